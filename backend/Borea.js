@@ -11,11 +11,19 @@ const metadataKey = 'metadata';
 const postData = true;
 const hostname = window.location.hostname;
 const GO_PORT = '8080';
+// The script is loaded from the backend as /script?token=<site tracking token>,
+// so the backend address and the site's token are read from our own src
+const scriptUrl = document.currentScript ? new URL(document.currentScript.src) : null;
+const trackingToken = scriptUrl ? scriptUrl.searchParams.get('token') : null;
 const postSessionDataRoute = 'postSession';
+const postEventRoute = 'event';
+const postBatchRoute = 'batch';
+const batchSize = 20; // queued items are flushed once this many are waiting
+const batchFlushInterval = 10000; // ms, queued items never wait longer than this
 
 Borea.init = function () {
     this[metadataKey] = {
-        token: null,
+        token: trackingToken,
         userId: null,
         sessionId: this.helpers.generateUUID(),
         // previousSessionId: this.getPreviousSessionId(),
@@ -24,11 +32,9 @@ Borea.init = function () {
         sessionDuration: null,
         userAgent: navigator.userAgent,
         // screenResolution: [`${window.screen.width}x${window.screen.height}`],
-        // location set via helper function below
-        location: null,
         language: navigator.language,
         referrer: this.helpers.getReferrer(document.referrer),
-        // userPath: [],
+        landingPage: window.location.href, // the backend reads the utm_ parameters from it
         // to store and get access to at anytime. these are props you want to be tracked with an event
         // customProperties: {},
     };
@@ -61,6 +67,8 @@ Borea.init = function () {
     };
     this.events = {};
     this.eventsArray = []; // TODO determine if adding event obj to array for event order is helpful
+    this.batchQueue = []; // session, event and page view payloads waiting to be sent to the batch route
+    this.currentPageView = null; // the page being viewed, sent again with its time on page when left
     this.enabledEventTypes = null;
     this.defaultEventCallback = null;
 
@@ -70,9 +78,8 @@ Borea.init = function () {
         this[metadataKey] = JSON.parse(metadata);
     }
 
-    // tmp, location via ip address
-    // this.metadata.location = this.helpers.fetchIPAddress();
     this.initMaintenanceEventListeners();
+    this.captureWindowLocationMetadata();
 };
 
 // idk if this is the right idea yet...
@@ -166,23 +173,15 @@ Borea.parseOptions = function () {
             this.updateLastActivityTime();
             this.setSessionDuration();
             this.storeMetadataInSessionStorage();
-            postData && this.postSessionData();
+            this.endPageView();
+            postData && this.flushBatchQueue(true);
         });
 
         window.addEventListener('resize', () => this.updateScreenResolution());
 
-        // TODO not working on testing GitHub page right now
-        // TODO multiple getting added
-        const eventsForTrackPaths = [
-            'popstate',
-            'pushstate',
-            'replacestate',
-        ];
-        eventsForTrackPaths.forEach(event => {
-            window.addEventListener(event, () => this.captureWindowLocationMetadata());
-        });
+        // Every navigation of a single page app goes through the history API or popstate
+        window.addEventListener('popstate', () => this.captureWindowLocationMetadata());
 
-        // Intercept and handle programmatic navigation
         const originalPushState = history.pushState;
         history.pushState = function () {
             originalPushState.apply(this, arguments);
@@ -213,23 +212,42 @@ Borea.parseOptions = function () {
     //     this[metadataKey].screenResolution.push(`${window.innerWidth}x${window.innerHeight}`);
     // };
 
-    // Borea.captureWindowLocationMetadata = function () {
-    //     const metadata = {
-    //         href: window.location.href,
-    //         protocol: window.location.protocol,
-    //         host: window.location.host,
-    //         hostname: window.location.hostname,
-    //         port: window.location.port,
-    //         pathname: window.location.pathname,
-    //         search: window.location.search,
-    //         hash: window.location.hash
-    //     };
-
-    //     this[metadataKey].userPath.push(metadata.pathname);
-    // };
+    // Runs on load and after every navigation. A new path or query starts a new page view,
+    // hash changes and replaceState calls that keep both stay on the current one.
+    Borea.captureWindowLocationMetadata = function () {
+        const path = window.location.pathname;
+        const query = window.location.search.replace(/^\?/, '');
+        const current = this.currentPageView;
+        if (current && current.path === path && current.query === query)
+            return;
+
+        this.endPageView();
+        this.currentPageView = {
+            viewId: this.helpers.generateUUID(),
+            sessionId: this[metadataKey].sessionId,
+            path,
+            title: document.title,
+            query,
+            timestamp: new Date(),
+            duration: null,
+        };
+        postData && this.enqueueBatchItem('pageview', Object.assign({}, this.currentPageView));
+    };
+
+    // Sends the current page view again, now with its time on page
+    Borea.endPageView = function () {
+        const view = this.currentPageView;
+        if (!view)
+            return;
+
+        this.currentPageView = null;
+        view.title = view.title || document.title; // SPAs often set the title after navigating
+        view.duration = new Date() - view.timestamp;
+        postData && this.enqueueBatchItem('pageview', view);
+    };
 
     Borea.postSessionData = function () {
-        const url = `http://192.168.86.23:${GO_PORT}/postSession`;
+        const url = this.helpers.getRouteUrl(postSessionDataRoute);
         console.log('Fetching URL:', url);
         
         fetch(url, {
@@ -252,7 +270,74 @@ Borea.parseOptions = function () {
                 console.error('Error:', error);
             });
     };
-    
+
+    // Record a named event (signup, purchase, ...) with optional properties.
+    // Events are queued and sent to the backend in batches.
+    Borea.trackEvent = function (name, properties = {}) {
+        if (typeof name !== 'string' || name.trim() === '')
+            return console.error('ERROR: trackEvent requires an event name');
+
+        this.enqueueBatchItem('event', {
+            sessionId: this[metadataKey].sessionId,
+            name: name.trim(),
+            timestamp: new Date(),
+            pageUrl: window.location.href,
+            properties,
+        });
+    };
+
+    Borea.enqueueBatchItem = function (type, data) {
+        this.batchQueue.push({ type, data });
+
+        if (this.batchQueue.length >= batchSize) {
+            this.flushBatchQueue(false);
+        } else if (this.batchTimer === undefined) {
+            this.batchTimer = setTimeout(() => this.flushBatchQueue(false), batchFlushInterval);
+        }
+    };
+
+    // Sends every queued item in one request. On unload the current session is
+    // appended and the batch goes out as a beacon so it survives the page closing.
+    Borea.flushBatchQueue = function (unloading) {
+        clearTimeout(this.batchTimer);
+        this.batchTimer = undefined;
+
+        if (unloading)
+            this.batchQueue.push({ type: 'session', data: this.getSessionData() });
+
+        if (this.batchQueue.length === 0)
+            return;
+
+        const url = this.helpers.getRouteUrl(postBatchRoute);
+        const body = JSON.stringify(this.batchQueue);
+        this.batchQueue = [];
+
+        // sendBeacon posts as text/plain, which avoids a CORS preflight; the backend sniffs the format
+        if (unloading && navigator.sendBeacon && navigator.sendBeacon(url, body))
+            return;
+
+        fetch(url, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body,
+            keepalive: true,
+        })
+            .then(response => {
+                if (!response.ok) {
+                    throw new Error(`HTTP error! status: ${response.status}`);
+                }
+                return response.json();
+            })
+            .then(results => {
+                results.filter(result => !result.success)
+                    .forEach(result => console.error('Batch item failed:', result.index, result.error));
+            })
+            .catch(error => {
+                console.error('Error:', error);
+            });
+    };
 }
 
 // Event Listener Management
@@ -469,54 +554,30 @@ Borea.parseOptions = function () {
         }
     };
 
+    Borea.helpers.getRouteUrl = function (route) {
+        const origin = scriptUrl ? scriptUrl.origin : `${window.location.protocol}//${hostname}:${GO_PORT}`;
+        const url = new URL(route, origin);
+        if (trackingToken)
+            url.searchParams.set('token', trackingToken);
+        return url.toString();
+    };
+
+    // The backend classifies the session's channel from the referrer URL, credentials are never sent
     Borea.helpers.getReferrer = function (referrer) {
         if (!referrer) {
-            return referrer;
+            return null;
         }
 
         try {
             const url = new URL(referrer);
-
-            const metadata = {
-                protocol: url.protocol,
-                hostname: url.hostname,
-                port: url.port, // || (url.protocol === 'https:' ? '443' : '80'),
-                pathname: url.pathname,
-                search: url.search,
-                hash: url.hash,
-                origin: url.origin,
-                host: url.host,
-                searchParams: Object.fromEntries(url.searchParams),
-                username: url.username,
-                password: url.password
-            };
-
-            return metadata;
+            url.username = '';
+            url.password = '';
+            return url.toString();
         } catch (error) {
             console.error('Invalid referrer URL:', error);
             return null;
         }
     };
-
-    Borea.helpers.fetchIPAddress = function () {
-        return fetch('https://api.ipify.org?format=json',
-            // {mode: 'no-cors'}
-        )
-            .then(response => {
-                if (!response.ok) {
-                    throw new Error(`HTTP error! status: ${response.status}`);
-                }
-                return response.json();
-            })
-            .then(data => {
-                console.log(data.ip)
-                return data.ip;
-            })
-            .catch(error => {
-                console.error('Error fetching IP address:', error);
-                throw error;
-            });
-    };
 }
 
 Borea.init();
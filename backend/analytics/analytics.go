@@ -0,0 +1,115 @@
+// Named analytics queries for the dashboard. The queries themselves are implemented by the
+// store, not the client; callers can only pick a query and pass validated Params.
+package analytics
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DateLayout   = "2006-01-02"
+	maxRangeDays = 366 * 2
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+// Maps the granularity query param to the date_trunc/interval unit
+var granularities = map[string]string{
+	"day":   "day",
+	"week":  "week",
+	"month": "month",
+}
+
+type Params struct {
+	From        time.Time
+	To          time.Time // Inclusive, the whole day is counted
+	Granularity string
+	Limit       int
+	Site        int // 0 means all sites
+}
+
+// ParseParams validates the query string of an analytics request.
+// from and to are required YYYY-MM-DD dates, granularity defaults to day and limit to 10.
+// site is an optional site id, without it all sites are counted.
+func ParseParams(query url.Values) (Params, error) {
+	var params Params
+	var err error
+
+	params.From, err = time.Parse(DateLayout, query.Get("from"))
+	if err != nil {
+		return params, fmt.Errorf("from must be a date formatted as YYYY-MM-DD")
+	}
+
+	params.To, err = time.Parse(DateLayout, query.Get("to"))
+	if err != nil {
+		return params, fmt.Errorf("to must be a date formatted as YYYY-MM-DD")
+	}
+
+	if params.To.Before(params.From) {
+		return params, fmt.Errorf("to must not be before from")
+	}
+
+	if params.To.Sub(params.From) > maxRangeDays*24*time.Hour {
+		return params, fmt.Errorf("date range must not exceed %d days", maxRangeDays)
+	}
+
+	params.Granularity = "day"
+	if g := query.Get("granularity"); g != "" {
+		unit, ok := granularities[g]
+		if !ok {
+			return params, fmt.Errorf("granularity must be one of day, week or month")
+		}
+		params.Granularity = unit
+	}
+
+	params.Limit = defaultLimit
+	if l := query.Get("limit"); l != "" {
+		params.Limit, err = strconv.Atoi(l)
+		if err != nil || params.Limit < 1 || params.Limit > maxLimit {
+			return params, fmt.Errorf("limit must be a number between 1 and %d", maxLimit)
+		}
+	}
+
+	if site := query.Get("site"); site != "" {
+		params.Site, err = strconv.Atoi(site)
+		if err != nil || params.Site < 1 {
+			return params, fmt.Errorf("site must be a site id")
+		}
+	}
+
+	return params, nil
+}
+
+// End is the exclusive upper bound of the range
+func (p Params) End() time.Time {
+	return p.To.AddDate(0, 0, 1)
+}
+
+// Truncate returns the start of the granularity bucket containing t, weeks start on Monday
+func (p Params) Truncate(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	switch p.Granularity {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// Next returns the start of the bucket after the one starting at bucket
+func (p Params) Next(bucket time.Time) time.Time {
+	switch p.Granularity {
+	case "week":
+		return bucket.AddDate(0, 0, 7)
+	case "month":
+		return bucket.AddDate(0, 1, 0)
+	default:
+		return bucket.AddDate(0, 0, 1)
+	}
+}
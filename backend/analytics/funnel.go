@@ -0,0 +1,128 @@
+package analytics
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"Borea/backend/models"
+)
+
+// Funnel step types, a step matches page views by path or events by name
+const (
+	StepPageview = "pageview"
+	StepEvent    = "event"
+)
+
+const (
+	minFunnelSteps = 2
+	maxFunnelSteps = 10
+)
+
+// ParamError is a client facing message about an invalid query param
+type ParamError string
+
+func (e ParamError) Error() string {
+	return string(e)
+}
+
+// FunnelStep matches Match against the path of a page view or the name of an event,
+// a * in Match stands for any characters
+type FunnelStep struct {
+	Type  string
+	Match string
+}
+
+// Funnel is an ordered list of steps a session goes through. A session reaches a step when it
+// matches it after reaching the one before. Every step has to happen in the range, so the
+// numbers of a range that is over don't change.
+type Funnel struct {
+	Params
+	Steps  []FunnelStep
+	Within time.Duration // Longest time between two steps, 0 for no limit
+}
+
+// ParseFunnel reads the steps of a funnel request on top of its Params. Every step is a
+// step=<type>:<match> param, in funnel order, e.g. step=pageview:/pricing&step=event:signup.
+// within is the optional longest time between two steps in seconds.
+func ParseFunnel(query url.Values, params Params) (Funnel, error) {
+	funnel := Funnel{Params: params}
+
+	for _, raw := range query["step"] {
+		stepType, match, ok := strings.Cut(raw, ":")
+		if !ok || (stepType != StepPageview && stepType != StepEvent) || match == "" {
+			return funnel, ParamError(fmt.Sprintf("step %q must be pageview:<path> or event:<name>", raw))
+		}
+		funnel.Steps = append(funnel.Steps, FunnelStep{Type: stepType, Match: match})
+	}
+
+	if len(funnel.Steps) < minFunnelSteps || len(funnel.Steps) > maxFunnelSteps {
+		return funnel, ParamError(fmt.Sprintf("a funnel needs between %d and %d steps", minFunnelSteps, maxFunnelSteps))
+	}
+
+	if within := query.Get("within"); within != "" {
+		seconds, err := strconv.Atoi(within)
+		if err != nil || seconds < 1 {
+			return funnel, ParamError("within must be a positive number of seconds")
+		}
+		funnel.Within = time.Duration(seconds) * time.Second
+	}
+
+	return funnel, nil
+}
+
+func (s FunnelStep) String() string {
+	return s.Type + ":" + s.Match
+}
+
+// Matches reports whether a page path or event name matches the step, for stores that match in Go
+func (s FunnelStep) Matches(value string) bool {
+	parts := strings.Split(s.Match, "*")
+	if len(parts) == 1 {
+		return value == s.Match
+	}
+
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// LikePattern returns Match as a pattern for SQL LIKE with the default \ escape
+func (s FunnelStep) LikePattern() string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s.Match)
+	return strings.ReplaceAll(escaped, "*", "%")
+}
+
+// FunnelSteps turns the sessions that reached each step into the funnel report
+func FunnelSteps(f Funnel, reached []int) []models.Funnel_step {
+	steps := make([]models.Funnel_step, len(f.Steps))
+
+	for i, step := range f.Steps {
+		steps[i] = models.Funnel_step{Step: step.String(), Sessions: reached[i]}
+
+		if reached[0] > 0 {
+			steps[i].Conversion = float64(reached[i]) / float64(reached[0])
+		}
+		if i > 0 {
+			steps[i].DropOff = reached[i-1] - reached[i]
+			if reached[i-1] > 0 {
+				steps[i].DropOffRate = float64(steps[i].DropOff) / float64(reached[i-1])
+			}
+		}
+	}
+
+	return steps
+}
@@ -0,0 +1,38 @@
+package analytics
+
+import (
+	"Borea/backend/models"
+)
+
+// RetentionCohorts lays out the retention report of p, with a cohort for every period of the range.
+// users counts the users first seen in each cohort and active the users of a cohort with a session
+// in a period, both keyed by the first day of the period as YYYY-MM-DD.
+func RetentionCohorts(p Params, users map[string]int, active map[string]map[string]int) []models.Retention_cohort {
+	cohorts := make([]models.Retention_cohort, 0)
+	end := p.End()
+
+	for cohort := p.Truncate(p.From); cohort.Before(end); cohort = p.Next(cohort) {
+		key := cohort.Format(DateLayout)
+		row := models.Retention_cohort{
+			Cohort:    key,
+			Users:     users[key],
+			Active:    make([]int, 0),
+			Retention: make([]float64, 0),
+		}
+
+		for period := cohort; period.Before(end); period = p.Next(period) {
+			count := active[key][period.Format(DateLayout)]
+			row.Active = append(row.Active, count)
+
+			share := 0.0
+			if row.Users > 0 {
+				share = float64(count) / float64(row.Users)
+			}
+			row.Retention = append(row.Retention, share)
+		}
+
+		cohorts = append(cohorts, row)
+	}
+
+	return cohorts
+}
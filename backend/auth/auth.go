@@ -0,0 +1,183 @@
+// Authentication for the data endpoints. Requests carry either the dashboard's JWT
+// (signed with SERVER_KEY) or a scoped API key from the store.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"Borea/backend/logging"
+	"Borea/backend/models"
+	"Borea/backend/store"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+
+	// ScopeLogin only looks up admin users, for the dashboard's login route before anyone is
+	// logged in. Only JWTs carry it, no API key or dashboard session can be given it.
+	ScopeLogin = "login"
+
+	// APIKeyPrefix tells API keys apart from JWTs in the Authorization header
+	APIKeyPrefix = "borea_"
+)
+
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type contextKey struct{}
+
+// FromContext returns the principal that Require authenticated for this request.
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
+
+// Authenticator checks the credentials of requests, API keys are looked up in keys
+type Authenticator struct {
+	keys store.APIKeyStore
+}
+
+func NewAuthenticator(keys store.APIKeyStore) *Authenticator {
+	return &Authenticator{keys: keys}
+}
+
+// Require wraps a handler so it only runs for requests authenticated with the given scope.
+// Missing or invalid credentials get a 401, valid credentials without the scope get a 403.
+// CORS preflight requests carry no credentials and are passed through.
+func (a *Authenticator) Require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrUnauthenticated) {
+				logging.FromContext(r.Context()).Error("authenticating request", "err", err)
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="borea"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !principal.HasScope(scope) {
+			http.Error(w, "Forbidden: missing scope "+scope, http.StatusForbidden)
+			return
+		}
+
+		logging.With(r.Context(), "principal", principal.Name)
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, principal)))
+	}
+}
+
+// Authenticate resolves the credentials of a request from "Authorization: Bearer <token>" or "X-API-Key: <key>".
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	token := r.Header.Get("X-API-Key")
+	if token == "" {
+		header := r.Header.Get("Authorization")
+		scheme, value, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, ErrUnauthenticated
+		}
+		token = strings.TrimSpace(value)
+	}
+
+	if token == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return a.lookupAPIKey(r.Context(), token)
+	}
+
+	return verifyJWT(token)
+}
+
+// verifyJWT accepts HS256 tokens signed with SERVER_KEY, as issued by the dashboard's login route.
+// Dashboard users are admins and can read and write. A token with {"scope": "login"} and no user
+// is the dashboard checking a login, it only gets ScopeLogin.
+func verifyJWT(token string) (Principal, error) {
+	SERVER_KEY := os.Getenv("SERVER_KEY")
+	if SERVER_KEY == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(SERVER_KEY), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	username, _ := claims["username"].(string)
+	if username == "" {
+		if scope, _ := claims["scope"].(string); scope == ScopeLogin {
+			return Principal{Name: "dashboard login", Scopes: []string{ScopeLogin}}, nil
+		}
+		return Principal{}, ErrUnauthenticated
+	}
+
+	return Principal{Name: username, Scopes: []string{ScopeRead, ScopeWrite}}, nil
+}
+
+func (a *Authenticator) lookupAPIKey(ctx context.Context, key string) (Principal, error) {
+	stored, err := a.keys.APIKey(ctx, HashAPIKey(key))
+	if errors.Is(err, store.ErrNotFound) {
+		return Principal{}, ErrUnauthenticated
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{Name: stored.Name, Scopes: stored.Scopes}, nil
+}
+
+// HashAPIKey is how keys are stored, the key itself is only shown once on creation.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey stores a new key with the given scopes and returns it.
+func CreateAPIKey(ctx context.Context, keys store.APIKeyStore, name string, scopes []string) (string, error) {
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return "", fmt.Errorf("unknown scope %q, expected %s or %s", scope, ScopeRead, ScopeWrite)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generating api key: %w", err)
+	}
+	key := APIKeyPrefix + hex.EncodeToString(secret)
+
+	err := keys.CreateAPIKey(ctx, models.Api_key{Name: name, KeyHash: HashAPIKey(key), Scopes: scopes})
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,124 @@
+// Package bots decides whether a session beacon came from a bot rather than a person, and
+// applies the per-site policy for what happens to bot sessions at ingestion.
+package bots
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"time"
+
+	"Borea/backend/models"
+	"Borea/backend/useragent"
+)
+
+// What a site does with sessions classified as bots
+const (
+	PolicyDrop = "drop" // never stored
+	PolicyFlag = "flag" // stored with is_bot set, analytics leave them out
+	PolicyKeep = "keep" // stored and counted like any other session
+)
+
+func ValidPolicy(policy string) bool {
+	return policy == PolicyDrop || policy == PolicyFlag || policy == PolicyKeep
+}
+
+// clockSkew is how far in the future a client clock may be before its timestamps are impossible
+const clockSkew = 5 * time.Minute
+
+//go:embed crawlers.txt
+var crawlerList string
+
+var crawlers = parseCrawlers(crawlerList)
+
+func parseCrawlers(list string) []string {
+	patterns := make([]string, 0)
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// Verdict explains a classification, Reasons is empty for a person
+type Verdict struct {
+	Bot     bool
+	Reasons []string
+}
+
+// Classify combines three kinds of evidence. A known bot user agent or a timestamp no browser
+// could send is enough on its own. The behavioural hints a real browser rarely shows are
+// only trusted when at least two of them agree, so one odd beacon does not flag a visitor.
+func Classify(session models.Session, now time.Time) Verdict {
+	var verdict Verdict
+	conclusive := func(reason string) {
+		verdict.Bot = true
+		verdict.Reasons = append(verdict.Reasons, reason)
+	}
+
+	if session.UserAgent != nil {
+		if session.Client.Device != nil && *session.Client.Device == useragent.DeviceBot {
+			conclusive("user agent is a known bot")
+		} else if pattern := matchCrawler(*session.UserAgent); pattern != "" {
+			conclusive(fmt.Sprintf("user agent matches %q", pattern))
+		}
+	}
+
+	if session.LastActivityTime != nil && session.LastActivityTime.After(now.Add(clockSkew)) {
+		conclusive("last activity is in the future")
+	}
+	if session.StartTime != nil && session.StartTime.After(now.Add(clockSkew)) {
+		conclusive("start time is in the future")
+	}
+	if session.StartTime != nil && session.LastActivityTime != nil && session.StartTime.After(*session.LastActivityTime) {
+		conclusive("session starts after its last activity")
+	}
+	if session.SessionDuration != nil && *session.SessionDuration < 0 {
+		conclusive("negative session duration")
+	}
+
+	hints := make([]string, 0)
+	if session.UserAgent == nil || strings.TrimSpace(*session.UserAgent) == "" {
+		hints = append(hints, "no user agent")
+	}
+	if session.Language == nil || *session.Language == "" {
+		hints = append(hints, "no language")
+	}
+	if session.SessionDuration != nil && *session.SessionDuration == 0 {
+		hints = append(hints, "zero duration")
+	}
+	if len(hints) >= 2 {
+		verdict.Bot = true
+		verdict.Reasons = append(verdict.Reasons, hints...)
+	}
+
+	return verdict
+}
+
+func matchCrawler(userAgent string) string {
+	userAgent = strings.ToLower(userAgent)
+	for _, pattern := range crawlers {
+		if strings.Contains(userAgent, pattern) {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// Admit classifies the session and applies the site's policy. It returns false when the
+// session must not be stored, and flags it when the policy says so. An unknown policy
+// is treated as PolicyFlag.
+func Admit(session *models.Session, policy string, now time.Time) bool {
+	if policy == PolicyKeep || !Classify(*session, now).Bot {
+		return true
+	}
+
+	if policy == PolicyDrop {
+		return false
+	}
+
+	session.IsBot = true
+	return true
+}
@@ -0,0 +1,225 @@
+// Package channels attributes a session to the acquisition channel it came from, using the
+// utm_ parameters of its landing page and the host of its referrer.
+package channels
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/logging"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+const (
+	Search   = "search"
+	Social   = "social"
+	Email    = "email"
+	Direct   = "direct"
+	Internal = "internal"
+	Paid     = "paid"
+	Referral = "referral" // any other site linking in, when no rule matched
+)
+
+// The session fields a rule can match on
+const (
+	FieldReferrerHost = "referrer_host"
+	FieldSource       = "utm_source"
+	FieldMedium       = "utm_medium"
+	FieldCampaign     = "utm_campaign"
+)
+
+func ValidChannel(channel string) bool {
+	switch channel {
+	case Search, Social, Email, Direct, Internal, Paid, Referral:
+		return true
+	}
+	return false
+}
+
+func ValidField(field string) bool {
+	switch field {
+	case FieldReferrerHost, FieldSource, FieldMedium, FieldCampaign:
+		return true
+	}
+	return false
+}
+
+// ParseCampaign reads the utm_ parameters of a landing page URL, an unparsable URL has none
+func ParseCampaign(landingPage string) models.Campaign {
+	parsed, err := url.Parse(landingPage)
+	if err != nil {
+		return models.Campaign{}
+	}
+
+	query := parsed.Query()
+	param := func(name string) *string {
+		value := strings.TrimSpace(query.Get(name))
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+
+	return models.Campaign{
+		Source:  param("utm_source"),
+		Medium:  param("utm_medium"),
+		Name:    param("utm_campaign"),
+		Term:    param("utm_term"),
+		Content: param("utm_content"),
+	}
+}
+
+// rule is a models.Channel_rule with its pattern compiled
+type rule struct {
+	channel string
+	field   string
+	pattern *regexp.Regexp
+}
+
+func compile(rules []models.Channel_rule) []rule {
+	compiled := make([]rule, 0, len(rules))
+	for _, r := range rules {
+		pattern, err := regexp.Compile("(?i)" + r.Pattern)
+		if err != nil {
+			// CreateRule refuses these, so the rule was put in the table by hand
+			slog.Warn("skipping channel rule with an invalid pattern", "rule", r.ID, "err", err)
+			continue
+		}
+		compiled = append(compiled, rule{channel: r.Channel, field: r.Field, pattern: pattern})
+	}
+	return compiled
+}
+
+// Classify attributes a session of site to a channel. Rules run in the order given and the
+// first match wins. A referrer from the site itself is internal unless the landing page carries
+// utm_ parameters; without a match, a session with no referrer and no campaign is direct and
+// everything else is a referral.
+func Classify(rules []models.Channel_rule, session models.Session, site models.Site) string {
+	return classify(compile(rules), session, site)
+}
+
+func classify(rules []rule, session models.Session, site models.Site) string {
+	campaign := session.Campaign
+	hasCampaign := campaign.Source != nil || campaign.Medium != nil || campaign.Name != nil
+
+	referrerHost := ""
+	if session.Referrer != nil {
+		referrerHost = host(*session.Referrer)
+	}
+
+	if !hasCampaign && referrerHost != "" && isInternal(referrerHost, session, site) {
+		return Internal
+	}
+
+	values := map[string]string{
+		FieldReferrerHost: referrerHost,
+		FieldSource:       value(campaign.Source),
+		FieldMedium:       value(campaign.Medium),
+		FieldCampaign:     value(campaign.Name),
+	}
+
+	for _, r := range rules {
+		if v := values[r.field]; v != "" && r.pattern.MatchString(v) {
+			return r.channel
+		}
+	}
+
+	if referrerHost == "" && !hasCampaign {
+		return Direct
+	}
+	return Referral
+}
+
+// isInternal reports whether the referrer host is the landing page's or one of the site's origins
+func isInternal(referrerHost string, session models.Session, site models.Site) bool {
+	if session.LandingPage != nil && host(*session.LandingPage) == referrerHost {
+		return true
+	}
+
+	for _, origin := range site.AllowedOrigins {
+		if host(helper.NormalizeOrigin(origin)) == referrerHost {
+			return true
+		}
+	}
+	return false
+}
+
+// host returns the lowercased host of a URL without port or a leading "www."
+func host(rawURL string) string {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// reloadInterval bounds how long a rule edited outside this process, e.g. with SQL, takes to apply
+const reloadInterval = time.Minute
+
+// Classifier classifies sessions with the rules of a store. Rules are cached and reloaded
+// every reloadInterval, or on the next session after Invalidate. It is safe for concurrent use.
+type Classifier struct {
+	store store.ChannelRuleStore
+
+	mu       sync.Mutex
+	rules    []rule
+	loadedAt time.Time
+}
+
+func NewClassifier(rules store.ChannelRuleStore) *Classifier {
+	return &Classifier{store: rules}
+}
+
+// Invalidate makes the next Apply reload the rules, call it after changing them
+func (c *Classifier) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.loadedAt = time.Time{}
+}
+
+// Apply sets the campaign of the session from its landing page and classifies its channel.
+// When the rules cannot be loaded the last loaded rules are used, ingestion never fails on them.
+func (c *Classifier) Apply(ctx context.Context, session *models.Session, site models.Site) {
+	if session.LandingPage != nil {
+		session.Campaign = ParseCampaign(*session.LandingPage)
+	}
+
+	channel := classify(c.current(ctx), *session, site)
+	session.Channel = &channel
+}
+
+func (c *Classifier) current(ctx context.Context) []rule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.loadedAt) < reloadInterval {
+		return c.rules
+	}
+
+	// A failed load is retried after the interval too, so an outage does not cost a query per session
+	c.loadedAt = time.Now()
+
+	rules, err := c.store.ChannelRules(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("loading channel rules", "err", err)
+		return c.rules
+	}
+
+	c.rules = compile(rules)
+	return c.rules
+}
@@ -0,0 +1,74 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+var ErrInvalidRule = errors.New("invalid channel rule")
+
+// DefaultRules are what a new install classifies with. Campaign tags run before referrer hosts,
+// since a tagged link says more about the visit than the site it was clicked on.
+func DefaultRules() []models.Channel_rule {
+	return []models.Channel_rule{
+		{Channel: Paid, Field: FieldMedium, Pattern: `^(cpc|ppc|cpm|cpv|cpa|paid.*|display|banner|retargeting)$`, Priority: 10},
+		{Channel: Email, Field: FieldMedium, Pattern: `^e[-_ ]?mail$`, Priority: 20},
+		{Channel: Email, Field: FieldSource, Pattern: `^(newsletter|e[-_ ]?mail)$`, Priority: 30},
+		{Channel: Social, Field: FieldMedium, Pattern: `^(social|social[-_ ]?(network|media)|sm)$`, Priority: 40},
+		{Channel: Search, Field: FieldMedium, Pattern: `^organic$`, Priority: 50},
+		{Channel: Paid, Field: FieldReferrerHost, Pattern: `^(googleads\.g\.doubleclick\.net|googleadservices\.com)$`, Priority: 60},
+		{Channel: Email, Field: FieldReferrerHost, Pattern: `^(mail\.google\.com|outlook\.(live|office)\.com|mail\.yahoo\.com|mail\.proton\.me)$`, Priority: 70},
+		{Channel: Search, Field: FieldReferrerHost, Pattern: `(^|\.)(google|bing|duckduckgo|yahoo|baidu|yandex|ecosia|startpage|qwant|naver)\.`, Priority: 80},
+		{Channel: Search, Field: FieldReferrerHost, Pattern: `^search\.brave\.com$`, Priority: 80},
+		{Channel: Social, Field: FieldReferrerHost, Pattern: `(^|\.)(facebook\.com|fb\.me|instagram\.com|linkedin\.com|lnkd\.in|reddit\.com|pinterest\.com|tiktok\.com|youtube\.com|t\.co|x\.com|twitter\.com|threads\.net|bsky\.app|mastodon\.social|news\.ycombinator\.com)$`, Priority: 90},
+	}
+}
+
+// Validate checks a rule before it is stored, the returned error wraps ErrInvalidRule
+func Validate(rule models.Channel_rule) error {
+	if !ValidChannel(rule.Channel) {
+		return fmt.Errorf("%w: unknown channel %q", ErrInvalidRule, rule.Channel)
+	}
+	if !ValidField(rule.Field) {
+		return fmt.Errorf("%w: unknown field %q", ErrInvalidRule, rule.Field)
+	}
+	if rule.Pattern == "" {
+		return fmt.Errorf("%w: pattern is required", ErrInvalidRule)
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	return nil
+}
+
+// CreateRule validates and stores a rule
+func CreateRule(ctx context.Context, rules store.ChannelRuleStore, rule models.Channel_rule) (models.Channel_rule, error) {
+	if err := Validate(rule); err != nil {
+		return models.Channel_rule{}, err
+	}
+	return rules.CreateChannelRule(ctx, rule)
+}
+
+// EnsureDefaults stores DefaultRules when there are no rules at all, so a new install
+// classifies out of the box. Deleting every rule brings the defaults back on the next start.
+func EnsureDefaults(ctx context.Context, rules store.ChannelRuleStore) error {
+	existing, err := rules.ChannelRules(ctx)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+
+	for _, rule := range DefaultRules() {
+		if _, err := rules.CreateChannelRule(ctx, rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
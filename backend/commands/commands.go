@@ -0,0 +1,276 @@
+// Package commands runs the backend's one-off admin tasks, given as arguments to the binary.
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/auth"
+	"Borea/backend/db"
+	"Borea/backend/export"
+	"Borea/backend/rollup"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"Borea/backend/useragent"
+)
+
+// Run handles one-off admin tasks, e.g. `./main create-api-key -name ci -scopes read`
+// or `./main create-site -name blog -origins https://blog.example.com` or `./main migrate status`
+// or `./main backfill-user-agents` or `./main set-bot-policy -site 1 -policy drop` or `./main roll-up -days 90`
+// or `./main export -dir /data/borea`
+//
+// Every command but migrate refuses a schema newer than the binary, as the server does. migrate
+// is how such a schema gets rolled back.
+func Run(s store.Store, args []string) error {
+	if args[0] != "migrate" {
+		if err := db.CheckVersion(); err != nil {
+			return err
+		}
+	}
+
+	switch args[0] {
+	case "create-api-key":
+		return createAPIKeyCommand(s, args[1:])
+	case "create-site":
+		return createSiteCommand(s, args[1:])
+	case "set-bot-policy":
+		return setBotPolicyCommand(s, args[1:])
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "backfill-user-agents":
+		return backfillUserAgentsCommand(s, args[1:])
+	case "roll-up":
+		return rollUpCommand(s, args[1:])
+	case "export":
+		return exportCommand(s, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func createAPIKeyCommand(s store.Store, args []string) error {
+	flags := flag.NewFlagSet("create-api-key", flag.ContinueOnError)
+	name := flags.String("name", "", "who or what the key is for")
+	scopes := flags.String("scopes", auth.ScopeRead, "comma separated scopes: read, write")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+
+	key, err := auth.CreateAPIKey(context.Background(), s, *name, strings.Split(*scopes, ","))
+	if err != nil {
+		return err
+	}
+
+	// The key is only stored hashed, so this is the only time it can be shown
+	fmt.Println(key)
+	return nil
+}
+
+func createSiteCommand(s store.Store, args []string) error {
+	flags := flag.NewFlagSet("create-site", flag.ContinueOnError)
+	name := flags.String("name", "", "display name of the site")
+	origins := flags.String("origins", "", "comma separated origins allowed to track, e.g. https://example.com")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" || *origins == "" {
+		return fmt.Errorf("-name and -origins are required")
+	}
+
+	site, err := sites.Create(context.Background(), s, *name, strings.Split(*origins, ","))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("site %d created, tracking token: %s\n", site.ID, site.TrackingToken)
+	return nil
+}
+
+func setBotPolicyCommand(s store.Store, args []string) error {
+	flags := flag.NewFlagSet("set-bot-policy", flag.ContinueOnError)
+	siteID := flags.Int("site", 0, "id of the site, see create-site")
+	policy := flags.String("policy", "", "what to do with bot sessions: drop, flag or keep")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *siteID == 0 || *policy == "" {
+		return fmt.Errorf("-site and -policy are required")
+	}
+
+	if err := sites.SetBotPolicy(context.Background(), s, *siteID, *policy); err != nil {
+		return err
+	}
+
+	fmt.Printf("site %d now uses bot policy %s\n", *siteID, *policy)
+	return nil
+}
+
+func backfillUserAgentsCommand(s store.Store, args []string) error {
+	flags := flag.NewFlagSet("backfill-user-agents", flag.ContinueOnError)
+	batchSize := flags.Int("batch-size", 500, "distinct user agents to read per query")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *batchSize < 1 {
+		return fmt.Errorf("-batch-size must be at least 1")
+	}
+
+	updated, err := useragent.Backfill(context.Background(), s, *batchSize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("parsed the user agent of %d sessions\n", updated)
+	return nil
+}
+
+func rollUpCommand(s store.Store, args []string) error {
+	config, err := rollup.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet("roll-up", flag.ContinueOnError)
+	days := flags.Int("days", config.RetentionDays, "whole days before today to keep raw, defaults to DATA_RETENTION_DAYS")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *days < 1 {
+		return fmt.Errorf("-days or DATA_RETENTION_DAYS is required")
+	}
+	config.RetentionDays = *days
+
+	job := rollup.NewJob(s, config)
+	_, dropped, err := job.Partition(context.Background(), time.Now())
+	for _, name := range dropped {
+		fmt.Printf("rolled up and dropped session partition %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	results, err := job.Run(context.Background(), time.Now())
+	for _, result := range results {
+		fmt.Printf("rolled up %s, deleted %d sessions, %d page views and %d events\n",
+			result.Day, result.Sessions, result.PageViews, result.Events)
+	}
+	return err
+}
+
+// exportCommand writes one file per dataset to <dir>/<dataset>/<from>_<to>[_site-N].<format>, by
+// default for yesterday so a daily cron job keeps a data lake up to date
+func exportCommand(s store.Store, args []string) error {
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(analytics.DateLayout)
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory to write the files to")
+	from := flags.String("from", yesterday, "first day to export, YYYY-MM-DD")
+	to := flags.String("to", "", "last day to export, YYYY-MM-DD, defaults to -from")
+	site := flags.Int("site", 0, "site id, all sites without it")
+	format := flags.String("format", export.FormatParquet, "csv, ndjson or parquet")
+	datasets := flags.String("datasets", "sessions,events,pageviews", "comma separated datasets to export")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if *to == "" {
+		*to = *from
+	}
+
+	query := url.Values{"from": {*from}, "to": {*to}}
+	suffix := ""
+	if *site != 0 {
+		query.Set("site", strconv.Itoa(*site))
+		suffix = fmt.Sprintf("_site-%d", *site)
+	}
+	params, err := analytics.ParseParams(query)
+	if err != nil {
+		return err
+	}
+	if _, err := export.Format(*format); err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(*datasets, ",") {
+		dataset, ok := export.Datasets[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown dataset %q", name)
+		}
+
+		if err := os.MkdirAll(filepath.Join(*dir, dataset.Name), 0o755); err != nil {
+			return err
+		}
+		path := filepath.Join(*dir, dataset.Name, fmt.Sprintf("%s_%s%s.%s", *from, *to, suffix, *format))
+		if err := export.ToFile(context.Background(), s, dataset, params, *format, path); err != nil {
+			return fmt.Errorf("exporting %s: %w", dataset.Name, err)
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+
+	return nil
+}
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [-steps N] | status")
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", applied)
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+
+		reverted, err := db.MigrateDown(*steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("reverted %d migrations\n", reverted)
+	case "status":
+		current, err := db.CurrentVersion()
+		if err != nil {
+			return err
+		}
+
+		latest, err := db.LatestVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("schema version %d, latest %d\n", current, latest)
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q", args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package db
+
+// Schema migrations are embedded in the binary and tracked in schema_migrations.
+// Files are named NNNN_name.up.sql / NNNN_name.down.sql and applied in version order.
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key so concurrent backends never migrate at the same time
+const migrationLockKey = 7_365_240_011
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		base, direction, ok := strings.Cut(strings.TrimSuffix(fileName, ".sql"), ".")
+		versionText, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionText)
+		if !ok || !found || err != nil || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", fileName)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join("migrations", fileName))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", fileName, err)
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// LatestVersion is the newest schema version this binary knows about.
+func LatestVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// CurrentVersion returns the newest applied version, 0 for an empty database.
+func CurrentVersion() (int, error) {
+	return currentVersion(context.Background(), DB)
+}
+
+// CheckVersion refuses a schema newer than the binary, e.g. after rolling back to an older release.
+func CheckVersion() error {
+	current, err := CurrentVersion()
+	if err != nil {
+		return err
+	}
+
+	latest, err := LatestVersion()
+	if err != nil {
+		return err
+	}
+
+	if current > latest {
+		return errSchemaTooNew(current, latest)
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the applied schema version and how many embedded migrations are not
+// applied yet. Like CheckVersion it refuses a schema newer than the binary.
+func PendingMigrations(ctx context.Context, database *sql.DB) (current int, pending int, err error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	current, err = currentVersion(ctx, database)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	latest := 0
+	for _, m := range migrations {
+		if m.Version > current {
+			pending++
+		}
+		latest = m.Version
+	}
+
+	if current > latest {
+		return current, 0, errSchemaTooNew(current, latest)
+	}
+
+	return current, pending, nil
+}
+
+func errSchemaTooNew(current, latest int) error {
+	return fmt.Errorf("database schema version %d is newer than this binary supports (%d), upgrade Borea", current, latest)
+}
+
+// MigrateUp applies every pending migration and returns how many ran.
+func MigrateUp() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if len(migrations) > 0 && current > migrations[len(migrations)-1].Version {
+			return errSchemaTooNew(current, migrations[len(migrations)-1].Version)
+		}
+
+		for _, m := range migrations {
+			if m.Version <= current {
+				continue
+			}
+
+			err := runMigration(ctx, conn, m.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("applying migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// MigrateDown reverts the newest steps applied migrations and returns how many ran.
+func MigrateDown(steps int) (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+
+	byVersion := make(map[int]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	reverted := 0
+	err = withMigrationLock(func(ctx context.Context, conn *sql.Conn) error {
+		rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations ORDER BY version DESC LIMIT $1", steps)
+		if err != nil {
+			return fmt.Errorf("querying applied migrations: %w", err)
+		}
+
+		var versions []int
+		for rows.Next() {
+			var version int
+			if err := rows.Scan(&version); err != nil {
+				rows.Close()
+				return fmt.Errorf("scanning applied migrations: %w", err)
+			}
+			versions = append(versions, version)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("querying applied migrations: %w", err)
+		}
+
+		for _, version := range versions {
+			m, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("database schema version %d is unknown to this binary, cannot revert it", version)
+			}
+
+			err := runMigration(ctx, conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %04d_%s: %w", m.Version, m.Name, err)
+			}
+			reverted++
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func currentVersion(ctx context.Context, q queryRower) (int, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("checking schema_migrations: %w", err)
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = q.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("querying schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// withMigrationLock runs fn on a single connection holding a session advisory lock
+func withMigrationLock(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	if DB == nil {
+		return fmt.Errorf("database connection not initialized")
+	}
+
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	_, err = conn.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+
+	return fn(ctx, conn)
+}
+
+// runMigration runs a migration script and its bookkeeping statement in one transaction
+func runMigration(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Without args lib/pq uses the simple query protocol, which allows several statements
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
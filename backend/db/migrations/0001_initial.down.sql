@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS admin_users;
+DROP TABLE IF EXISTS unique_users;
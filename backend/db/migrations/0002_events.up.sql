@@ -0,0 +1,13 @@
+-- Create events table for named events that happen inside a session
+-- No foreign key on session_id: events are usually posted before the session beacon on unload
+CREATE TABLE IF NOT EXISTS events (
+    id SERIAL PRIMARY KEY,
+    session_id UUID NOT NULL,                -- Matches sessions.session_id
+    name TEXT NOT NULL,                      -- Event name, e.g. "signup"
+    event_time TIMESTAMP DEFAULT NOW(),      -- Matches timestamp
+    page_url TEXT,                           -- Matches pageUrl
+    properties JSONB NOT NULL DEFAULT '{}'   -- Matches properties, arbitrary key/values
+);
+
+CREATE INDEX IF NOT EXISTS events_session_id_idx ON events (session_id);
+CREATE INDEX IF NOT EXISTS events_name_time_idx ON events (name, event_time);
@@ -0,0 +1,9 @@
+-- Create api_keys table for scoped access to the backend's data endpoints
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,                     -- Who or what the key was issued to
+    key_hash TEXT NOT NULL UNIQUE,          -- sha256 of the key, the key itself is never stored
+    scopes TEXT[] NOT NULL DEFAULT '{}',    -- "read" and/or "write"
+    created_at TIMESTAMP DEFAULT NOW(),
+    revoked_at TIMESTAMP DEFAULT NULL
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS sessions_site_activity_idx;
+ALTER TABLE events DROP COLUMN IF EXISTS site_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS site_id;
+DROP TABLE IF EXISTS sites;
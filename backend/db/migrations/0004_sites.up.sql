@@ -0,0 +1,14 @@
+-- Create sites table, one row per tracked website
+CREATE TABLE IF NOT EXISTS sites (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    tracking_token TEXT NOT NULL UNIQUE,        -- Passed as ?token= by the tracking script
+    allowed_origins TEXT[] NOT NULL DEFAULT '{}', -- e.g. https://example.com, no trailing slash
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Resolved from the tracking token or origin
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS site_id INTEGER REFERENCES sites(id);
+ALTER TABLE events ADD COLUMN IF NOT EXISTS site_id INTEGER REFERENCES sites(id);
+
+CREATE INDEX IF NOT EXISTS sessions_site_activity_idx ON sessions (site_id, last_activity_time);
@@ -0,0 +1,12 @@
+-- Concurrent beacons used to insert the same session twice, keep the row with the latest activity
+DELETE FROM sessions
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY last_activity_time DESC NULLS LAST, id DESC) AS position
+        FROM sessions
+    ) ranked
+    WHERE position > 1
+);
+
+-- Session writes upsert on this with INSERT ... ON CONFLICT (session_id)
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_session_id_key ON sessions (session_id);
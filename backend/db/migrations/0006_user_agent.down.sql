@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS sessions_unparsed_user_agent_idx;
+ALTER TABLE sessions DROP COLUMN IF EXISTS device_type;
+ALTER TABLE sessions DROP COLUMN IF EXISTS os_version;
+ALTER TABLE sessions DROP COLUMN IF EXISTS os;
+ALTER TABLE sessions DROP COLUMN IF EXISTS browser_version;
+ALTER TABLE sessions DROP COLUMN IF EXISTS browser;
@@ -0,0 +1,10 @@
+-- Parsed from user_agent by the backend, filled for older rows by the backfill-user-agents command
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS browser TEXT;          -- e.g. "Mobile Safari"
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS browser_version TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS os TEXT;               -- e.g. "iOS"
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS os_version TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_type TEXT;      -- desktop, mobile, tablet or bot
+
+-- Lets the backfill page through the user agents it has not parsed yet
+CREATE INDEX IF NOT EXISTS sessions_unparsed_user_agent_idx ON sessions (user_agent)
+WHERE device_type IS NULL AND user_agent IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS city;
+ALTER TABLE sessions DROP COLUMN IF EXISTS region;
+ALTER TABLE sessions DROP COLUMN IF EXISTS country;
@@ -0,0 +1,4 @@
+-- Looked up from the client IP by the backend when GEOIP_DATABASE is set, the IP is never stored
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS country TEXT;    -- ISO 3166-1 code, e.g. "US"
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS region TEXT;     -- e.g. "California"
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS city TEXT;
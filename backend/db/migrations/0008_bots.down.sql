@@ -0,0 +1,2 @@
+ALTER TABLE sites DROP COLUMN IF EXISTS bot_policy;
+ALTER TABLE sessions DROP COLUMN IF EXISTS is_bot;
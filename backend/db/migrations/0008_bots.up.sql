@@ -0,0 +1,6 @@
+-- Set by bot detection at ingestion, flagged sessions are left out of analytics
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- What a site does with bot sessions: drop (never stored), flag (stored with is_bot) or keep (counted)
+ALTER TABLE sites ADD COLUMN IF NOT EXISTS bot_policy TEXT NOT NULL DEFAULT 'flag'
+    CHECK (bot_policy IN ('drop', 'flag', 'keep'));
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS channel_rules;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS channel;
+ALTER TABLE sessions DROP COLUMN IF EXISTS utm_content;
+ALTER TABLE sessions DROP COLUMN IF EXISTS utm_term;
+ALTER TABLE sessions DROP COLUMN IF EXISTS utm_campaign;
+ALTER TABLE sessions DROP COLUMN IF EXISTS utm_medium;
+ALTER TABLE sessions DROP COLUMN IF EXISTS utm_source;
@@ -0,0 +1,20 @@
+-- Parsed from the utm_ parameters of the session's landing page
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS utm_source TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS utm_medium TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS utm_campaign TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS utm_term TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS utm_content TEXT;
+
+-- Acquisition channel the session was classified into at ingestion, see the channel_rules table
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS channel TEXT;
+
+-- Editable rules that classify sessions into channels. The first matching rule by priority wins,
+-- the backend fills an empty table with its default rules on startup.
+CREATE TABLE IF NOT EXISTS channel_rules (
+    id SERIAL PRIMARY KEY,
+    channel TEXT NOT NULL CHECK (channel IN ('search', 'social', 'email', 'direct', 'internal', 'paid', 'referral')),
+    field TEXT NOT NULL CHECK (field IN ('referrer_host', 'utm_source', 'utm_medium', 'utm_campaign')),
+    pattern TEXT NOT NULL,                      -- Case insensitive regular expression, RE2 syntax
+    priority INTEGER NOT NULL DEFAULT 100,      -- Lower runs first
+    created_at TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,16 @@
+-- Create page_views table, one row per page of a session including SPA route changes
+-- No foreign key on session_id: like events, views are usually posted before the session beacon
+CREATE TABLE IF NOT EXISTS page_views (
+    id SERIAL PRIMARY KEY,
+    view_id UUID NOT NULL UNIQUE,               -- Generated by the script, resent with the time on page
+    session_id UUID NOT NULL,                   -- Matches sessions.session_id
+    site_id INTEGER REFERENCES sites(id),
+    path TEXT NOT NULL,                         -- e.g. /pricing
+    title TEXT,                                 -- document.title
+    query TEXT,                                 -- Search part of the URL without the leading ?
+    viewed_at TIMESTAMP NOT NULL DEFAULT NOW(), -- When the page was entered
+    duration INTEGER                            -- Time on page in milliseconds, NULL until the page is left
+);
+
+CREATE INDEX IF NOT EXISTS page_views_session_idx ON page_views (session_id, viewed_at);
+CREATE INDEX IF NOT EXISTS page_views_site_time_idx ON page_views (site_id, viewed_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS sessions_site_user_idx;
+DROP TABLE IF EXISTS unique_users;
+
+CREATE TABLE IF NOT EXISTS unique_users (
+    userId UUID PRIMARY KEY UNIQUE,
+    lastActivityTime TIMESTAMP
+);
@@ -0,0 +1,23 @@
+-- unique_users was never written to, recreate it with one row per user of a site
+DROP TABLE IF EXISTS unique_users;
+
+CREATE TABLE IF NOT EXISTS unique_users (
+    site_id INTEGER NOT NULL REFERENCES sites(id),
+    user_id UUID NOT NULL,                  -- Matches sessions.user_id
+    first_seen TIMESTAMP NOT NULL,          -- Start of the user's first session, keys the retention cohort
+    last_seen TIMESTAMP NOT NULL,           -- Last activity of the user's latest session
+    session_count INTEGER NOT NULL,         -- Human sessions of the user
+    PRIMARY KEY (site_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS unique_users_first_seen_idx ON unique_users (site_id, first_seen);
+
+-- Session writes recompute the row of the session's user from this
+CREATE INDEX IF NOT EXISTS sessions_site_user_idx ON sessions (site_id, user_id) WHERE user_id IS NOT NULL;
+
+INSERT INTO unique_users (site_id, user_id, first_seen, last_seen, session_count)
+SELECT site_id, user_id, MIN(COALESCE(start_time, last_activity_time)), MAX(COALESCE(last_activity_time, start_time)), COUNT(*)
+FROM sessions
+WHERE user_id IS NOT NULL AND site_id IS NOT NULL AND NOT is_bot
+    AND COALESCE(start_time, last_activity_time) IS NOT NULL
+GROUP BY site_id, user_id;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS page_views_time_idx;
+DROP INDEX IF EXISTS events_time_idx;
+DROP INDEX IF EXISTS sessions_activity_idx;
+ALTER TABLE unique_users DROP COLUMN IF EXISTS pruned_sessions;
+DROP TABLE IF EXISTS daily_events;
+DROP TABLE IF EXISTS daily_pages;
+DROP TABLE IF EXISTS daily_breakdowns;
+DROP TABLE IF EXISTS daily_sessions;
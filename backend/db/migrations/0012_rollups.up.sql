@@ -0,0 +1,48 @@
+-- Daily aggregates of the sessions, page views and events the retention job deleted.
+-- site_id is 0 for rows stored before sites existed, there is no foreign key so sites can be compared with it.
+CREATE TABLE IF NOT EXISTS daily_sessions (
+    site_id INTEGER NOT NULL,
+    day DATE NOT NULL,                      -- Day of last_activity_time
+    sessions INTEGER NOT NULL,
+    total_duration BIGINT NOT NULL,         -- Sum of session_duration in milliseconds
+    duration_count INTEGER NOT NULL,        -- Sessions that had a session_duration
+    PRIMARY KEY (site_id, day)
+);
+
+-- One row per breakdown value, e.g. dimension 'referrer' and value 'https://google.com/'
+CREATE TABLE IF NOT EXISTS daily_breakdowns (
+    site_id INTEGER NOT NULL,
+    day DATE NOT NULL,
+    dimension TEXT NOT NULL,                -- referrer, language, browser, os, device, country, channel, campaign, entry_page or exit_page
+    value TEXT NOT NULL,
+    sessions INTEGER NOT NULL,
+    PRIMARY KEY (site_id, day, dimension, value)
+);
+
+CREATE TABLE IF NOT EXISTS daily_pages (
+    site_id INTEGER NOT NULL,
+    day DATE NOT NULL,                      -- Day of viewed_at
+    path TEXT NOT NULL,
+    views INTEGER NOT NULL,
+    sessions INTEGER NOT NULL,              -- Distinct sessions of the day
+    total_duration BIGINT NOT NULL,         -- Sum of the time on page in milliseconds
+    duration_count INTEGER NOT NULL,        -- Views that had a duration
+    PRIMARY KEY (site_id, day, path)
+);
+
+CREATE TABLE IF NOT EXISTS daily_events (
+    site_id INTEGER NOT NULL,
+    day DATE NOT NULL,                      -- Day of event_time
+    name TEXT NOT NULL,
+    events INTEGER NOT NULL,
+    sessions INTEGER NOT NULL,              -- Distinct sessions of the day
+    PRIMARY KEY (site_id, day, name)
+);
+
+-- Sessions of a user that were deleted, so session_count survives them
+ALTER TABLE unique_users ADD COLUMN IF NOT EXISTS pruned_sessions INTEGER NOT NULL DEFAULT 0;
+
+-- The retention job looks for the oldest day left on every run
+CREATE INDEX IF NOT EXISTS sessions_activity_idx ON sessions ((COALESCE(last_activity_time, start_time)));
+CREATE INDEX IF NOT EXISTS events_time_idx ON events (event_time);
+CREATE INDEX IF NOT EXISTS page_views_time_idx ON page_views (viewed_at);
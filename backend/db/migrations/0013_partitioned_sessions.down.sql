@@ -0,0 +1,20 @@
+CREATE TABLE sessions_unpartitioned (LIKE sessions INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+
+INSERT INTO sessions_unpartitioned SELECT * FROM sessions;
+
+ALTER SEQUENCE sessions_id_seq OWNED BY sessions_unpartitioned.id;
+
+-- Drops every partition with it
+DROP TABLE sessions;
+DROP TABLE IF EXISTS session_keys;
+
+ALTER TABLE sessions_unpartitioned RENAME TO sessions;
+ALTER TABLE sessions ADD PRIMARY KEY (id);
+ALTER TABLE sessions ADD FOREIGN KEY (site_id) REFERENCES sites(id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS sessions_session_id_key ON sessions (session_id);
+CREATE INDEX IF NOT EXISTS sessions_site_activity_idx ON sessions (site_id, last_activity_time);
+CREATE INDEX IF NOT EXISTS sessions_activity_idx ON sessions ((COALESCE(last_activity_time, start_time)));
+CREATE INDEX IF NOT EXISTS sessions_site_user_idx ON sessions (site_id, user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS sessions_unparsed_user_agent_idx ON sessions (user_agent)
+WHERE device_type IS NULL AND user_agent IS NOT NULL;
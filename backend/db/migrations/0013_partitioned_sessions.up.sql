@@ -0,0 +1,50 @@
+-- Partition sessions by month on last_activity_time, so analytics over a date range only read its months
+-- and data retention can drop whole months. Sessions without an activity time, or in a month whose
+-- partition does not exist yet, go to sessions_default; the backend creates the months ahead of time.
+ALTER TABLE sessions RENAME TO sessions_unpartitioned;
+
+CREATE TABLE sessions (LIKE sessions_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS)
+PARTITION BY RANGE (last_activity_time);
+
+ALTER SEQUENCE sessions_id_seq OWNED BY sessions.id;
+
+CREATE TABLE sessions_default PARTITION OF sessions DEFAULT;
+
+-- One partition per month from the oldest session to three months ahead, at most two years back
+DO $$
+DECLARE
+    partition_start DATE := date_trunc('month', GREATEST(
+        COALESCE((SELECT MIN(last_activity_time) FROM sessions_unpartitioned), NOW()),
+        NOW() - INTERVAL '2 years'
+    ));
+BEGIN
+    WHILE partition_start < date_trunc('month', NOW()) + INTERVAL '4 months' LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF sessions FOR VALUES FROM (%L) TO (%L)',
+            to_char(partition_start, '"sessions_y"YYYY"m"MM'), partition_start, (partition_start + INTERVAL '1 month')::date);
+        partition_start := partition_start + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO sessions SELECT * FROM sessions_unpartitioned;
+
+-- A unique index on a partitioned table has to include the partition key, which changes with every
+-- beacon. Session writes lock the session's key here instead, see sessionKeyLock in the store.
+CREATE TABLE IF NOT EXISTS session_keys (
+    session_id UUID PRIMARY KEY,
+    site_id INTEGER                         -- The site the session belongs to, NULL before sites existed
+);
+
+INSERT INTO session_keys (session_id, site_id)
+SELECT session_id, site_id FROM sessions_unpartitioned;
+
+DROP TABLE sessions_unpartitioned;
+
+ALTER TABLE sessions ADD FOREIGN KEY (site_id) REFERENCES sites(id);
+
+CREATE INDEX IF NOT EXISTS sessions_id_idx ON sessions (id);
+CREATE INDEX IF NOT EXISTS sessions_session_id_idx ON sessions (session_id);
+CREATE INDEX IF NOT EXISTS sessions_site_activity_idx ON sessions (site_id, last_activity_time) WHERE NOT is_bot;
+CREATE INDEX IF NOT EXISTS sessions_activity_idx ON sessions ((COALESCE(last_activity_time, start_time)));
+CREATE INDEX IF NOT EXISTS sessions_site_user_idx ON sessions (site_id, user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS sessions_unparsed_user_agent_idx ON sessions (user_agent)
+WHERE device_type IS NULL AND user_agent IS NOT NULL;
@@ -0,0 +1,34 @@
+-- URLs notified of what is tracked on a site. types lists the notifications a webhook gets,
+-- 'session.started' and 'event'; events limits event notifications to those names, all when empty.
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY,
+    site_id INTEGER NOT NULL REFERENCES sites(id),
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,                        -- Key of the HMAC signature of every delivery
+    types TEXT[] NOT NULL,
+    events TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_site_idx ON webhooks (site_id);
+
+-- One notification to one webhook, queued in the transaction that stored what it is about.
+-- A pending delivery is sent once next_attempt_at has passed; delivered and failed ones are the
+-- delivery log, kept for a while and then pruned.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    status_code INTEGER,                         -- Of the last attempt, NULL when it got no response
+    error TEXT,                                  -- Why the last attempt failed
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    next_attempt_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_created_idx ON webhook_deliveries (created_at) WHERE status <> 'pending';
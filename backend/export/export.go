@@ -0,0 +1,333 @@
+// Package export writes raw sessions, events and page views as CSV, NDJSON or Parquet for loading
+// into a warehouse or data lake. Rows are written as the store produces them, an export is never
+// held in memory as a whole; Parquet keeps at most one row group.
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+var contentTypes = map[string]string{
+	FormatCSV:     "text/csv; charset=utf-8",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// Dataset is a kind of row that can be exported, Columns are its columns in their default order
+type Dataset struct {
+	Name    string
+	Columns []string
+}
+
+var Sessions = Dataset{
+	Name: "sessions",
+	Columns: []string{
+		"site_id", "session_id", "user_id", "start_time", "last_activity_time", "duration_ms",
+		"referrer", "language", "user_agent", "browser", "browser_version", "os", "os_version", "device_type",
+		"country", "region", "city", "utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content",
+		"channel", "is_bot",
+	},
+}
+
+var Events = Dataset{
+	Name:    "events",
+	Columns: []string{"site_id", "session_id", "name", "timestamp", "page_url", "properties"},
+}
+
+var PageViews = Dataset{
+	Name:    "pageviews",
+	Columns: []string{"site_id", "view_id", "session_id", "path", "title", "query", "timestamp", "duration_ms"},
+}
+
+// Datasets are all the datasets that can be exported, by name
+var Datasets = map[string]Dataset{
+	Sessions.Name:  Sessions,
+	Events.Name:    Events,
+	PageViews.Name: PageViews,
+}
+
+// Stream writes the rows of d in the range and site of p to w, it does not flush w
+func Stream(ctx context.Context, s store.ExportStore, d Dataset, p analytics.Params, w *Writer) error {
+	switch d.Name {
+	case Sessions.Name:
+		return s.ExportSessions(ctx, p, func(session models.Site_session) error {
+			return w.Write(SessionValues(session))
+		})
+	case Events.Name:
+		return s.ExportEvents(ctx, p, func(event models.Site_event) error {
+			return w.Write(EventValues(event))
+		})
+	case PageViews.Name:
+		return s.ExportPageViews(ctx, p, func(view models.Site_view) error {
+			return w.Write(PageViewValues(view))
+		})
+	default:
+		return fmt.Errorf("unknown dataset %q", d.Name)
+	}
+}
+
+// SessionValues returns the values of a session in the order of Sessions.Columns
+func SessionValues(s models.Site_session) []interface{} {
+	session := s.Session
+	return []interface{}{
+		s.SiteID, session.SessionID, text(session.UserID), timestamp(session.StartTime), timestamp(session.LastActivityTime), integer(session.SessionDuration),
+		text(session.Referrer), text(session.Language), text(session.UserAgent), text(session.Client.Browser), text(session.Client.BrowserVersion),
+		text(session.Client.OS), text(session.Client.OSVersion), text(session.Client.Device),
+		text(session.Location.Country), text(session.Location.Region), text(session.Location.City),
+		text(session.Campaign.Source), text(session.Campaign.Medium), text(session.Campaign.Name), text(session.Campaign.Term), text(session.Campaign.Content),
+		text(session.Channel), session.IsBot,
+	}
+}
+
+// EventValues returns the values of an event in the order of Events.Columns
+func EventValues(e models.Site_event) []interface{} {
+	event := e.Event
+	var properties interface{}
+	if len(event.Properties) > 0 {
+		properties = event.Properties
+	}
+	return []interface{}{e.SiteID, event.SessionID, event.Name, timestamp(event.Timestamp), event.PageURL, properties}
+}
+
+// PageViewValues returns the values of a page view in the order of PageViews.Columns
+func PageViewValues(v models.Site_view) []interface{} {
+	view := v.View
+	return []interface{}{v.SiteID, view.ViewID, view.SessionID, view.Path, text(view.Title), text(view.Query), timestamp(view.Timestamp), integer(view.Duration)}
+}
+
+func text(value *string) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func integer(value *int64) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func timestamp(value *time.Time) interface{} {
+	if value == nil {
+		return nil
+	}
+	return value.UTC()
+}
+
+// Format validates the format query param, csv when it is empty
+func Format(format string) (string, error) {
+	if format == "" {
+		return FormatCSV, nil
+	}
+	if _, ok := contentTypes[format]; !ok {
+		return "", analytics.ParamError("format must be csv, ndjson or parquet")
+	}
+	return format, nil
+}
+
+// ContentType is the Content-Type of an export in format
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Select returns the positions of the comma separated columns in d.Columns, in the order asked for.
+// An empty list selects every column.
+func (d Dataset) Select(columns string) ([]int, error) {
+	if columns == "" {
+		all := make([]int, len(d.Columns))
+		for i := range all {
+			all[i] = i
+		}
+		return all, nil
+	}
+
+	positions := make(map[string]int, len(d.Columns))
+	for i, column := range d.Columns {
+		positions[column] = i
+	}
+
+	selected := make([]int, 0)
+	seen := make(map[string]bool)
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		i, ok := positions[column]
+		if !ok {
+			return nil, analytics.ParamError(fmt.Sprintf("unknown %s column %q, columns are %s", d.Name, column, strings.Join(d.Columns, ", ")))
+		}
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+		selected = append(selected, i)
+	}
+	return selected, nil
+}
+
+// Writer writes the selected columns of rows, a CSV export starts with a header row.
+// Call Flush once the last row is written, it ends a Parquet file.
+type Writer struct {
+	out     io.Writer
+	format  string
+	names   []string
+	columns []int
+	csv     *csv.Writer
+	parquet *parquetWriter
+	started bool
+}
+
+func NewWriter(out io.Writer, format string, d Dataset, columns []int) *Writer {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = d.Columns[column]
+	}
+
+	w := &Writer{out: out, format: format, names: names, columns: columns}
+	switch format {
+	case FormatCSV:
+		w.csv = csv.NewWriter(out)
+	case FormatParquet:
+		w.parquet = newParquetWriter(out, d.Name, names)
+	}
+	return w
+}
+
+// Write writes one row, values in the order of the dataset's columns
+func (w *Writer) Write(values []interface{}) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+
+	if w.parquet != nil {
+		return w.parquet.write(w.columns, values)
+	}
+
+	if w.csv != nil {
+		record := make([]string, len(w.columns))
+		for i, column := range w.columns {
+			record[i] = csvValue(values[column])
+		}
+		return w.csv.Write(record)
+	}
+
+	var line bytes.Buffer
+	line.WriteByte('{')
+	for i, column := range w.columns {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		name, _ := json.Marshal(w.names[i])
+		value, err := json.Marshal(values[column])
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", w.names[i], err)
+		}
+		line.Write(name)
+		line.WriteByte(':')
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+
+	_, err := w.out.Write(line.Bytes())
+	return err
+}
+
+// Flush writes what is buffered, and the header of an export without rows
+func (w *Writer) Flush() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if w.parquet != nil {
+		return w.parquet.close()
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return nil
+}
+
+func (w *Writer) start() error {
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	if w.csv != nil {
+		return w.csv.Write(w.names)
+	}
+	return nil
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case json.RawMessage:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ToFile writes every column of d to path. The rows go to a temporary file next to it first, so a
+// reader watching the directory never picks up a partial export.
+func ToFile(ctx context.Context, s store.ExportStore, d Dataset, p analytics.Params, format, path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	columns, _ := d.Select("")
+	out := bufio.NewWriter(file)
+	w := NewWriter(out, format, d, columns)
+	if err := Stream(ctx, s, d, p, w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
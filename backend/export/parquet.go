@@ -0,0 +1,119 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// rowGroupSize bounds what a Parquet export buffers before a row group is written out
+const rowGroupSize = 50_000
+
+// parquetTypes are the types of the columns that are not strings, the same for every dataset.
+// Times are UTC timestamps in microseconds, as Postgres stores them.
+var parquetTypes = map[string]parquet.Node{
+	"site_id":            parquet.Int(32),
+	"start_time":         parquet.Timestamp(parquet.Microsecond),
+	"last_activity_time": parquet.Timestamp(parquet.Microsecond),
+	"timestamp":          parquet.Timestamp(parquet.Microsecond),
+	"duration_ms":        parquet.Int(64),
+	"is_bot":             parquet.Leaf(parquet.BooleanType),
+	"properties":         parquet.JSON(),
+}
+
+// requiredColumns are never null, every other column is optional
+var requiredColumns = map[string]bool{
+	"site_id":    true,
+	"session_id": true,
+	"view_id":    true,
+	"name":       true,
+	"path":       true,
+	"is_bot":     true,
+}
+
+// parquetWriter writes rows against a schema of the selected columns. Parquet orders the
+// columns of a schema by name, so leaves maps each selected column to its place in a row.
+type parquetWriter struct {
+	writer *parquet.Writer
+	names  []string
+	leaves []parquet.LeafColumn
+}
+
+func newParquetWriter(out io.Writer, dataset string, names []string) *parquetWriter {
+	group := make(parquet.Group, len(names))
+	for _, name := range names {
+		node, ok := parquetTypes[name]
+		if !ok {
+			node = parquet.String()
+		}
+		if !requiredColumns[name] {
+			node = parquet.Optional(node)
+		}
+		group[name] = node
+	}
+
+	schema := parquet.NewSchema(dataset, group)
+	leaves := make([]parquet.LeafColumn, len(names))
+	for i, name := range names {
+		leaves[i], _ = schema.Lookup(name)
+	}
+
+	return &parquetWriter{
+		writer: parquet.NewWriter(out, schema, parquet.Compression(&parquet.Snappy), parquet.MaxRowsPerRowGroup(rowGroupSize)),
+		names:  names,
+		leaves: leaves,
+	}
+}
+
+func (w *parquetWriter) write(columns []int, values []interface{}) error {
+	row := make(parquet.Row, len(w.leaves))
+	for i, column := range columns {
+		leaf := w.leaves[i]
+
+		value, err := parquetValue(values[column])
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", w.names[i], err)
+		}
+
+		definition := leaf.MaxDefinitionLevel
+		if value.IsNull() {
+			if definition == 0 {
+				return fmt.Errorf("encoding %s: column is required", w.names[i])
+			}
+			definition = 0
+		}
+		row[leaf.ColumnIndex] = value.Level(0, definition, leaf.ColumnIndex)
+	}
+
+	_, err := w.writer.WriteRows([]parquet.Row{row})
+	return err
+}
+
+// close writes the last row group and the footer
+func (w *parquetWriter) close() error {
+	return w.writer.Close()
+}
+
+func parquetValue(value interface{}) (parquet.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return parquet.Value{}, nil
+	case string:
+		return parquet.ByteArrayValue([]byte(v)), nil
+	case json.RawMessage:
+		return parquet.ByteArrayValue(v), nil
+	case int:
+		return parquet.Int32Value(int32(v)), nil
+	case int64:
+		return parquet.Int64Value(v), nil
+	case bool:
+		return parquet.BooleanValue(v), nil
+	case time.Time:
+		return parquet.Int64Value(v.UnixMicro()), nil
+	default:
+		return parquet.Value{}, fmt.Errorf("unsupported value %T", value)
+	}
+}
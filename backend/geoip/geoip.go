@@ -0,0 +1,112 @@
+// Package geoip resolves a request's client IP to a country, region and city using a local
+// MaxMind format database (GeoLite2-City or GeoIP2-City), so no lookup leaves the server.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/models"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Locator is safe for concurrent use. A nil Locator resolves nothing, which is how
+// GeoIP stays off when no database is configured.
+type Locator struct {
+	reader         *maxminddb.Reader
+	trustedProxies []*net.IPNet
+}
+
+// record is the part of a City database entry Borea keeps
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+func Open(path string, trustedProxies []*net.IPNet) (*Locator, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening GeoIP database: %w", err)
+	}
+
+	return &Locator{reader: reader, trustedProxies: trustedProxies}, nil
+}
+
+// FromEnv opens the database at GEOIP_DATABASE, trusting X-Forwarded-For from TRUSTED_PROXIES.
+// It returns a nil Locator when GEOIP_DATABASE is not set.
+func FromEnv() (*Locator, error) {
+	path := os.Getenv("GEOIP_DATABASE")
+	if path == "" {
+		return nil, nil
+	}
+
+	trustedProxies, err := helper.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+
+	return Open(path, trustedProxies)
+}
+
+func (l *Locator) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.reader.Close()
+}
+
+// Describe names the loaded database and when it was built, e.g. "GeoLite2-City built 2024-10-01"
+func (l *Locator) Describe() string {
+	metadata := l.reader.Metadata
+	built := time.Unix(int64(metadata.BuildEpoch), 0).UTC().Format("2006-01-02")
+	return fmt.Sprintf("%s built %s", metadata.DatabaseType, built)
+}
+
+// Locate resolves the client IP of the request, the IP is only held for the lookup
+func (l *Locator) Locate(r *http.Request) models.Location {
+	if l == nil {
+		return models.Location{}
+	}
+	return l.Lookup(helper.ClientIP(r, l.trustedProxies))
+}
+
+// Lookup returns what the database knows about ip, private and unknown addresses resolve to nothing
+func (l *Locator) Lookup(ip net.IP) models.Location {
+	if l == nil || ip == nil {
+		return models.Location{}
+	}
+
+	var entry record
+	if err := l.reader.Lookup(ip, &entry); err != nil {
+		return models.Location{}
+	}
+
+	location := models.Location{
+		Country: optional(entry.Country.ISOCode),
+		City:    optional(entry.City.Names["en"]),
+	}
+	if len(entry.Subdivisions) > 0 {
+		location.Region = optional(entry.Subdivisions[0].Names["en"])
+	}
+
+	return location
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
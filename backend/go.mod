@@ -6,10 +6,37 @@ require github.com/joho/godotenv v1.5.1 // direct
 
 require github.com/stretchr/testify v1.9.0
 
+require github.com/golang-jwt/jwt/v5 v5.2.1
+
+require github.com/mileusna/useragent v1.3.5
+
+require github.com/oschwald/maxminddb-golang v1.12.0
+
+require github.com/maxmind/mmdbwriter v1.0.0
+
+require github.com/parquet-go/parquet-go v0.25.1
+
+require github.com/prometheus/client_golang v1.20.5
+
+require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	go4.org/netipx v0.0.0-20220812043211-3cc044ffd68d // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
+
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/lib/pq v1.10.9
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/exp v0.0.0-20240909161429-701f63a606c0
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"Borea/backend/logging"
+	"Borea/backend/store"
+)
+
+// GetAdminUser expects {"username": ...} and returns the admin user with its password hash,
+// so the dashboard can check a login without sending SQL
+func (h *Handlers) GetAdminUser(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil || requestBody.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.store.AdminUser(r.Context(), requestBody.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Admin user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("looking up admin user", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
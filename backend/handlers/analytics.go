@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"Borea/backend/analytics"
+	"Borea/backend/logging"
+)
+
+// Query params for every analytics route: from, to (YYYY-MM-DD, inclusive) and granularity (day, week, month)
+// for time series, or limit for breakdowns. See analytics.ParseParams.
+
+func (h *Handlers) GetSessionsOverTime(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.SessionsOverTime(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetAverageDuration(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.AverageDuration(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetTopReferrers(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.TopReferrers(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetLanguages(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.Languages(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetBrowsers(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.Browsers(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetOperatingSystems(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.OperatingSystems(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetDevices(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.Devices(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetCountries(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.Countries(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetChannels(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.Channels(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.Campaigns(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetTopPages(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.TopPages(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetEntryPages(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.EntryPages(r.Context(), p)
+	})
+}
+
+func (h *Handlers) GetExitPages(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		return h.store.ExitPages(r.Context(), p)
+	})
+}
+
+// GetFunnel also takes the funnel's steps and within, see analytics.ParseFunnel
+func (h *Handlers) GetFunnel(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		funnel, err := analytics.ParseFunnel(r.URL.Query(), p)
+		if err != nil {
+			return nil, err
+		}
+		return h.store.Funnel(r.Context(), funnel)
+	})
+}
+
+// GetRetention groups users into cohorts by week unless another granularity is asked for
+func (h *Handlers) GetRetention(w http.ResponseWriter, r *http.Request) {
+	serveAnalytics(w, r, func(p analytics.Params) (interface{}, error) {
+		if r.URL.Query().Get("granularity") == "" {
+			p.Granularity = "week"
+		}
+		return h.store.Retention(r.Context(), p)
+	})
+}
+
+// serveAnalytics handles the request plumbing shared by all analytics routes and encodes the query result as JSON
+func serveAnalytics(w http.ResponseWriter, r *http.Request, query func(analytics.Params) (interface{}, error)) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := analytics.ParseParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := query(params)
+	var paramErr analytics.ParamError
+	if errors.As(err, &paramErr) {
+		http.Error(w, paramErr.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("running analytics query", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"Borea/backend/bots"
+	"Borea/backend/logging"
+	"Borea/backend/metrics"
+	"Borea/backend/models"
+	"Borea/backend/store"
+	"Borea/backend/useragent"
+)
+
+const (
+	maxBatchItems     = 500
+	maxBatchBodyBytes = 5 << 20
+)
+
+// PostBatch ingests many sessions, events and page views in one request. The body is either a JSON
+// array of models.Batch_item or NDJSON with one item per line. Every item is validated
+// and written on its own (see store.BatchWriter), so one bad item does not reject the
+// rest, and the response reports success or failure per item index.
+func (h *Handlers) PostBatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method != http.MethodPost && r.Method != http.MethodOptions {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Every item in a batch belongs to the site of the request
+	site, ok := h.resolveTrackingSite(w, r)
+	if !ok {
+		return
+	}
+
+	// Handle preflight request
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	rawItems, err := splitBatchBody(body)
+	if err != nil {
+		metrics.Reject(metrics.ReasonBadJSON)
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	if len(rawItems) == 0 {
+		http.Error(w, "Batch contains no items", http.StatusBadRequest)
+		return
+	}
+
+	if len(rawItems) > maxBatchItems {
+		http.Error(w, fmt.Sprintf("Batch exceeds %d items", maxBatchItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results, err := h.writeBatch(r, site, rawItems)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("writing batch", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// splitBatchBody returns the undecoded items of a JSON array or NDJSON body.
+// Browsers send beacons as text/plain, so the format is sniffed from the body instead of the Content-Type.
+func splitBatchBody(body []byte) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(body)
+
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	items := make([]json.RawMessage, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchBodyBytes)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+	}
+
+	return items, scanner.Err()
+}
+
+func (h *Handlers) writeBatch(r *http.Request, site models.Site, rawItems []json.RawMessage) ([]models.Batch_result, error) {
+	results := make([]models.Batch_result, len(rawItems))
+
+	// Only valid items reach the store, indexes maps each write back to its item
+	writes := make([]store.Write, 0, len(rawItems))
+	indexes := make([]int, 0, len(rawItems))
+	location := h.geo.Locate(r)
+	now := time.Now()
+
+	for i, raw := range rawItems {
+		results[i].Index = i
+
+		write, msg := prepareBatchItem(raw)
+		if msg != "" {
+			results[i].Error = msg
+			continue
+		}
+
+		if write.Session != nil {
+			write.Session.Location = location
+
+			// Dropped bot sessions are reported as written, like /postSession does
+			if !bots.Admit(write.Session, site.BotPolicy, now) {
+				results[i].Success = true
+				continue
+			}
+			h.channels.Apply(r.Context(), write.Session, site)
+		}
+
+		writes = append(writes, write)
+		indexes = append(indexes, i)
+	}
+
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	errs, err := h.store.WriteBatch(r.Context(), site.ID, writes)
+	if err != nil {
+		return nil, err
+	}
+
+	ingested := 0
+	for j, err := range errs {
+		i := indexes[j]
+		if err != nil {
+			logging.FromContext(r.Context()).Error("writing batch item", "index", i, "err", err)
+			results[i].Error = "Error writing item"
+			continue
+		}
+
+		results[i].Success = true
+		h.publish(site.ID, writes[j], now)
+		if writes[j].Session != nil {
+			ingested++
+		}
+	}
+	metrics.SessionsIngested(site.ID, ingested)
+
+	return results, nil
+}
+
+// prepareBatchItem decodes and validates one item, returning the write for it
+// or a client facing error message.
+func prepareBatchItem(raw json.RawMessage) (store.Write, string) {
+	var item models.Batch_item
+	if err := json.Unmarshal(raw, &item); err != nil {
+		return store.Write{}, "Error parsing JSON"
+	}
+
+	switch item.Type {
+	case "session":
+		var session models.Session
+		if err := json.Unmarshal(item.Data, &session); err != nil {
+			return store.Write{}, "data must be a session object"
+		}
+
+		if msg := validateSession(session); msg != "" {
+			return store.Write{}, msg
+		}
+		useragent.Apply(&session)
+
+		return store.Write{Session: &session}, ""
+
+	case "event":
+		var event models.Event
+		if err := json.Unmarshal(item.Data, &event); err != nil {
+			return store.Write{}, "data must be an event object"
+		}
+
+		if msg := validateEvent(&event); msg != "" {
+			return store.Write{}, msg
+		}
+
+		return store.Write{Event: &event}, ""
+
+	case "pageview":
+		var view models.Page_view
+		if err := json.Unmarshal(item.Data, &view); err != nil {
+			return store.Write{}, "data must be a page view object"
+		}
+
+		if msg := validatePageView(&view); msg != "" {
+			return store.Write{}, msg
+		}
+
+		return store.Write{PageView: &view}, ""
+
+	default:
+		return store.Write{}, fmt.Sprintf("unknown item type %q", item.Type)
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"Borea/backend/channels"
+	"Borea/backend/logging"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+// GetChannelRules returns the rules sessions are classified into channels with, in the order they run
+func (h *Handlers) GetChannelRules(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rules, err := h.store.ChannelRules(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("listing channel rules", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// CreateChannelRule expects {"channel": ..., "field": ..., "pattern": ..., "priority": ...} and returns
+// the stored rule. It applies to sessions ingested from now on, stored sessions keep their channel.
+func (h *Handlers) CreateChannelRule(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody models.Channel_rule
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	rule, err := channels.CreateRule(r.Context(), h.store, requestBody)
+	if errors.Is(err, channels.ErrInvalidRule) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("creating channel rule", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.channels.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteChannelRule expects {"id": ...}
+func (h *Handlers) DeleteChannelRule(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	err := h.store.DeleteChannelRule(r.Context(), requestBody.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Channel rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting channel rule", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.channels.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true}`))
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/logging"
+	"Borea/backend/metrics"
+	"Borea/backend/models"
+)
+
+// Events are stored alongside sessions and linked by session_id, so they can
+// be posted at any point during a session, before the session beacon exists.
+func (h *Handlers) PostEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method != http.MethodPost && r.Method != http.MethodOptions {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site, ok := h.resolveTrackingSite(w, r)
+	if !ok {
+		return
+	}
+
+	// Handle preflight request
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var event models.Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		metrics.Reject(metrics.ReasonBadJSON)
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validateEvent(&event); msg != "" {
+		metrics.Reject(metrics.ReasonInvalid)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	logging.With(r.Context(), "session_id", event.SessionID)
+
+	if err := h.store.InsertEvent(r.Context(), site.ID, event); err != nil {
+		logging.FromContext(r.Context()).Error("inserting event", "err", err)
+		http.Error(w, "Error inserting event", http.StatusInternalServerError)
+		return
+	}
+	h.live.Event(site.ID, event, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"success": true}`))
+}
+
+// validateEvent returns a client facing error message, or "" if the event is valid.
+// Missing properties are normalized to an empty object.
+func validateEvent(event *models.Event) string {
+	if !helper.IsValidUUID(event.SessionID) {
+		return "sessionId must be a valid UUID"
+	}
+
+	if event.Name == "" {
+		return "name is required"
+	}
+
+	props := bytes.TrimSpace(event.Properties)
+	if len(props) == 0 || bytes.Equal(props, []byte("null")) {
+		event.Properties = json.RawMessage("{}")
+		return ""
+	}
+
+	if props[0] != '{' {
+		return "properties must be a JSON object"
+	}
+
+	return ""
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"Borea/backend/analytics"
+	"Borea/backend/export"
+	"Borea/backend/logging"
+)
+
+// Query params for the export routes: from, to and site as for analytics, format (csv, ndjson or
+// parquet, csv by default) and columns, a comma separated subset of the dataset's columns in the
+// order they are wanted; Parquet files order their columns by name. CSV and NDJSON are gzipped
+// when the client accepts it, Parquet is compressed with Snappy instead.
+
+func (h *Handlers) ExportSessions(w http.ResponseWriter, r *http.Request) {
+	h.serveExport(w, r, export.Sessions)
+}
+
+func (h *Handlers) ExportEvents(w http.ResponseWriter, r *http.Request) {
+	h.serveExport(w, r, export.Events)
+}
+
+func (h *Handlers) ExportPageViews(w http.ResponseWriter, r *http.Request) {
+	h.serveExport(w, r, export.PageViews)
+}
+
+// serveExport streams the rows of dataset as the store reads them. Until the first bytes are sent
+// a failure is still a 500, after that the connection is aborted so the client never takes a cut
+// short export for a complete one.
+func (h *Handlers) serveExport(w http.ResponseWriter, r *http.Request, dataset export.Dataset) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	params, err := analytics.ParseParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format, err := export.Format(r.URL.Query().Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns, err := dataset.Select(r.URL.Query().Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := &exportBody{
+		w:           w,
+		gzip:        format != export.FormatParquet && acceptsGzip(r),
+		contentType: export.ContentType(format),
+		filename:    fmt.Sprintf("%s-%s-%s.%s", dataset.Name, params.From.Format("2006-01-02"), params.To.Format("2006-01-02"), format),
+	}
+	writer := export.NewWriter(body, format, dataset, columns)
+
+	err = export.Stream(r.Context(), h.store, dataset, params, writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		body.start()
+		err = body.Close()
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("exporting", "dataset", dataset.Name, "err", err)
+		if !body.started {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// exportBody sends the export headers with the first bytes written, so nothing is committed
+// to before the query produced a row
+type exportBody struct {
+	w           http.ResponseWriter
+	gzip        bool
+	contentType string
+	filename    string
+
+	started bool
+	out     io.Writer
+	zw      *gzip.Writer
+}
+
+func (b *exportBody) start() {
+	if b.started {
+		return
+	}
+	b.started = true
+
+	header := b.w.Header()
+	header.Set("Content-Type", b.contentType)
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", b.filename))
+	header.Add("Vary", "Accept-Encoding")
+	b.out = b.w
+	if b.gzip {
+		header.Set("Content-Encoding", "gzip")
+		b.zw = gzip.NewWriter(b.w)
+		b.out = b.zw
+	}
+	b.w.WriteHeader(http.StatusOK)
+}
+
+func (b *exportBody) Write(p []byte) (int, error) {
+	b.start()
+	return b.out.Write(p)
+}
+
+func (b *exportBody) Close() error {
+	if b.zw != nil {
+		return b.zw.Close()
+	}
+	return nil
+}
+
+// acceptsGzip reports whether Accept-Encoding lists gzip without ruling it out with q=0
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
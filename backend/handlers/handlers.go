@@ -1,411 +1,93 @@
-// This is the file to put our crud and query functions into.
-
 package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-
-	"log"
 	"net/http"
 	"os"
+	"time"
 
-	"Borea/backend/db"
+	"Borea/backend/bots"
+	"Borea/backend/channels"
+	"Borea/backend/geoip"
 	"Borea/backend/helper"
+	"Borea/backend/ingest"
+	"Borea/backend/live"
+	"Borea/backend/logging"
+	"Borea/backend/metrics"
 	"Borea/backend/models"
+	"Borea/backend/store"
+	"Borea/backend/useragent"
 )
 
-// TODO: change this to GET and find a way to send the query & param data without a POST or URL params
-// TODO: change this to only run SELECT statements
-func GetItems(w http.ResponseWriter, r *http.Request) {
-	DOMAIN := os.Getenv("DOMAIN")
-
-	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		fmt.Println("Invalid request method")
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if db.DB == nil {
-		log.Println("Database connection not initialized")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	var requestBody models.Request_body
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		fmt.Println("Error reading request body:", err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
-		return
-	}
-
-	if !helper.IsAllowedQuery(requestBody.Query, "SELECT") {
-		http.Error(w, "Invalid query: only SELECT queries allowed", http.StatusBadRequest)
-		return
-	}
-
-	// The two below methods prevent SQL injection
-	stmt, err := db.DB.Prepare(requestBody.Query)
-	if err != nil {
-		log.Printf("Error preparing query: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer stmt.Close()
-
-	rows, err := stmt.Query(requestBody.Params...)
-	if err != nil {
-		log.Printf("Error querying database: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
-
-	columns, err := rows.Columns()
-	if err != nil {
-		return
-	}
-
-	items := make([]map[string]interface{}, 0)
-
-	values := make([]interface{}, len(columns))
-	for i := range values {
-		var value interface{}
-		values[i] = &value
-	}
-
-	for rows.Next() {
-		if err := rows.Scan(values...); err != nil {
-			return
-		}
-
-		rowMap := make(map[string]interface{})
-		for i, colName := range columns {
-			rowMap[colName] = *(values[i].(*interface{}))
-		}
-
-		items = append(items, rowMap)
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Printf("Error during row iteration: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(items)
+// Handlers serves the backend's routes from a store. sessions is optional, without a
+// queue session beacons are written to the store during the request. geo is optional too,
+// without it sessions have no location, and so is hub, without it there is no live view.
+type Handlers struct {
+	store    store.Store
+	sessions *ingest.Queue
+	geo      *geoip.Locator
+	channels *channels.Classifier
+	live     *live.Hub
 }
 
-// TODO: change this to GET and find a way to send the query & param data without a POST or URL splice
-// TODO: change this functio nto only run SELECT sql queries
-// Note that this returns an interface type, while GetItems returns an array of interface types
-func GetItem(w http.ResponseWriter, r *http.Request) {
-	DOMAIN := os.Getenv("DOMAIN")
-
-	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if db.DB == nil {
-		log.Println("Database connection not initialized")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	var requestBody models.Request_body
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		fmt.Println("Error reading request body:", err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
-		return
-	}
-
-	if !helper.IsAllowedQuery(requestBody.Query, "SELECT") {
-		http.Error(w, "Invalid query: only SELECT queries allowed", http.StatusBadRequest)
-		return
-	}
-
-	// The two below methods prevent SQL injection
-	stmt, err := db.DB.Prepare(requestBody.Query)
-	if err != nil {
-		log.Printf("Error preparing query: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer stmt.Close()
-
-	rows, err := stmt.Query(requestBody.Params...)
-	if err != nil {
-		log.Printf("Error querying: %v", err)
-	}
-	defer rows.Close()
-
-	// Get column count and names dynamically
-	columns, err := rows.Columns()
-	if err != nil {
-		log.Printf("Error querying: %v", err)
-	}
-
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-
-	for i := range values {
-		valuePtrs[i] = &values[i]
-	}
-
-	results := make(map[string]interface{})
-
-	for rows.Next() {
-		err := rows.Scan(valuePtrs...)
-		if err != nil {
-			log.Printf("Error scanning row data: %v", err)
-		}
-
-		for i, col := range columns {
-			results[col] = values[i]
-		}
-	}
-
-	if err := rows.Err(); err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(results)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+func New(s store.Store, sessions *ingest.Queue, geo *geoip.Locator, hub *live.Hub) *Handlers {
+	return &Handlers{store: s, sessions: sessions, geo: geo, channels: channels.NewClassifier(s), live: hub}
 }
 
-// This function expects an INSERT query with a RETURNING id to ensure insertion
-// Create a new item
-func CreateItem(w http.ResponseWriter, r *http.Request) {
-	DOMAIN := os.Getenv("DOMAIN")
-
-	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
+func (h *Handlers) HandleScriptRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if db.DB == nil {
-		log.Println("Database connection not initialized")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	var requestBody models.Request_body
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		fmt.Println("Error reading request body:", err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
-		return
-	}
-
-	if !helper.IsAllowedQuery(requestBody.Query, "INSERT") {
-		http.Error(w, "Invalid query: only INSERT queries allowed", http.StatusBadRequest)
-		return
-	}
-
-	// The two below methods prevent SQL injection
-	stmt, err := db.DB.Prepare(requestBody.Query)
-	if err != nil {
-		log.Printf("Error preparing query: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if r.Method != http.MethodGet && r.Method != http.MethodOptions {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	defer stmt.Close()
 
-	var insertedID int
-	err = stmt.QueryRow(requestBody.Params...).Scan(&insertedID)
-	if err != nil {
-		log.Printf("SQL execution error: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Token and domain enforcement
+	if _, ok := h.resolveTrackingSite(w, r); !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(insertedID)
-}
-
-// Update an existing item
-func UpdateItem(w http.ResponseWriter, r *http.Request) {
-	DOMAIN := os.Getenv("DOMAIN")
-
-	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
-	w.Header().Set("Access-Control-Allow-Methods", "PUT")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if r.Method != http.MethodPut {
-		http.Error(w, "Invalid query: only PUT queries allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if db.DB == nil {
-		log.Println("Database connection not initialized")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
-	var requestBody models.Request_body
-
-	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
-		fmt.Println("Error reading request body:", err)
-		http.Error(w, "Error reading request body", http.StatusInternalServerError)
-		return
-	}
-
-	if !helper.IsAllowedQuery(requestBody.Query, "UPDATE") {
-		http.Error(w, "Invalid query: only PUT queries allowed", http.StatusBadRequest)
-		return
-	}
-
-	// The two below methods prevent SQL injection
-	stmt, err := db.DB.Prepare(requestBody.Query)
+	// Read the content of the JavaScript file
+	jsContent, err := os.ReadFile("./Borea.js")
 	if err != nil {
-		log.Printf("Error preparing query: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		http.Error(w, "Error reading script file", http.StatusInternalServerError)
+		logging.FromContext(r.Context()).Error("reading script file", "err", err)
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(requestBody.Params...)
-	if err != nil {
-		log.Printf("SQL execution error: %v", err)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Write(jsContent)
 }
 
-// // Delete an item
-// func DeleteItem(w http.ResponseWriter, r *http.Request) {
-// 	if r.Method != http.MethodDelete {
-// 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-// 		return
-// 	}
-
-// 	id, err := extractIDFromURL(r.URL.Path)
-// 	if err != nil {
-// 		http.Error(w, "Invalid ID", http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	_, err = db.DB.Exec("DELETE FROM test_table WHERE id = $1", id)
-// 	if err != nil {
-// 		log.Fatal(err)
-// 	}
-
-// 	w.Header().Set("Content-Type", "application/json")
-// 	json.NewEncoder(w).Encode(map[string]string{"message": "Item deleted"})
-// }
-
-func HandleScriptRequest(w http.ResponseWriter, r *http.Request) {
-	DOMAIN := os.Getenv("DOMAIN")
-	TOKEN := os.Getenv("API_TOKEN")
-
-	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
-	w.Header().Set("Access-Control-Allow-Methods", "GET")
+func (h *Handlers) PostSessionData(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
-
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodPost && r.Method != http.MethodOptions {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Token check
-	token := r.URL.Query().Get("token")
-
-	validToken := TOKEN == token
-
-	if !validToken {
-		http.Error(w, "Invalid token in request", http.StatusForbidden)
-		return
-	}
-
-	// Domain enforcement
-	domain := helper.ParseDomainRequest(r)
-	if domain == "" {
-		http.Error(w, "Error getting domain", http.StatusForbidden)
-		return
-	}
-
-	domainAllowed := DOMAIN == domain
-
-	if !domainAllowed {
-		http.Error(w, "Domain not allowed for this token", http.StatusForbidden)
-		return
-	}
-
-	// Read the content of the JavaScript file
-	jsContent, err := os.ReadFile("./Borea.js")
-	if err != nil {
-		http.Error(w, "Error reading script file", http.StatusInternalServerError)
-		log.Printf("Error reading script file: %v", err)
+	site, ok := h.resolveTrackingSite(w, r)
+	if !ok {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/javascript")
-	w.Write(jsContent)
-}
-
-func PostSessionData(w http.ResponseWriter, r *http.Request) {
-	DOMAIN := os.Getenv("DOMAIN")
-
-	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
-	w.Header().Set("Access-Control-Allow-Methods", "POST")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
 	// Handle preflight request
 	if r.Method == http.MethodOptions {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Error reading request body", http.StatusInternalServerError)
@@ -414,73 +96,85 @@ func PostSessionData(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
 
-	var sessionData map[string]interface{}
-	err = json.Unmarshal(body, &sessionData)
+	var session models.Session
+	err = json.Unmarshal(body, &session)
 	if err != nil {
+		metrics.Reject(metrics.ReasonBadJSON)
 		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
 		return
 	}
 
-	// Extract sessionId
-	sessionId, ok := sessionData["sessionId"].(string)
-	if !ok {
-		http.Error(w, "sessionId not found in session data", http.StatusBadRequest)
+	if msg := validateSession(session); msg != "" {
+		metrics.Reject(metrics.ReasonInvalid)
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
+	logging.With(r.Context(), "session_id", session.SessionID)
+	useragent.Apply(&session)
+	session.Location = h.geo.Locate(r)
 
-	// Prepare the SELECT statement
-	stmt, err := db.DB.Prepare("SELECT id FROM sessions WHERE session_id = $1")
-	if err != nil {
-		log.Printf("Error preparing query: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// A dropped bot gets the usual answer, so it has no reason to retry or change its ways
+	if !bots.Admit(&session, site.BotPolicy, time.Now()) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"success": true}`))
 		return
 	}
-	defer stmt.Close()
+	h.channels.Apply(r.Context(), &session, site)
 
-	var id int
-	err = stmt.QueryRow(sessionId).Scan(&id)
-	if err != nil {
-		if id == 0 {
-			// No session, create it
-			_, err = db.DB.Exec(`
-			INSERT INTO sessions (last_activity_time, user_id, session_id, token, start_time, session_duration, user_agent, referrer, language)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-				sessionData["lastActivityTime"], sessionData["userId"], sessionData["sessionId"],
-				sessionData["token"], sessionData["startTime"], sessionData["sessionDuration"], sessionData["userAgent"],
-				sessionData["referrer"], sessionData["language"])
-
-			if err != nil {
-				http.Error(w, "Error inserting new session", http.StatusInternalServerError)
-				log.Printf("Error inserting new session: %v", err)
-				return
-			}
-		} else {
-			log.Printf("Error querying session: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+	// Without an ingest queue (one-off commands, tests) the session is written right away
+	if h.sessions == nil {
+		err := h.store.UpsertSession(r.Context(), site.ID, session)
+		if errors.Is(err, store.ErrSessionOfAnotherSite) {
+			http.Error(w, "Session belongs to another site", http.StatusConflict)
 			return
 		}
-	} else {
-		// Session found, update it
-		_, err = db.DB.Exec(`
-		UPDATE sessions
-		SET last_activity_time = $2, user_id = $3, session_id = $1, token = $4, start_time = $5, session_duration = $6, user_agent = $7, referrer = $8, language = $9
-		WHERE session_id = $1`,
-			sessionData["sessionId"], sessionData["lastActivityTime"], sessionData["userId"],
-			sessionData["token"], sessionData["startTime"], sessionData["sessionDuration"], sessionData["userAgent"],
-			sessionData["referrer"], sessionData["language"])
-
 		if err != nil {
-			http.Error(w, "Error updating session", http.StatusInternalServerError)
-			log.Printf("Error updating session: %v", err)
+			logging.FromContext(r.Context()).Error("writing session", "err", err)
+			http.Error(w, "Error writing session", http.StatusInternalServerError)
 			return
 		}
+		h.live.Session(site.ID, session, time.Now())
+		metrics.SessionsIngested(site.ID, 1)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"success": true}`))
+		return
 	}
 
+	err = h.sessions.Enqueue(models.Site_session{SiteID: site.ID, Session: session})
+	if err != nil {
+		metrics.Reject(metrics.ReasonQueueFull)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server busy, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	h.live.Session(site.ID, session, time.Now())
+	metrics.SessionsIngested(site.ID, 1)
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusAccepted)
 	w.Write([]byte(`{"success": true}`))
 }
 
+// validateSession returns a client facing error message, or "" if the session is valid.
+func validateSession(session models.Session) string {
+	if session.SessionID == "" {
+		return "sessionId not found in session data"
+	}
+
+	if !helper.IsValidUUID(session.SessionID) {
+		return "sessionId must be a valid UUID"
+	}
+
+	if session.UserID != nil && !helper.IsValidUUID(*session.UserID) {
+		return "userId must be a valid UUID"
+	}
+
+	return ""
+}
+
 func PingHandler(w http.ResponseWriter, r *http.Request) {
 	// Set response header and status
 	w.Header().Set("Content-Type", "text/plain")
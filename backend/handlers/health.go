@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"Borea/backend/health"
+	"Borea/backend/models"
+)
+
+// LivenessHandler answers 200 while the process serves requests. It checks no dependency,
+// a database outage should take the backend out of rotation, not get it restarted.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeHealthReport(w, http.StatusOK, models.Health_report{Status: health.StatusOK})
+}
+
+// ReadinessHandler answers 200 with the checks while the backend can take traffic, even
+// degraded, and 503 once a critical check fails.
+func ReadinessHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := checker.Ready(r.Context())
+
+		status := http.StatusOK
+		if report.Status == health.StatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealthReport(w, status, report)
+	}
+}
+
+func writeHealthReport(w http.ResponseWriter, status int, report models.Health_report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+// GetLive streams the live view of ?site= (all sites without it) as Server-Sent Events.
+// An "active" event with a models.Live_count is sent on connect and then every interval,
+// and what the tracking endpoints ingest follows as "session", "event" and "pageview"
+// events carrying a models.Live_item.
+func (h *Handlers) GetLive(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.live == nil {
+		http.Error(w, "Live view is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	siteID := 0
+	if site := r.URL.Query().Get("site"); site != "" {
+		var err error
+		siteID, err = strconv.Atoi(site)
+		if err != nil || siteID < 1 {
+			http.Error(w, "site must be a site id", http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := h.live.Subscribe(siteID)
+	defer h.live.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keeps nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	// The count doubles as a heartbeat, so proxies don't time out a quiet stream
+	ticker := time.NewTicker(h.live.Interval())
+	defer ticker.Stop()
+
+	count := func() error {
+		return writeServerEvent(w, "active", models.Live_count{SiteID: siteID, Active: h.live.Active(siteID, time.Now())})
+	}
+
+	if err := count(); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	for {
+		var err error
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			err = count()
+		case item, ok := <-sub.Items:
+			if !ok {
+				return
+			}
+			err = writeServerEvent(w, item.Type, item)
+		}
+
+		// The client is gone
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerEvent(w http.ResponseWriter, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
+
+// publish shows a written batch item in the live view
+func (h *Handlers) publish(siteID int, write store.Write, now time.Time) {
+	switch {
+	case write.Session != nil:
+		h.live.Session(siteID, *write.Session, now)
+	case write.Event != nil:
+		h.live.Event(siteID, *write.Event, now)
+	case write.PageView != nil:
+		h.live.PageView(siteID, *write.PageView, now)
+	}
+}
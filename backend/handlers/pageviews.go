@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/logging"
+	"Borea/backend/metrics"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+// PostPageView stores one page of a session. Like events, views are linked by session_id
+// and can arrive before the session beacon. Posting a view again with the same viewId
+// records its time on page.
+func (h *Handlers) PostPageView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method != http.MethodPost && r.Method != http.MethodOptions {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	site, ok := h.resolveTrackingSite(w, r)
+	if !ok {
+		return
+	}
+
+	// Handle preflight request
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var view models.Page_view
+	if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+		metrics.Reject(metrics.ReasonBadJSON)
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	if msg := validatePageView(&view); msg != "" {
+		metrics.Reject(metrics.ReasonInvalid)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	logging.With(r.Context(), "session_id", view.SessionID)
+
+	err := h.store.UpsertPageView(r.Context(), site.ID, view)
+	if errors.Is(err, store.ErrViewOfAnotherSession) {
+		http.Error(w, "Page view belongs to another session", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("writing page view", "err", err)
+		http.Error(w, "Error writing page view", http.StatusInternalServerError)
+		return
+	}
+	h.live.PageView(site.ID, view, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"success": true}`))
+}
+
+// validatePageView returns a client facing error message, or "" if the view is valid.
+// Empty title and query are normalized to nil and the query loses its leading "?".
+func validatePageView(view *models.Page_view) string {
+	if !helper.IsValidUUID(view.ViewID) {
+		return "viewId must be a valid UUID"
+	}
+
+	if !helper.IsValidUUID(view.SessionID) {
+		return "sessionId must be a valid UUID"
+	}
+
+	if !strings.HasPrefix(view.Path, "/") {
+		return "path must start with /"
+	}
+
+	if view.Duration != nil && *view.Duration < 0 {
+		return "duration must not be negative"
+	}
+
+	if view.Title != nil && strings.TrimSpace(*view.Title) == "" {
+		view.Title = nil
+	}
+
+	if view.Query != nil {
+		query := strings.TrimPrefix(*view.Query, "?")
+		view.Query = &query
+		if query == "" {
+			view.Query = nil
+		}
+	}
+
+	return ""
+}
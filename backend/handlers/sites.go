@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"Borea/backend/logging"
+	"Borea/backend/metrics"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+)
+
+// resolveTrackingSite resolves the site of a tracking request and sets the CORS headers for it,
+// echoing the request origin since every site has its own allowed origins.
+// On failure the error response is written and ok is false.
+func (h *Handlers) resolveTrackingSite(w http.ResponseWriter, r *http.Request) (models.Site, bool) {
+	site, err := sites.FromRequest(h.store, r)
+	switch {
+	case errors.Is(err, sites.ErrNotFound):
+		metrics.Reject(metrics.ReasonBadToken)
+		http.Error(w, "Invalid token in request", http.StatusForbidden)
+	case errors.Is(err, sites.ErrOriginNotAllowed):
+		metrics.Reject(metrics.ReasonBadDomain)
+		http.Error(w, "Domain not allowed for this token", http.StatusForbidden)
+	case err != nil:
+		logging.FromContext(r.Context()).Error("resolving site", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	default:
+		if origin := r.Header.Get("Origin"); origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
+		logging.With(r.Context(), "site", site.ID)
+		return site, true
+	}
+
+	return models.Site{}, false
+}
+
+func (h *Handlers) GetSites(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list, err := h.store.ListSites(r.Context())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("listing sites", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// CreateSite expects {"name": ..., "allowedOrigins": [...]} and returns the site with its generated tracking token
+func (h *Handlers) CreateSite(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody models.Site
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	if requestBody.Name == "" || len(requestBody.AllowedOrigins) == 0 {
+		http.Error(w, "name and allowedOrigins are required", http.StatusBadRequest)
+		return
+	}
+
+	site, err := sites.Create(r.Context(), h.store, requestBody.Name, requestBody.AllowedOrigins)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("creating site", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(site)
+}
+
+// SetBotPolicy expects {"siteId": ..., "botPolicy": "drop" | "flag" | "keep"}
+func (h *Handlers) SetBotPolicy(w http.ResponseWriter, r *http.Request) {
+	DOMAIN := os.Getenv("DOMAIN")
+
+	w.Header().Set("Access-Control-Allow-Origin", DOMAIN)
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requestBody struct {
+		SiteID    int    `json:"siteId"`
+		BotPolicy string `json:"botPolicy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Error parsing JSON", http.StatusBadRequest)
+		return
+	}
+
+	err := sites.SetBotPolicy(r.Context(), h.store, requestBody.SiteID, requestBody.BotPolicy)
+	if errors.Is(err, sites.ErrInvalidBotPolicy) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, sites.ErrNotFound) {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("setting bot policy", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"success": true}`))
+}
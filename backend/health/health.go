@@ -0,0 +1,219 @@
+// Package health decides whether the backend should get traffic. The readiness probe runs every
+// check at once under one timeout: the database has to take writes with the schema this binary
+// expects, and the ingest queue must have room. GeoIP and settings are reported too, but a
+// backend without them still records sessions, so their failures only mark it degraded.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"Borea/backend/db"
+	"Borea/backend/geoip"
+	"Borea/backend/helper"
+	"Borea/backend/ingest"
+	"Borea/backend/models"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+)
+
+type Config struct {
+	Timeout        time.Duration // Longest a readiness probe waits on its checks
+	QueueThreshold int           // Percent of a worker's share of the ingest buffer in use at which the backend is not ready
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:        2 * time.Second,
+		QueueThreshold: 90,
+	}
+}
+
+// ConfigFromEnv reads HEALTH_TIMEOUT_MS and HEALTH_QUEUE_THRESHOLD_PERCENT
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	timeout := int(config.Timeout / time.Millisecond)
+	if err := helper.PositiveIntFromEnv("HEALTH_TIMEOUT_MS", &timeout); err != nil {
+		return config, err
+	}
+	config.Timeout = time.Duration(timeout) * time.Millisecond
+
+	if err := helper.PositiveIntFromEnv("HEALTH_QUEUE_THRESHOLD_PERCENT", &config.QueueThreshold); err != nil {
+		return config, err
+	}
+	if config.QueueThreshold > 100 {
+		return config, fmt.Errorf("HEALTH_QUEUE_THRESHOLD_PERCENT must be at most 100, got %d", config.QueueThreshold)
+	}
+
+	return config, nil
+}
+
+// Check is one thing readiness depends on. Run returns a short description of what it found,
+// it should give up when ctx is done.
+type Check struct {
+	Name     string
+	Critical bool // A failure makes the backend not ready, otherwise only degraded
+	Run      func(ctx context.Context) (detail string, err error)
+}
+
+type Checker struct {
+	timeout time.Duration
+	checks  []Check
+}
+
+func NewChecker(config Config, checks ...Check) *Checker {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConfig().Timeout
+	}
+	return &Checker{timeout: config.Timeout, checks: checks}
+}
+
+// Ready runs the checks concurrently and reports them in the order they were given. A check
+// still running at the timeout fails, it is left to finish in the background.
+func (c *Checker) Ready(ctx context.Context) models.Health_report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make([]chan models.Health_check, len(c.checks))
+	for i, check := range c.checks {
+		results[i] = make(chan models.Health_check, 1)
+		go func(check Check, result chan<- models.Health_check) {
+			result <- run(ctx, check)
+		}(check, results[i])
+	}
+
+	report := models.Health_report{Status: StatusOK, Checks: make([]models.Health_check, len(c.checks))}
+	for i, check := range c.checks {
+		var result models.Health_check
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			// Another check may have finished in the meantime
+			select {
+			case result = <-results[i]:
+			default:
+				result = failed(check, c.timeout, fmt.Errorf("timed out after %s", c.timeout))
+			}
+		}
+		report.Checks[i] = result
+
+		if result.Status == StatusFail {
+			if check.Critical {
+				report.Status = StatusFail
+			} else if report.Status == StatusOK {
+				report.Status = StatusDegraded
+			}
+		}
+	}
+
+	return report
+}
+
+func run(ctx context.Context, check Check) models.Health_check {
+	start := time.Now()
+	detail, err := check.Run(ctx)
+	if err != nil {
+		return failed(check, time.Since(start), err)
+	}
+
+	result := models.Health_check{
+		Name:       check.Name,
+		Status:     StatusOK,
+		Critical:   check.Critical,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if detail != "" {
+		result.Detail = &detail
+	}
+	return result
+}
+
+func failed(check Check, took time.Duration, err error) models.Health_check {
+	msg := err.Error()
+	return models.Health_check{
+		Name:       check.Name,
+		Status:     StatusFail,
+		Critical:   check.Critical,
+		DurationMs: took.Milliseconds(),
+		Error:      &msg,
+	}
+}
+
+// Database checks that Postgres answers and takes writes, a standby or a read only
+// database can't store sessions.
+func Database(database *sql.DB) Check {
+	return Check{Name: "database", Critical: true, Run: func(ctx context.Context) (string, error) {
+		var readOnly string
+		err := database.QueryRowContext(ctx, "SELECT current_setting('transaction_read_only')").Scan(&readOnly)
+		if err != nil {
+			return "", err
+		}
+		if readOnly == "on" {
+			return "", errors.New("database is read only")
+		}
+
+		stats := database.Stats()
+		return fmt.Sprintf("%d connections open, %d in use", stats.OpenConnections, stats.InUse), nil
+	}}
+}
+
+// Migrations checks that the schema is the one this binary was built for
+func Migrations(database *sql.DB) Check {
+	return Check{Name: "migrations", Critical: true, Run: func(ctx context.Context) (string, error) {
+		current, pending, err := db.PendingMigrations(ctx, database)
+		if err != nil {
+			return "", err
+		}
+		if pending > 0 {
+			return "", fmt.Errorf("schema version %d, %d migrations pending", current, pending)
+		}
+		return fmt.Sprintf("schema version %d", current), nil
+	}}
+}
+
+// Queue fails once threshold percent of any worker's share of the ingest buffer is in use, before
+// /postSession starts answering 503 for the sessions that hash to it
+func Queue(queue *ingest.Queue, threshold int) Check {
+	return Check{Name: "ingest_queue", Critical: true, Run: func(ctx context.Context) (string, error) {
+		length, capacity := queue.MaxShardFill()
+		detail := fmt.Sprintf("%d of %d sessions buffered, fullest worker holds %d of %d", queue.Len(), queue.Cap(), length, capacity)
+		if length*100 >= capacity*threshold {
+			return "", fmt.Errorf("%s, over %d%%", detail, threshold)
+		}
+		return detail, nil
+	}}
+}
+
+// GeoIP reports the loaded database. configured is whether GEOIP_DATABASE is set, without it
+// GeoIP is off on purpose.
+func GeoIP(locator *geoip.Locator, configured bool) Check {
+	return Check{Name: "geoip", Run: func(ctx context.Context) (string, error) {
+		switch {
+		case locator != nil:
+			return locator.Describe(), nil
+		case configured:
+			return "", errors.New("GEOIP_DATABASE is set but no database is loaded")
+		default:
+			return "disabled", nil
+		}
+	}}
+}
+
+// Settings reports settings the backend starts without but can't fully work without
+func Settings() Check {
+	return Check{Name: "config", Run: func(ctx context.Context) (string, error) {
+		if os.Getenv("SERVER_KEY") == "" {
+			return "", errors.New("SERVER_KEY is not set, dashboard logins are rejected")
+		}
+		return "", nil
+	}}
+}
@@ -2,26 +2,17 @@ package helper
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-func IsAllowedQuery(query, expectedCommand string) bool {
-	// Split the query to get the first word
-	queryWords := strings.Fields(query)
-	if len(queryWords) == 0 {
-		return false
-	}
-
-	return strings.ToUpper(queryWords[0]) == expectedCommand
-}
-
+// ParseDomainRequest returns the scheme and host of the page that made the request, based on the Referer header
 func ParseDomainRequest(r *http.Request) string {
-	scheme := "http://"
-	if r.TLS != nil {
-		scheme = "https://"
-	}
 	referer := r.Referer()
 	if referer == "" {
 		return ""
@@ -29,10 +20,167 @@ func ParseDomainRequest(r *http.Request) string {
 
 	// Parse the referer URL
 	parsedURL, err := url.Parse(referer)
-	if err != nil {
+	if err != nil || parsedURL.Host == "" {
 		return ""
 	}
 
 	// Get the scheme and host
-	return fmt.Sprintf("%s%s", scheme, parsedURL.Host)
-}
\ No newline at end of file
+	return NormalizeOrigin(fmt.Sprintf("%s://%s", parsedURL.Scheme, parsedURL.Host))
+}
+
+// RequestOrigin prefers the Origin header and falls back to the Referer, which is
+// all a browser sends when loading a <script> tag.
+func RequestOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	if origin != "" && origin != "null" {
+		return NormalizeOrigin(origin)
+	}
+
+	return ParseDomainRequest(r)
+}
+
+// NormalizeOrigin lowercases an origin and drops any trailing slash so origins compare as strings
+func NormalizeOrigin(origin string) string {
+	return strings.TrimRight(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// ParseTrustedProxies reads a comma separated list of IPs and CIDR ranges, e.g. "10.0.0.0/8, 192.168.1.4"
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+// ClientIP returns the address of whoever made the request. X-Forwarded-For is only believed
+// when the connection comes from a trusted proxy, and is read from the right so a client
+// cannot prepend a fake address. nil if the address cannot be parsed.
+func ClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrusted(ip, trustedProxies) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// Whatever sent a malformed entry can't be trusted with the ones before it
+			return ip
+		}
+
+		ip = hop
+		if !isTrusted(ip, trustedProxies) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func IsValidUUID(id string) bool {
+	return uuidPattern.MatchString(id)
+}
+
+// PositiveIntFromEnv sets value to the env var name when it is set, leaving the default otherwise
+func PositiveIntFromEnv(name string, value *int) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+
+	parsed, err := strconv.Atoi(raw)
+	if err != nil || parsed < 1 {
+		return fmt.Errorf("%s must be a positive integer", name)
+	}
+
+	*value = parsed
+	return nil
+}
+
+// StatusWriter keeps the status code of the response it wraps, for middleware that reports it.
+// It flushes like the writer it wraps, so streaming handlers still work through it.
+type StatusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w}
+}
+
+func (s *StatusWriter) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *StatusWriter) Write(p []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+func (s *StatusWriter) Flush() {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *StatusWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Status is the code sent, 200 when the handler wrote nothing
+func (s *StatusWriter) Status() int {
+	if s.code == 0 {
+		return http.StatusOK
+	}
+	return s.code
+}
@@ -0,0 +1,237 @@
+// Package ingest buffers session writes in memory so tracking requests don't wait on Postgres.
+// Background workers flush the buffer in batches, and the buffer is drained on shutdown.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/models"
+)
+
+var (
+	ErrQueueFull   = errors.New("ingest queue is full")
+	ErrQueueClosed = errors.New("ingest queue is closed")
+)
+
+// FlushFunc writes one batch, it must not keep the slice after returning
+type FlushFunc func(batch []models.Site_session) error
+
+type Config struct {
+	BufferSize    int           // Sessions held in memory before requests get 503
+	Workers       int           // Concurrent flushes, each with its own share of the buffer
+	BatchSize     int           // Sessions per flush
+	FlushInterval time.Duration // Longest a session waits for a batch to fill
+}
+
+func DefaultConfig() Config {
+	return Config{
+		BufferSize:    10000,
+		Workers:       2,
+		BatchSize:     500,
+		FlushInterval: time.Second,
+	}
+}
+
+// ConfigFromEnv reads INGEST_BUFFER_SIZE, INGEST_WORKERS, INGEST_BATCH_SIZE and INGEST_FLUSH_INTERVAL_MS
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	settings := []struct {
+		name  string
+		value *int
+	}{
+		{"INGEST_BUFFER_SIZE", &config.BufferSize},
+		{"INGEST_WORKERS", &config.Workers},
+		{"INGEST_BATCH_SIZE", &config.BatchSize},
+	}
+
+	for _, setting := range settings {
+		if err := helper.PositiveIntFromEnv(setting.name, setting.value); err != nil {
+			return config, err
+		}
+	}
+
+	interval := int(config.FlushInterval / time.Millisecond)
+	if err := helper.PositiveIntFromEnv("INGEST_FLUSH_INTERVAL_MS", &interval); err != nil {
+		return config, err
+	}
+	config.FlushInterval = time.Duration(interval) * time.Millisecond
+
+	return config, nil
+}
+
+type Queue struct {
+	config  Config
+	flush   FlushFunc
+	workers []chan models.Site_session
+
+	mu      sync.RWMutex
+	closed  bool
+	started bool
+	wg      sync.WaitGroup
+}
+
+func NewQueue(config Config, flush FlushFunc) *Queue {
+	defaults := DefaultConfig()
+	if config.Workers < 1 {
+		config.Workers = defaults.Workers
+	}
+	if config.BufferSize < config.Workers {
+		config.BufferSize = config.Workers
+	}
+	if config.BatchSize < 1 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+
+	q := &Queue{config: config, flush: flush}
+	for i := 0; i < config.Workers; i++ {
+		q.workers = append(q.workers, make(chan models.Site_session, config.BufferSize/config.Workers))
+	}
+
+	return q
+}
+
+// Start launches the workers. Sessions enqueued before Start wait in the buffer.
+func (q *Queue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.started {
+		return
+	}
+	q.started = true
+
+	for _, items := range q.workers {
+		q.wg.Add(1)
+		go q.work(items)
+	}
+}
+
+// Enqueue never blocks, a full buffer is reported so the caller can shed load.
+func (q *Queue) Enqueue(session models.Site_session) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	// The same session always lands on the same worker, so its updates are written in order
+	hash := fnv.New32a()
+	hash.Write([]byte(session.Session.SessionID))
+
+	select {
+	case q.workers[hash.Sum32()%uint32(len(q.workers))] <- session:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Len is the number of sessions waiting to be flushed
+func (q *Queue) Len() int {
+	total := 0
+	for _, items := range q.workers {
+		total += len(items)
+	}
+	return total
+}
+
+// Cap is the number of sessions the buffer holds before Enqueue reports it full
+func (q *Queue) Cap() int {
+	total := 0
+	for _, items := range q.workers {
+		total += cap(items)
+	}
+	return total
+}
+
+// MaxShardFill is the length and capacity of the fullest worker's share of the buffer. Enqueue
+// reports the queue full once the share a session hashes to is, however empty the others are.
+func (q *Queue) MaxShardFill() (length, capacity int) {
+	for _, items := range q.workers {
+		// len/cap above length/capacity, without dividing
+		if capacity == 0 || len(items)*capacity > length*cap(items) {
+			length, capacity = len(items), cap(items)
+		}
+	}
+	return length, capacity
+}
+
+// Close stops accepting sessions and waits until the buffer is flushed or ctx is done.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, items := range q.workers {
+			close(items)
+		}
+	}
+	started := q.started
+	q.mu.Unlock()
+
+	// Nothing would ever drain the buffer otherwise
+	if !started {
+		q.Start()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ingest queue not drained, %d sessions left: %w", q.Len(), ctx.Err())
+	}
+}
+
+func (q *Queue) work(items <-chan models.Site_session) {
+	defer q.wg.Done()
+
+	batch := make([]models.Site_session, 0, q.config.BatchSize)
+	ticker := time.NewTicker(q.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case session, ok := <-items:
+			if !ok {
+				q.flushBatch(batch)
+				return
+			}
+
+			batch = append(batch, session)
+			if len(batch) >= q.config.BatchSize {
+				q.flushBatch(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			q.flushBatch(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (q *Queue) flushBatch(batch []models.Site_session) {
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := q.flush(batch); err != nil {
+		slog.Error("flushing sessions", "component", "ingest", "sessions", len(batch), "err", err)
+	}
+}
@@ -0,0 +1,268 @@
+// Package live keeps the sessions active on each site in memory and fans out what the tracking
+// endpoints ingest to /live subscribers, so the live view never queries Postgres. It only sees
+// the traffic of its own process, behind a load balancer every backend reports its own share.
+package live
+
+import (
+	"sync"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/models"
+)
+
+// Items buffered per subscriber, a subscriber that falls further behind misses items
+// instead of slowing down ingestion
+const subscriberBuffer = 256
+
+type Config struct {
+	Window   time.Duration // A session is active while it was seen within Window
+	Interval time.Duration // How often subscribers get the active count
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Window:   5 * time.Minute,
+		Interval: 5 * time.Second,
+	}
+}
+
+// ConfigFromEnv reads LIVE_ACTIVE_WINDOW_SECONDS and LIVE_INTERVAL_SECONDS
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	window := int(config.Window / time.Second)
+	if err := helper.PositiveIntFromEnv("LIVE_ACTIVE_WINDOW_SECONDS", &window); err != nil {
+		return config, err
+	}
+	config.Window = time.Duration(window) * time.Second
+
+	interval := int(config.Interval / time.Second)
+	if err := helper.PositiveIntFromEnv("LIVE_INTERVAL_SECONDS", &interval); err != nil {
+		return config, err
+	}
+	config.Interval = time.Duration(interval) * time.Second
+
+	return config, nil
+}
+
+// Hub is safe for concurrent use. A nil Hub ignores what it is given, like a live view nobody opened.
+type Hub struct {
+	config Config
+
+	mu          sync.Mutex
+	sites       map[int]*site
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+type site struct {
+	sessions map[string]*activity
+	swept    time.Time
+}
+
+type activity struct {
+	lastSeen  time.Time
+	announced bool // A session beacon arrived, before that the session is not counted
+	bot       bool
+}
+
+// Subscription receives the items of one site, or of all sites for site 0.
+// Items is closed when the hub shuts down.
+type Subscription struct {
+	SiteID int
+	Items  <-chan models.Live_item
+	items  chan models.Live_item
+}
+
+func NewHub(config Config) *Hub {
+	return &Hub{
+		config:      config,
+		sites:       make(map[int]*site),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Interval() time.Duration {
+	return h.config.Interval
+}
+
+// Session records a session beacon. A session is announced to subscribers when it becomes active,
+// not on every beacon. Flagged bots are neither announced nor counted, and their events are not shown.
+func (h *Hub) Session(siteID int, session models.Session, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	a := h.touch(siteID, session.SessionID, now)
+	if session.IsBot {
+		a.bot = true
+		return
+	}
+	if a.announced || a.bot {
+		return
+	}
+	a.announced = true
+
+	h.publish(models.Live_item{
+		Type:      "session",
+		SiteID:    siteID,
+		SessionID: session.SessionID,
+		Time:      now,
+		Channel:   session.Channel,
+		Country:   session.Location.Country,
+		Browser:   session.Client.Browser,
+	})
+}
+
+func (h *Hub) Event(siteID int, event models.Event, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if a := h.touch(siteID, event.SessionID, now); a.bot {
+		return
+	}
+
+	h.publish(models.Live_item{Type: "event", SiteID: siteID, SessionID: event.SessionID, Time: now, Name: event.Name})
+}
+
+// PageView shows a view when the page is entered, the update with its duration only keeps the session active
+func (h *Hub) PageView(siteID int, view models.Page_view, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if a := h.touch(siteID, view.SessionID, now); a.bot || view.Duration != nil {
+		return
+	}
+
+	h.publish(models.Live_item{Type: "pageview", SiteID: siteID, SessionID: view.SessionID, Time: now, Path: view.Path})
+}
+
+// Active counts the sessions of a site seen within the window, site 0 counts all sites
+func (h *Hub) Active(siteID int, now time.Time) int {
+	if h == nil {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := 0
+	for id, s := range h.sites {
+		if siteID != 0 && id != siteID {
+			continue
+		}
+
+		h.sweep(s, now)
+		for _, a := range s.sessions {
+			if a.announced && !a.bot {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func (h *Hub) Subscribe(siteID int) *Subscription {
+	items := make(chan models.Live_item, subscriberBuffer)
+	sub := &Subscription{SiteID: siteID, Items: items, items: items}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(items)
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.items)
+	}
+}
+
+// Close ends every subscription, so open streams don't hold up a graceful shutdown
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.items)
+	}
+}
+
+// touch marks a session as seen, h.mu must be held
+func (h *Hub) touch(siteID int, sessionID string, now time.Time) *activity {
+	s, ok := h.sites[siteID]
+	if !ok {
+		s = &site{sessions: make(map[string]*activity), swept: now}
+		h.sites[siteID] = s
+	}
+
+	// Sessions that went quiet are only forgotten here and in Active, so sites nobody
+	// watches are swept about once per window
+	if now.Sub(s.swept) > h.config.Window {
+		h.sweep(s, now)
+	}
+
+	a, ok := s.sessions[sessionID]
+	if !ok || now.Sub(a.lastSeen) > h.config.Window {
+		a = &activity{}
+		s.sessions[sessionID] = a
+	}
+	if now.After(a.lastSeen) {
+		a.lastSeen = now
+	}
+
+	return a
+}
+
+// sweep forgets the sessions of s that are no longer active, h.mu must be held
+func (h *Hub) sweep(s *site, now time.Time) {
+	for id, a := range s.sessions {
+		if now.Sub(a.lastSeen) > h.config.Window {
+			delete(s.sessions, id)
+		}
+	}
+	s.swept = now
+}
+
+// publish hands item to the subscribers of its site without waiting on them, h.mu must be held
+func (h *Hub) publish(item models.Live_item) {
+	for sub := range h.subscribers {
+		if sub.SiteID != 0 && sub.SiteID != item.SiteID {
+			continue
+		}
+
+		select {
+		case sub.items <- item:
+		default:
+		}
+	}
+}
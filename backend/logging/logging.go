@@ -0,0 +1,164 @@
+// Package logging sets up the backend's structured logger and carries a logger for each request
+// through its context. Middleware gives every request an ID, taken from X-Request-ID when the
+// client or a proxy sent one, and every line logged for the request carries it, along with
+// fields handlers add such as the site. A failed beacon's request line and the store error
+// behind it share the request_id.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"Borea/backend/helper"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// A request ID from outside is kept when it is short and plain enough to log as is
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Probes and scrapes come every few seconds, they are only logged at debug level unless they fail
+var quietRoutes = map[string]bool{
+	"/ping":    true,
+	"/livez":   true,
+	"/readyz":  true,
+	"/metrics": true,
+}
+
+type Config struct {
+	Level  slog.Level
+	Format string // FormatJSON, or FormatText to read logs in a terminal
+}
+
+func DefaultConfig() Config {
+	return Config{Level: slog.LevelInfo, Format: FormatJSON}
+}
+
+// ConfigFromEnv reads LOG_LEVEL (debug, info, warn or error) and LOG_FORMAT (json or text)
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := config.Level.UnmarshalText([]byte(level)); err != nil {
+			return config, fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", level)
+		}
+	}
+
+	if format := strings.ToLower(os.Getenv("LOG_FORMAT")); format != "" {
+		if format != FormatJSON && format != FormatText {
+			return config, fmt.Errorf("LOG_FORMAT must be json or text, got %q", format)
+		}
+		config.Format = format
+	}
+
+	return config, nil
+}
+
+func New(config Config, out io.Writer) *slog.Logger {
+	options := &slog.HandlerOptions{Level: config.Level}
+	if config.Format == FormatText {
+		return slog.New(slog.NewTextHandler(out, options))
+	}
+	return slog.New(slog.NewJSONHandler(out, options))
+}
+
+type contextKey struct{}
+
+// requestLog is shared by the middleware and the handler, so fields a handler adds
+// reach the request line the middleware writes at the end
+type requestLog struct {
+	id     string
+	logger *slog.Logger
+}
+
+// FromContext returns the logger of the request ctx belongs to, or the default logger
+// outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if log, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		return log.logger
+	}
+	return slog.Default()
+}
+
+// NewContext returns a context whose FromContext is logger, for work done outside of requests
+// such as the background jobs
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLog{logger: logger})
+}
+
+// With adds fields to every later line of the request, including its request line.
+// Outside of a request it does nothing.
+func With(ctx context.Context, args ...any) {
+	if log, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		log.logger = log.logger.With(args...)
+	}
+}
+
+// RequestID returns the ID of the request ctx belongs to, "" outside of a request
+func RequestID(ctx context.Context) string {
+	if log, ok := ctx.Value(contextKey{}).(*requestLog); ok {
+		return log.id
+	}
+	return ""
+}
+
+// Middleware assigns the request ID, echoes it in the response and logs one line per request
+// with its handler, status and latency. Failed requests are logged as warnings (4xx) or
+// errors (5xx). It reads the handler from the ServeMux pattern, so next has to be a ServeMux
+// or wrap one.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		log := &requestLog{id: id, logger: slog.Default().With("request_id", id)}
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, log))
+		recorder := helper.NewStatusWriter(w)
+
+		next.ServeHTTP(recorder, r)
+
+		status := recorder.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		case quietRoutes[r.Pattern]:
+			level = slog.LevelDebug
+		}
+
+		log.logger.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("handler", r.Pattern),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+		)
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -6,14 +6,29 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"Borea/backend/auth"
+	"Borea/backend/channels"
+	"Borea/backend/commands"
 	"Borea/backend/db"
+	"Borea/backend/geoip"
 	"Borea/backend/handlers"
+	"Borea/backend/health"
+	"Borea/backend/ingest"
+	"Borea/backend/live"
+	"Borea/backend/logging"
+	"Borea/backend/metrics"
+	"Borea/backend/models"
+	"Borea/backend/rollup"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"Borea/backend/webhooks"
 )
 
 var (
@@ -22,52 +37,197 @@ var (
 )
 
 func main() {
-	err := db.InitDB()
+	logConfig, err := logging.ConfigFromEnv()
 	if err != nil {
 		log.Fatalf("Error: %s", err)
 	}
+	// Also sends what the log package and net/http log through the structured logger
+	slog.SetDefault(logging.New(logConfig, os.Stderr))
+
+	err = db.InitDB()
+	if err != nil {
+		fatal("connecting to the database", err)
+	}
 
 	defer db.DB.Close()
 
-	http.HandleFunc("/getItems", handlers.GetItems)
-	http.HandleFunc("/getItem", handlers.GetItem)
-	http.HandleFunc("/createItem", handlers.CreateItem)
-	http.HandleFunc("/updateItem", handlers.UpdateItem)
-	http.HandleFunc("/script", handlers.HandleScriptRequest)
-	http.HandleFunc("/postSession", handlers.PostSessionData)
+	postgres := store.NewPostgres(db.DB)
+
+	if len(os.Args) > 1 {
+		if err := commands.Run(postgres, os.Args[1:]); err != nil {
+			fatal("running "+os.Args[1], err)
+		}
+		return
+	}
+
+	// Also refuses to start on a schema newer than this binary
+	applied, err := db.MigrateUp()
+	if err != nil {
+		fatal("migrating the database", err)
+	}
+	if applied > 0 {
+		slog.Info("applied database migrations", "migrations", applied)
+	}
+
+	// Installs from before multi-site support configure their one site through the env
+	if err := sites.EnsureDefault(context.Background(), postgres, os.Getenv("API_TOKEN"), os.Getenv("DOMAIN")); err != nil {
+		slog.Error("registering default site", "err", err)
+	}
+
+	if err := channels.EnsureDefaults(context.Background(), postgres); err != nil {
+		slog.Error("storing default channel rules", "err", err)
+	}
+
+	ingestConfig, err := ingest.ConfigFromEnv()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	ingestContext := logging.NewContext(context.Background(), slog.With("component", "ingest"))
+	sessions := ingest.NewQueue(ingestConfig, func(batch []models.Site_session) error {
+		return postgres.UpsertSessions(ingestContext, batch)
+	})
+	sessions.Start()
+	metrics.WatchQueue(sessions.Len)
+	metrics.WatchDB(db.DB)
+
+	geo, err := geoip.FromEnv()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	defer geo.Close()
+
+	liveConfig, err := live.ConfigFromEnv()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	hub := live.NewHub(liveConfig)
+
+	retentionConfig, err := rollup.ConfigFromEnv()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	retention := rollup.NewJob(postgres, retentionConfig)
+	retention.Start()
+
+	webhookConfig, err := webhooks.ConfigFromEnv()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	deliveries := webhooks.NewWorker(postgres, webhookConfig)
+	deliveries.Start()
 
+	healthConfig, err := health.ConfigFromEnv()
+	if err != nil {
+		fatal("loading config", err)
+	}
+	readiness := health.NewChecker(healthConfig,
+		health.Database(db.DB),
+		health.Migrations(db.DB),
+		health.Queue(sessions, healthConfig.QueueThreshold),
+		health.GeoIP(geo, os.Getenv("GEOIP_DATABASE") != ""),
+		health.Settings(),
+	)
+
+	h := handlers.New(postgres, sessions, geo, hub)
+	authenticator := auth.NewAuthenticator(postgres)
+
+	// Data endpoints require the dashboard's JWT or a scoped API key
+	http.HandleFunc("/getAdminUser", authenticator.Require(auth.ScopeLogin, h.GetAdminUser))
+	http.HandleFunc("/getSites", authenticator.Require(auth.ScopeRead, h.GetSites))
+	http.HandleFunc("/createSite", authenticator.Require(auth.ScopeWrite, h.CreateSite))
+	http.HandleFunc("/setBotPolicy", authenticator.Require(auth.ScopeWrite, h.SetBotPolicy))
+	http.HandleFunc("/getChannelRules", authenticator.Require(auth.ScopeRead, h.GetChannelRules))
+	http.HandleFunc("/createChannelRule", authenticator.Require(auth.ScopeWrite, h.CreateChannelRule))
+	http.HandleFunc("/deleteChannelRule", authenticator.Require(auth.ScopeWrite, h.DeleteChannelRule))
+	http.HandleFunc("/getWebhooks", authenticator.Require(auth.ScopeRead, h.GetWebhooks))
+	http.HandleFunc("/createWebhook", authenticator.Require(auth.ScopeWrite, h.CreateWebhook))
+	http.HandleFunc("/deleteWebhook", authenticator.Require(auth.ScopeWrite, h.DeleteWebhook))
+	http.HandleFunc("/getWebhookDeliveries", authenticator.Require(auth.ScopeRead, h.GetWebhookDeliveries))
+	http.HandleFunc("/script", h.HandleScriptRequest)
+	http.HandleFunc("/postSession", h.PostSessionData)
+	http.HandleFunc("/event", h.PostEvent)
+	http.HandleFunc("/pageview", h.PostPageView)
+	http.HandleFunc("/batch", h.PostBatch)
+
+	http.HandleFunc("/analytics/sessions", authenticator.Require(auth.ScopeRead, h.GetSessionsOverTime))
+	http.HandleFunc("/analytics/duration", authenticator.Require(auth.ScopeRead, h.GetAverageDuration))
+	http.HandleFunc("/analytics/referrers", authenticator.Require(auth.ScopeRead, h.GetTopReferrers))
+	http.HandleFunc("/analytics/languages", authenticator.Require(auth.ScopeRead, h.GetLanguages))
+	http.HandleFunc("/analytics/browsers", authenticator.Require(auth.ScopeRead, h.GetBrowsers))
+	http.HandleFunc("/analytics/os", authenticator.Require(auth.ScopeRead, h.GetOperatingSystems))
+	http.HandleFunc("/analytics/devices", authenticator.Require(auth.ScopeRead, h.GetDevices))
+	http.HandleFunc("/analytics/countries", authenticator.Require(auth.ScopeRead, h.GetCountries))
+	http.HandleFunc("/analytics/channels", authenticator.Require(auth.ScopeRead, h.GetChannels))
+	http.HandleFunc("/analytics/campaigns", authenticator.Require(auth.ScopeRead, h.GetCampaigns))
+	http.HandleFunc("/analytics/pages", authenticator.Require(auth.ScopeRead, h.GetTopPages))
+	http.HandleFunc("/analytics/entry-pages", authenticator.Require(auth.ScopeRead, h.GetEntryPages))
+	http.HandleFunc("/analytics/exit-pages", authenticator.Require(auth.ScopeRead, h.GetExitPages))
+	http.HandleFunc("/analytics/funnel", authenticator.Require(auth.ScopeRead, h.GetFunnel))
+	http.HandleFunc("/analytics/retention", authenticator.Require(auth.ScopeRead, h.GetRetention))
+	http.HandleFunc("/live", authenticator.Require(auth.ScopeRead, h.GetLive))
+	http.HandleFunc("/export/sessions", authenticator.Require(auth.ScopeRead, h.ExportSessions))
+	http.HandleFunc("/export/events", authenticator.Require(auth.ScopeRead, h.ExportEvents))
+	http.HandleFunc("/export/pageviews", authenticator.Require(auth.ScopeRead, h.ExportPageViews))
+
+	// Probes for load balancers and orchestrators, /ping stays for older setups
 	http.HandleFunc("/ping", handlers.PingHandler)
+	http.HandleFunc("/livez", handlers.LivenessHandler)
+	http.HandleFunc("/readyz", handlers.ReadinessHandler(readiness))
+	http.HandleFunc("/metrics", authenticator.Require(auth.ScopeRead, metrics.Handler().ServeHTTP))
 
 	GO_PORT = os.Getenv("GO_PORT")
 	URL = fmt.Sprintf("0.0.0.0:%s", GO_PORT) // Changed from localhost to 0.0.0.0 for prod
 
 	server := &http.Server{
-		Addr:    URL,
-		Handler: nil,
+		Addr:     URL,
+		Handler:  logging.Middleware(metrics.Instrument(http.DefaultServeMux)),
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
 	}
+	// Live streams never finish on their own, Shutdown would wait on them until it times out
+	server.RegisterOnShutdown(hub.Close)
 
 	go func() {
-		log.Printf("Server starting on %s\n", URL)
+		slog.Info("server starting", "address", URL)
 		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("Server init error: %v", err)
+			fatal("starting the server", err)
 		}
 	}()
 
-	waitForShutdown(server)
+	waitForShutdown(server, sessions, retention, deliveries)
 }
 
-func waitForShutdown(server *http.Server) {
+func waitForShutdown(server *http.Server, sessions *ingest.Queue, retention *rollup.Job, deliveries *webhooks.Worker) {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
-	log.Println("Server is shutting down...")
+	slog.Info("server shutting down")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		fatal("shutting down the server", err)
+	}
+
+	// No handler can enqueue anymore, write out what is still buffered
+	if err := sessions.Close(ctx); err != nil {
+		slog.Error("draining ingest queue", "err", err)
+	}
+
+	if err := retention.Close(ctx); err != nil {
+		slog.Error("stopping data retention", "err", err)
 	}
 
-	log.Println("Server exiting")
+	// Deliveries the ingest queue just queued are sent by whichever backend runs next
+	if err := deliveries.Close(ctx); err != nil {
+		slog.Error("stopping webhook deliveries", "err", err)
+	}
+
+	slog.Info("server exiting")
+}
+
+// fatal is log.Fatalf for the structured logger
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -0,0 +1,120 @@
+// Package metrics exposes the backend's Prometheus metrics: requests and their latency per route
+// and status, requests turned away and why, session beacons accepted per site, the ingest queue
+// depth and the database connection pool.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Borea/backend/helper"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Reasons a tracking request is rejected, the values of the reason label
+const (
+	ReasonBadToken  = "bad_token"  // The tracking token matches no site
+	ReasonBadDomain = "bad_domain" // The Origin is not allowed for the site
+	ReasonBadJSON   = "bad_json"   // The body does not parse
+	ReasonInvalid   = "invalid"    // The body parses but fails validation
+	ReasonQueueFull = "queue_full" // The ingest queue is full, the client is asked to retry
+)
+
+// unmatched is the handler label of requests no route matched, so unknown paths can't blow up the label set
+const unmatched = "unmatched"
+
+var registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borea_http_requests_total",
+		Help: "HTTP requests by route and status code.",
+	}, []string{"handler", "code"})
+
+	latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "borea_http_request_duration_seconds",
+		Help:    "Time until the response was written, by route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "code"})
+
+	rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borea_rejected_requests_total",
+		Help: "Tracking requests rejected, by reason.",
+	}, []string{"reason"})
+
+	sessions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "borea_sessions_ingested_total",
+		Help: "Session beacons accepted, by site.",
+	}, []string{"site"})
+)
+
+func init() {
+	registry.MustRegister(
+		requests,
+		latency,
+		rejected,
+		sessions,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	for _, reason := range []string{ReasonBadToken, ReasonBadDomain, ReasonBadJSON, ReasonInvalid, ReasonQueueFull} {
+		rejected.WithLabelValues(reason)
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Reject counts a tracking request turned away for one of the Reason constants
+func Reject(reason string) {
+	rejected.WithLabelValues(reason).Inc()
+}
+
+// SessionsIngested counts session beacons accepted for a site
+func SessionsIngested(siteID int, count int) {
+	sessions.WithLabelValues(strconv.Itoa(siteID)).Add(float64(count))
+}
+
+// WatchQueue reports the depth of the ingest queue, read on every scrape
+func WatchQueue(depth func() int) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "borea_ingest_queue_depth",
+		Help: "Session beacons waiting in the ingest queue to be written.",
+	}, func() float64 {
+		return float64(depth())
+	}))
+}
+
+// WatchDB reports the connection pool stats of db as the go_sql_* metrics
+func WatchDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, "borea"))
+}
+
+// Instrument counts and times every request next serves. Routes are told apart by the ServeMux
+// pattern that matched, so next has to be a ServeMux or wrap one.
+func Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := helper.NewStatusWriter(w)
+
+		next.ServeHTTP(recorder, r)
+
+		// The mux sets the pattern on the request it was given
+		handler := r.Pattern
+		if handler == "" {
+			handler = unmatched
+		}
+		code := strconv.Itoa(recorder.Status())
+
+		requests.WithLabelValues(handler, code).Inc()
+		latency.WithLabelValues(handler, code).Observe(time.Since(start).Seconds())
+	})
+}
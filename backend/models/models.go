@@ -3,6 +3,11 @@
 
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Auth_item struct {
 	ID           int    `json:"ID"`
 	Username     string `json:"username"`
@@ -13,3 +18,265 @@ type Request_body struct {
 	Query  string        `json:"query"`
 	Params []interface{} `json:"params"`
 }
+
+// Session is the beacon Borea.js posts to /postSession, fields it did not send are nil
+type Session struct {
+	SessionID        string     `json:"sessionId"`
+	LastActivityTime *time.Time `json:"lastActivityTime"`
+	UserID           *string    `json:"userId"`
+	SessionDuration  *int64     `json:"sessionDuration"` // Milliseconds
+	UserAgent        *string    `json:"userAgent"`
+	Referrer         *string    `json:"referrer"`
+	Token            *string    `json:"token"`
+	StartTime        *time.Time `json:"startTime"`
+	Language         *string    `json:"language"`
+	LandingPage      *string    `json:"landingPage"` // URL of the first page, only its utm_ parameters are kept
+
+	// Client is parsed from UserAgent and Location looked up from the client IP on the server,
+	// a beacon cannot set them
+	Client   User_agent `json:"-"`
+	Location Location   `json:"-"`
+	// IsBot is set when bot detection flagged the session, see the bots package
+	IsBot bool `json:"-"`
+	// Campaign is parsed from LandingPage and Channel classified by the channels package
+	Campaign Campaign `json:"-"`
+	Channel  *string  `json:"-"`
+}
+
+// Campaign is the utm_ parameters of a session's landing page, nil when not present
+type Campaign struct {
+	Source  *string `json:"source"`
+	Medium  *string `json:"medium"`
+	Name    *string `json:"name"` // utm_campaign
+	Term    *string `json:"term"`
+	Content *string `json:"content"`
+}
+
+// Channel_rule classifies a session into Channel when Pattern matches its Field.
+// Rules run by ascending Priority, the first match wins.
+type Channel_rule struct {
+	ID       int    `json:"id"`
+	Channel  string `json:"channel"`  // search, social, email, direct, internal, paid or referral
+	Field    string `json:"field"`    // referrer_host, utm_source, utm_medium or utm_campaign
+	Pattern  string `json:"pattern"`  // Case insensitive regular expression
+	Priority int    `json:"priority"` // Lower runs first
+}
+
+// Location is where a session's IP resolved to in the GeoIP database, the IP itself is never stored
+type Location struct {
+	Country *string `json:"country"` // ISO 3166-1 code, e.g. "US"
+	Region  *string `json:"region"`  // First subdivision, e.g. "California"
+	City    *string `json:"city"`
+}
+
+// User_agent is what the backend reads out of a raw user agent, nil when it could not tell
+type User_agent struct {
+	Browser        *string `json:"browser"`
+	BrowserVersion *string `json:"browserVersion"`
+	OS             *string `json:"os"`
+	OSVersion      *string `json:"osVersion"`
+	Device         *string `json:"device"` // desktop, mobile, tablet or bot
+}
+
+// Site_session is a session resolved to the site it was tracked on
+type Site_session struct {
+	SiteID  int
+	Session Session
+}
+
+// Site_event is an event with the site it was tracked on
+type Site_event struct {
+	SiteID int
+	Event  Event
+}
+
+// Site_view is a page view with the site it was tracked on
+type Site_view struct {
+	SiteID int
+	View   Page_view
+}
+
+type Event struct {
+	SessionID  string          `json:"sessionId"`
+	Name       string          `json:"name"`
+	Timestamp  *time.Time      `json:"timestamp"`
+	PageURL    string          `json:"pageUrl"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+// Page_view is one page of a session, every route an SPA navigates to is a page of its own.
+// Borea.js posts it when the page is entered and again with Duration when it is left.
+type Page_view struct {
+	ViewID    string     `json:"viewId"` // Posting the same view again updates it
+	SessionID string     `json:"sessionId"`
+	Path      string     `json:"path"`
+	Title     *string    `json:"title"`
+	Query     *string    `json:"query"`
+	Timestamp *time.Time `json:"timestamp"` // When the page was entered
+	Duration  *int64     `json:"duration"`  // Time on page in milliseconds
+}
+
+// Batch_item is one entry of a /batch request. Type is "session", "event" or "pageview" and
+// Data holds the same JSON object /postSession, /event or /pageview would accept.
+type Batch_item struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type Batch_result struct {
+	Index   int    `json:"index"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Live_item is one entry of the /live feed. Type is "session" when a session becomes active,
+// "event" or "pageview", fields that don't belong to the type are left out.
+type Live_item struct {
+	Type      string    `json:"type"`
+	SiteID    int       `json:"site"`
+	SessionID string    `json:"sessionId"`
+	Time      time.Time `json:"time"`              // When the backend received it
+	Name      string    `json:"name,omitempty"`    // Event name
+	Path      string    `json:"path,omitempty"`    // Page path
+	Channel   *string   `json:"channel,omitempty"` // Session channel, country and browser
+	Country   *string   `json:"country,omitempty"`
+	Browser   *string   `json:"browser,omitempty"`
+}
+
+// Live_count is the number of sessions active on a site right now, Site is 0 for all sites
+type Live_count struct {
+	SiteID int `json:"site"`
+	Active int `json:"active"`
+}
+
+// Health_report answers the liveness and readiness probes. Status is "ok", "degraded" when only
+// checks the backend can serve without fail, or "fail" when it should not get traffic.
+type Health_report struct {
+	Status string         `json:"status"`
+	Checks []Health_check `json:"checks,omitempty"`
+}
+
+type Health_check struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Critical   bool    `json:"critical"`
+	DurationMs int64   `json:"durationMs"`
+	Detail     *string `json:"detail,omitempty"`
+	Error      *string `json:"error,omitempty"`
+}
+
+// Analytics responses. Date is the first day of the bucket as YYYY-MM-DD.
+type Time_bucket struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type Duration_bucket struct {
+	Date            string  `json:"date"`
+	AverageDuration float64 `json:"averageDuration"`
+}
+
+type Breakdown_row struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Page_row is a page of the top pages report
+type Page_row struct {
+	Path            string  `json:"path"`
+	Views           int     `json:"views"`
+	Sessions        int     `json:"sessions"`        // Distinct sessions that viewed it
+	AverageDuration float64 `json:"averageDuration"` // Time on page in milliseconds, 0 when never left
+}
+
+// Unique_user is a user of a site across their sessions, sessions flagged as bots are not counted
+type Unique_user struct {
+	SiteID       int       `json:"site"`
+	UserID       string    `json:"userId"`
+	FirstSeen    time.Time `json:"firstSeen"` // Start of the first session
+	LastSeen     time.Time `json:"lastSeen"`  // Last activity of the latest session
+	SessionCount int       `json:"sessionCount"`
+}
+
+// Retention_cohort is the users first seen in one period, and how many of them had a session
+// in that period and in each one after it up to the end of the range
+type Retention_cohort struct {
+	Cohort    string    `json:"cohort"` // First day of the period as YYYY-MM-DD
+	Users     int       `json:"users"`
+	Active    []int     `json:"active"`    // Active[0] is the cohort's own period
+	Retention []float64 `json:"retention"` // Active as a share of Users
+}
+
+// Rollup_result is what the retention job deleted of one day after rolling it up
+type Rollup_result struct {
+	Day       string // YYYY-MM-DD
+	Sessions  int64
+	PageViews int64
+	Events    int64
+}
+
+// Funnel_step is a step of a funnel report, Step is how it was asked for, e.g. "pageview:/pricing"
+type Funnel_step struct {
+	Step        string  `json:"step"`
+	Sessions    int     `json:"sessions"`    // Sessions that reached the step
+	Conversion  float64 `json:"conversion"`  // Share of the first step's sessions that reached it
+	DropOff     int     `json:"dropOff"`     // Sessions of the previous step that did not reach it
+	DropOffRate float64 `json:"dropOffRate"` // DropOff as a share of the previous step
+}
+
+type Site struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	TrackingToken  string   `json:"trackingToken"`
+	AllowedOrigins []string `json:"allowedOrigins"`
+	BotPolicy      string   `json:"botPolicy"` // drop, flag or keep, see the bots package
+}
+
+// Webhook notifies URL of what is tracked on a site. Types are the notifications it gets,
+// "session.started" and "event", and Events limits event notifications to those names.
+type Webhook struct {
+	ID        int       `json:"id"`
+	SiteID    int       `json:"siteId"`
+	URL       string    `json:"url"`
+	Types     []string  `json:"types"`
+	Events    []string  `json:"events"`           // All events when empty
+	Secret    string    `json:"secret,omitempty"` // Signs deliveries, only shown when the webhook is created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Webhook_delivery is one notification to one webhook and its entry in the delivery log
+type Webhook_delivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int             `json:"webhookId"`
+	Type          string          `json:"type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"` // pending, delivered or failed
+	Attempts      int             `json:"attempts"`
+	StatusCode    *int            `json:"statusCode"` // Of the last attempt, nil when it got no response
+	Error         *string         `json:"error"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	NextAttemptAt *time.Time      `json:"nextAttemptAt"` // nil once delivered or failed
+	DeliveredAt   *time.Time      `json:"deliveredAt"`
+
+	// URL and Secret of the webhook, only set on deliveries claimed for sending
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// Delivery_attempt is the outcome of sending a delivery. NextAttemptAt is when to retry,
+// nil when the delivery is done with, delivered or not.
+type Delivery_attempt struct {
+	DeliveryID    int64
+	Delivered     bool
+	StatusCode    *int
+	Error         *string
+	At            time.Time
+	NextAttemptAt *time.Time
+}
+
+// Api_key is stored by hash only, the key itself is shown once when created
+type Api_key struct {
+	Name    string
+	KeyHash string
+	Scopes  []string
+}
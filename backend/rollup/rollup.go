@@ -0,0 +1,218 @@
+// Package rollup enforces the data retention policy. Once a day falls out of the retention period,
+// its sessions, page views and events are aggregated into the daily rollup tables and deleted,
+// one day per transaction, so the database stops growing with the traffic it has seen.
+// On stores that partition sessions by month it also creates the months ahead, and whole months
+// out of the retention period are rolled up at once and their partitions dropped.
+package rollup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/logging"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+type Config struct {
+	RetentionDays   int           // Whole days before today kept raw, 0 keeps everything
+	Interval        time.Duration // How often the job looks for days to roll up
+	PartitionsAhead int           // Months after the current one that get a session partition
+}
+
+func DefaultConfig() Config {
+	return Config{Interval: time.Hour, PartitionsAhead: 3}
+}
+
+// ConfigFromEnv reads DATA_RETENTION_DAYS, ROLLUP_INTERVAL_MINUTES and PARTITION_MONTHS_AHEAD,
+// nothing is rolled up unless DATA_RETENTION_DAYS is set
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	if err := helper.PositiveIntFromEnv("DATA_RETENTION_DAYS", &config.RetentionDays); err != nil {
+		return config, err
+	}
+
+	interval := int(config.Interval / time.Minute)
+	if err := helper.PositiveIntFromEnv("ROLLUP_INTERVAL_MINUTES", &interval); err != nil {
+		return config, err
+	}
+	config.Interval = time.Duration(interval) * time.Minute
+
+	if err := helper.PositiveIntFromEnv("PARTITION_MONTHS_AHEAD", &config.PartitionsAhead); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// Job runs the rollups in the background. A nil Job, or one without a retention period on a store
+// without partitions, does nothing.
+type Job struct {
+	store  store.RollupStore
+	config Config
+
+	mu      sync.Mutex
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+	cancel  context.CancelFunc
+}
+
+func NewJob(s store.RollupStore, config Config) *Job {
+	if config.Interval <= 0 {
+		config.Interval = DefaultConfig().Interval
+	}
+
+	return &Job{
+		store:  s,
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Cutoff is the start of the oldest day kept raw at now, days are UTC like the rollup tables
+func (j *Job) Cutoff(now time.Time) time.Time {
+	today := now.UTC().Truncate(24 * time.Hour)
+	return today.AddDate(0, 0, -j.config.RetentionDays)
+}
+
+// Run rolls up every day before the cutoff and returns the days it rolled up, in order.
+// Days rolled up before an error stay rolled up.
+func (j *Job) Run(ctx context.Context, now time.Time) ([]models.Rollup_result, error) {
+	if j.config.RetentionDays < 1 {
+		return nil, nil
+	}
+	before := j.Cutoff(now)
+	results := make([]models.Rollup_result, 0)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		result, ok, err := j.store.RollUpDay(ctx, before)
+		if err != nil {
+			return results, fmt.Errorf("rolling up the data before %s: %w", before.Format(time.DateOnly), err)
+		}
+		if !ok {
+			return results, nil
+		}
+		results = append(results, result)
+	}
+}
+
+// Partition keeps the session partitions of a store.Partitioner: the months through PartitionsAhead
+// are created and, with a retention period, the months before the cutoff are rolled up and dropped.
+// Other stores have nothing to do. It runs before Run, which would otherwise delete those months
+// a day at a time.
+func (j *Job) Partition(ctx context.Context, now time.Time) (created, dropped []string, err error) {
+	partitioner, ok := j.store.(store.Partitioner)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	created, err = partitioner.EnsurePartitions(ctx, now, j.config.PartitionsAhead)
+	if err != nil || j.config.RetentionDays < 1 {
+		return created, nil, err
+	}
+
+	dropped, err = partitioner.DropPartitions(ctx, j.Cutoff(now))
+	return created, dropped, err
+}
+
+// Start runs the job right away and then every Interval
+func (j *Job) Start() {
+	if j == nil {
+		return
+	}
+	if _, partitioned := j.store.(store.Partitioner); j.config.RetentionDays < 1 && !partitioned {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.started {
+		return
+	}
+	j.started = true
+
+	ctx, cancel := context.WithCancel(logging.NewContext(context.Background(), slog.With("component", "rollup")))
+	j.cancel = cancel
+	go j.loop(ctx)
+}
+
+// Close stops the job and waits for a day being rolled up, or cancels it once ctx is done
+func (j *Job) Close(ctx context.Context) error {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	started := j.started
+	if started {
+		select {
+		case <-j.stop:
+		default:
+			close(j.stop)
+		}
+	}
+	j.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		j.cancel()
+		<-j.done
+		return fmt.Errorf("rollup cancelled: %w", ctx.Err())
+	}
+}
+
+func (j *Job) loop(ctx context.Context) {
+	defer close(j.done)
+	defer j.cancel()
+
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	log := logging.FromContext(ctx)
+
+	for {
+		created, dropped, err := j.Partition(ctx, time.Now())
+		for _, name := range created {
+			log.Info("created session partition", "partition", name)
+		}
+		for _, name := range dropped {
+			log.Info("dropped session partition", "partition", name)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Error("maintaining session partitions", "err", err)
+		}
+
+		results, err := j.Run(ctx, time.Now())
+		for _, result := range results {
+			log.Info("rolled up day", "day", result.Day,
+				"sessions", result.Sessions, "page_views", result.PageViews, "events", result.Events)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Error("applying data retention", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-j.stop:
+			return
+		}
+	}
+}
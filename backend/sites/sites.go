@@ -0,0 +1,108 @@
+// Sites are the websites tracked by this Borea install. Each has its own tracking
+// token and list of origins allowed to load the script and post data.
+package sites
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"Borea/backend/bots"
+	"Borea/backend/helper"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+var (
+	ErrNotFound         = store.ErrNotFound
+	ErrOriginNotAllowed = errors.New("origin not allowed for this site")
+	ErrInvalidBotPolicy = fmt.Errorf("bot policy must be %s, %s or %s", bots.PolicyDrop, bots.PolicyFlag, bots.PolicyKeep)
+)
+
+// AllowsOrigin reports whether origin may use the site's token. Origins are compared normalized.
+func AllowsOrigin(site models.Site, origin string) bool {
+	return slices.Contains(site.AllowedOrigins, helper.NormalizeOrigin(origin))
+}
+
+// FromRequest resolves the site of a tracking request. The ?token= query param wins and
+// the request origin must then be one of the site's allowed origins; without a token the
+// site is looked up by origin alone.
+func FromRequest(sites store.SiteStore, r *http.Request) (models.Site, error) {
+	token := r.URL.Query().Get("token")
+	origin := helper.RequestOrigin(r)
+
+	if token == "" {
+		if origin == "" {
+			return models.Site{}, ErrNotFound
+		}
+		return sites.SiteByOrigin(r.Context(), helper.NormalizeOrigin(origin))
+	}
+
+	site, err := sites.SiteByToken(r.Context(), token)
+	if err != nil {
+		return models.Site{}, err
+	}
+
+	if !AllowsOrigin(site, origin) {
+		return models.Site{}, ErrOriginNotAllowed
+	}
+
+	return site, nil
+}
+
+// Create stores a new site with a generated tracking token.
+func Create(ctx context.Context, sites store.SiteStore, name string, origins []string) (models.Site, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return models.Site{}, fmt.Errorf("generating tracking token: %w", err)
+	}
+
+	return insert(ctx, sites, name, hex.EncodeToString(secret), origins)
+}
+
+// EnsureDefault registers the single site configured through the API_TOKEN and DOMAIN
+// env vars, so installs from before multi-site support keep working.
+func EnsureDefault(ctx context.Context, sites store.SiteStore, token, origin string) error {
+	if token == "" || origin == "" {
+		return nil
+	}
+
+	_, err := sites.SiteByToken(ctx, token)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	_, err = insert(ctx, sites, "default", token, []string{origin})
+	return err
+}
+
+// SetBotPolicy changes what the site does with sessions classified as bots, see the bots package
+func SetBotPolicy(ctx context.Context, sites store.SiteStore, siteID int, policy string) error {
+	if !bots.ValidPolicy(policy) {
+		return ErrInvalidBotPolicy
+	}
+
+	return sites.SetBotPolicy(ctx, siteID, policy)
+}
+
+func insert(ctx context.Context, sites store.SiteStore, name, token string, origins []string) (models.Site, error) {
+	site := models.Site{Name: name, TrackingToken: token, AllowedOrigins: make([]string, 0, len(origins)), BotPolicy: bots.PolicyFlag}
+	for _, origin := range origins {
+		if origin = helper.NormalizeOrigin(origin); origin != "" {
+			site.AllowedOrigins = append(site.AllowedOrigins, origin)
+		}
+	}
+
+	if site.Name == "" {
+		return models.Site{}, fmt.Errorf("site name is required")
+	}
+
+	return sites.CreateSite(ctx, site)
+}
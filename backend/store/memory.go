@@ -0,0 +1,1363 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+)
+
+// Memory keeps everything in process memory. It follows the same rules as Postgres and
+// exists so handlers can be tested without a database; nothing survives a restart.
+type Memory struct {
+	mu         sync.Mutex
+	sessions   map[string]*models.Site_session
+	events     []siteEvent
+	pageViews  []siteView
+	sites      []models.Site
+	adminUsers map[string]models.Auth_item
+	apiKeys    map[string]models.Api_key
+	rules      []models.Channel_rule
+	nextRuleID int
+	// daily holds the rollups of the days RollUpDay deleted, prunedUsers what their sessions
+	// added to unique users
+	daily       map[dailyKey]dailyCounts
+	prunedUsers map[userKey]*models.Unique_user
+	// webhooks keep their secrets, deliveries are in the order they were queued
+	webhooks       []models.Webhook
+	nextWebhookID  int
+	deliveries     []models.Webhook_delivery
+	nextDeliveryID int64
+}
+
+type siteEvent struct {
+	siteID int
+	event  models.Event
+}
+
+type siteView struct {
+	siteID int
+	view   models.Page_view
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		sessions:    make(map[string]*models.Site_session),
+		adminUsers:  make(map[string]models.Auth_item),
+		apiKeys:     make(map[string]models.Api_key),
+		daily:       make(map[dailyKey]dailyCounts),
+		prunedUsers: make(map[userKey]*models.Unique_user),
+	}
+}
+
+// Session returns the stored state of a session, for tests
+func (m *Memory) Session(sessionID string) (models.Site_session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.sessions[sessionID]
+	if !ok {
+		return models.Site_session{}, false
+	}
+	return *stored, true
+}
+
+// Events returns the events stored for a site in insertion order, for tests
+func (m *Memory) Events(siteID int) []models.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	events := make([]models.Event, 0)
+	for _, stored := range m.events {
+		if stored.siteID == siteID {
+			events = append(events, stored.event)
+		}
+	}
+	return events
+}
+
+// PageViews returns the page views stored for a site in the order they were first posted, for tests
+func (m *Memory) PageViews(siteID int) []models.Page_view {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	views := make([]models.Page_view, 0)
+	for _, stored := range m.pageViews {
+		if stored.siteID == siteID {
+			views = append(views, stored.view)
+		}
+	}
+	return views
+}
+
+// AddAdminUser stands in for the admin user the installer creates
+func (m *Memory) AddAdminUser(username, passwordHash string) models.Auth_item {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user := models.Auth_item{ID: len(m.adminUsers) + 1, Username: username, PasswordHash: passwordHash}
+	m.adminUsers[username] = user
+	return user
+}
+
+// RevokeAPIKey stands in for setting revoked_at on a key
+func (m *Memory) RevokeAPIKey(keyHash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.apiKeys, keyHash)
+}
+
+func (m *Memory) UpsertSession(ctx context.Context, siteID int, session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.upsertSession(siteID, session)
+}
+
+func (m *Memory) upsertSession(siteID int, session models.Session) error {
+	stored, ok := m.sessions[session.SessionID]
+	if !ok {
+		m.sessions[session.SessionID] = &models.Site_session{SiteID: siteID, Session: session}
+		m.queueSessionStarted(siteID, session)
+		return nil
+	}
+
+	if stored.SiteID != siteID {
+		return ErrSessionOfAnotherSite
+	}
+
+	current := &stored.Session
+	current.LastActivityTime = latest(current.LastActivityTime, session.LastActivityTime)
+	current.StartTime = earliest(current.StartTime, session.StartTime)
+	if session.SessionDuration != nil && (current.SessionDuration == nil || *session.SessionDuration > *current.SessionDuration) {
+		current.SessionDuration = session.SessionDuration
+	}
+	if session.UserID != nil {
+		current.UserID = session.UserID
+	}
+	current.Token = firstSet(current.Token, session.Token)
+	current.UserAgent = firstSet(current.UserAgent, session.UserAgent)
+	current.Referrer = firstSet(current.Referrer, session.Referrer)
+	current.Language = firstSet(current.Language, session.Language)
+	current.Client.Browser = firstSet(current.Client.Browser, session.Client.Browser)
+	current.Client.BrowserVersion = firstSet(current.Client.BrowserVersion, session.Client.BrowserVersion)
+	current.Client.OS = firstSet(current.Client.OS, session.Client.OS)
+	current.Client.OSVersion = firstSet(current.Client.OSVersion, session.Client.OSVersion)
+	current.Client.Device = firstSet(current.Client.Device, session.Client.Device)
+	current.Location.Country = firstSet(current.Location.Country, session.Location.Country)
+	current.Location.Region = firstSet(current.Location.Region, session.Location.Region)
+	current.Location.City = firstSet(current.Location.City, session.Location.City)
+	current.Campaign.Source = firstSet(current.Campaign.Source, session.Campaign.Source)
+	current.Campaign.Medium = firstSet(current.Campaign.Medium, session.Campaign.Medium)
+	current.Campaign.Name = firstSet(current.Campaign.Name, session.Campaign.Name)
+	current.Campaign.Term = firstSet(current.Campaign.Term, session.Campaign.Term)
+	current.Campaign.Content = firstSet(current.Campaign.Content, session.Campaign.Content)
+	current.Channel = firstSet(current.Channel, session.Channel)
+	current.IsBot = current.IsBot || session.IsBot
+
+	return nil
+}
+
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+func earliest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.Before(*a)) {
+		return b
+	}
+	return a
+}
+
+func firstSet(current, update *string) *string {
+	if current != nil {
+		return current
+	}
+	return update
+}
+
+func (m *Memory) UpsertSessions(ctx context.Context, batch []models.Site_session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failed := 0
+	for _, s := range batch {
+		if err := m.upsertSession(s.SiteID, s.Session); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions could not be written", failed, len(batch))
+	}
+
+	return nil
+}
+
+func (m *Memory) InsertEvent(ctx context.Context, siteID int, event models.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.insertEvent(siteID, event)
+	return nil
+}
+
+func (m *Memory) insertEvent(siteID int, event models.Event) {
+	if event.Timestamp == nil {
+		now := time.Now().UTC()
+		event.Timestamp = &now
+	}
+	m.events = append(m.events, siteEvent{siteID: siteID, event: event})
+	m.queueEvent(siteID, event)
+}
+
+func (m *Memory) UpsertPageView(ctx context.Context, siteID int, view models.Page_view) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.upsertPageView(siteID, view)
+}
+
+func (m *Memory) upsertPageView(siteID int, view models.Page_view) error {
+	for i := range m.pageViews {
+		stored := &m.pageViews[i]
+		if stored.view.ViewID != view.ViewID {
+			continue
+		}
+
+		if stored.siteID != siteID || stored.view.SessionID != view.SessionID {
+			return ErrViewOfAnotherSession
+		}
+
+		stored.view.Title = firstSet(stored.view.Title, view.Title)
+		if view.Duration != nil && (stored.view.Duration == nil || *view.Duration > *stored.view.Duration) {
+			stored.view.Duration = view.Duration
+		}
+		return nil
+	}
+
+	if view.Timestamp == nil {
+		now := time.Now().UTC()
+		view.Timestamp = &now
+	}
+	m.pageViews = append(m.pageViews, siteView{siteID: siteID, view: view})
+	return nil
+}
+
+func (m *Memory) WriteBatch(ctx context.Context, siteID int, writes []Write) ([]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]error, len(writes))
+	for i, write := range writes {
+		switch {
+		case write.Session != nil:
+			errs[i] = m.upsertSession(siteID, *write.Session)
+		case write.PageView != nil:
+			errs[i] = m.upsertPageView(siteID, *write.PageView)
+		default:
+			m.insertEvent(siteID, *write.Event)
+		}
+	}
+
+	return errs, nil
+}
+
+func (m *Memory) SiteByToken(ctx context.Context, token string) (models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, site := range m.sites {
+		if site.TrackingToken == token {
+			return site, nil
+		}
+	}
+	return models.Site{}, ErrNotFound
+}
+
+func (m *Memory) SiteByOrigin(ctx context.Context, origin string) (models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, site := range m.sites {
+		if slices.Contains(site.AllowedOrigins, origin) {
+			return site, nil
+		}
+	}
+	return models.Site{}, ErrNotFound
+}
+
+func (m *Memory) ListSites(ctx context.Context) ([]models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.sites), nil
+}
+
+func (m *Memory) CreateSite(ctx context.Context, site models.Site) (models.Site, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.sites {
+		if existing.TrackingToken == site.TrackingToken {
+			return models.Site{}, fmt.Errorf("inserting site: tracking token already in use")
+		}
+	}
+
+	site.ID = len(m.sites) + 1
+	m.sites = append(m.sites, site)
+	return site, nil
+}
+
+func (m *Memory) SetBotPolicy(ctx context.Context, siteID int, policy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.sites {
+		if m.sites[i].ID == siteID {
+			m.sites[i].BotPolicy = policy
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *Memory) AdminUser(ctx context.Context, username string) (models.Auth_item, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.adminUsers[username]
+	if !ok {
+		return models.Auth_item{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *Memory) APIKey(ctx context.Context, keyHash string) (models.Api_key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[keyHash]
+	if !ok {
+		return models.Api_key{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (m *Memory) CreateAPIKey(ctx context.Context, key models.Api_key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiKeys[key.KeyHash] = key
+	return nil
+}
+
+func (m *Memory) ChannelRules(ctx context.Context) ([]models.Channel_rule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rules := slices.Clone(m.rules)
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority < rules[j].Priority
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules, nil
+}
+
+func (m *Memory) CreateChannelRule(ctx context.Context, rule models.Channel_rule) (models.Channel_rule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextRuleID++
+	rule.ID = m.nextRuleID
+	m.rules = append(m.rules, rule)
+	return rule, nil
+}
+
+func (m *Memory) DeleteChannelRule(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rule := range m.rules {
+		if rule.ID == id {
+			m.rules = slices.Delete(m.rules, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// sessionStartedPayload and eventPayload are the payloads Postgres builds with jsonb_build_object
+type sessionStartedPayload struct {
+	Type        string  `json:"type"`
+	SiteID      int     `json:"site_id"`
+	SessionID   string  `json:"session_id"`
+	UserID      *string `json:"user_id"`
+	StartTime   *string `json:"start_time"`
+	Referrer    *string `json:"referrer"`
+	Language    *string `json:"language"`
+	Country     *string `json:"country"`
+	Channel     *string `json:"channel"`
+	UTMSource   *string `json:"utm_source"`
+	UTMMedium   *string `json:"utm_medium"`
+	UTMCampaign *string `json:"utm_campaign"`
+}
+
+type eventPayload struct {
+	Type       string          `json:"type"`
+	SiteID     int             `json:"site_id"`
+	SessionID  string          `json:"session_id"`
+	Name       string          `json:"name"`
+	Timestamp  string          `json:"timestamp"`
+	PageURL    string          `json:"page_url"`
+	Properties json.RawMessage `json:"properties"`
+}
+
+// webhookTimeLayout is the layout of the times in delivery payloads
+const webhookTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+func (m *Memory) queueSessionStarted(siteID int, session models.Session) {
+	if session.IsBot {
+		return
+	}
+
+	var startTime *string
+	if session.StartTime != nil {
+		formatted := session.StartTime.UTC().Format(webhookTimeLayout)
+		startTime = &formatted
+	}
+	payload, _ := json.Marshal(sessionStartedPayload{
+		Type: "session.started", SiteID: siteID, SessionID: session.SessionID, UserID: session.UserID,
+		StartTime: startTime, Referrer: session.Referrer, Language: session.Language,
+		Country: session.Location.Country, Channel: session.Channel,
+		UTMSource: session.Campaign.Source, UTMMedium: session.Campaign.Medium, UTMCampaign: session.Campaign.Name,
+	})
+
+	for _, webhook := range m.webhooks {
+		if webhook.SiteID == siteID && slices.Contains(webhook.Types, "session.started") {
+			m.queueDelivery(webhook.ID, "session.started", payload)
+		}
+	}
+}
+
+func (m *Memory) queueEvent(siteID int, event models.Event) {
+	if session, ok := m.sessions[event.SessionID]; ok && session.Session.IsBot {
+		return
+	}
+
+	properties := event.Properties
+	if len(properties) == 0 {
+		properties = json.RawMessage(`{}`)
+	}
+	payload, _ := json.Marshal(eventPayload{
+		Type: "event", SiteID: siteID, SessionID: event.SessionID, Name: event.Name,
+		Timestamp: event.Timestamp.UTC().Format(webhookTimeLayout), PageURL: event.PageURL, Properties: properties,
+	})
+
+	for _, webhook := range m.webhooks {
+		if webhook.SiteID != siteID || !slices.Contains(webhook.Types, "event") {
+			continue
+		}
+		if len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Name) {
+			continue
+		}
+		m.queueDelivery(webhook.ID, "event", payload)
+	}
+}
+
+func (m *Memory) queueDelivery(webhookID int, deliveryType string, payload []byte) {
+	now := time.Now().UTC()
+	m.nextDeliveryID++
+	m.deliveries = append(m.deliveries, models.Webhook_delivery{
+		ID:            m.nextDeliveryID,
+		WebhookID:     webhookID,
+		Type:          deliveryType,
+		Payload:       payload,
+		Status:        "pending",
+		CreatedAt:     now,
+		NextAttemptAt: &now,
+	})
+}
+
+func (m *Memory) Webhooks(ctx context.Context, siteID int) ([]models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := make([]models.Webhook, 0)
+	for _, webhook := range m.webhooks {
+		if siteID == 0 || webhook.SiteID == siteID {
+			webhook.Secret = ""
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *Memory) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.ContainsFunc(m.sites, func(site models.Site) bool { return site.ID == webhook.SiteID }) {
+		return models.Webhook{}, fmt.Errorf("inserting webhook: site %d does not exist", webhook.SiteID)
+	}
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	m.nextWebhookID++
+	webhook.ID = m.nextWebhookID
+	webhook.CreatedAt = time.Now().UTC()
+	m.webhooks = append(m.webhooks, webhook)
+	return webhook, nil
+}
+
+func (m *Memory) DeleteWebhook(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.webhooks, func(webhook models.Webhook) bool { return webhook.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	m.webhooks = slices.Delete(m.webhooks, i, i+1)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(delivery models.Webhook_delivery) bool {
+		return delivery.WebhookID == id
+	})
+	return nil
+}
+
+func (m *Memory) WebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.Webhook_delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deliveries := make([]models.Webhook_delivery, 0)
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.Webhook_delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	leased := now.Add(lease).UTC()
+	claimed := make([]models.Webhook_delivery, 0)
+	for i := range m.deliveries {
+		delivery := &m.deliveries[i]
+		if len(claimed) == limit {
+			break
+		}
+		if delivery.Status != "pending" || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+
+		delivery.NextAttemptAt = &leased
+		claim := *delivery
+		for _, webhook := range m.webhooks {
+			if webhook.ID == delivery.WebhookID {
+				claim.URL, claim.Secret = webhook.URL, webhook.Secret
+			}
+		}
+		claimed = append(claimed, claim)
+	}
+	return claimed, nil
+}
+
+func (m *Memory) RecordAttempt(ctx context.Context, attempt models.Delivery_attempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.deliveries, func(delivery models.Webhook_delivery) bool { return delivery.ID == attempt.DeliveryID })
+	if i < 0 {
+		return nil
+	}
+
+	delivery := &m.deliveries[i]
+	delivery.Attempts++
+	delivery.StatusCode = attempt.StatusCode
+	delivery.Error = attempt.Error
+	delivery.NextAttemptAt = attempt.NextAttemptAt
+	switch {
+	case attempt.Delivered:
+		at := attempt.At.UTC()
+		delivery.Status = "delivered"
+		delivery.DeliveredAt = &at
+		delivery.NextAttemptAt = nil
+	case attempt.NextAttemptAt == nil:
+		delivery.Status = "failed"
+	default:
+		delivery.Status = "pending"
+	}
+	return nil
+}
+
+func (m *Memory) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := len(m.deliveries)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(delivery models.Webhook_delivery) bool {
+		return delivery.Status != "pending" && delivery.CreatedAt.Before(before)
+	})
+	return int64(kept - len(m.deliveries)), nil
+}
+
+// inRange returns the human sessions whose last activity, or start without one, falls in the range and site of p
+func (m *Memory) inRange(p analytics.Params) []models.Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := make([]models.Session, 0)
+	for _, stored := range m.sessions {
+		activity := activityAt(stored.Session)
+		if activity == nil || activity.Before(p.From) || !activity.Before(p.End()) {
+			continue
+		}
+		if (p.Site != 0 && stored.SiteID != p.Site) || stored.Session.IsBot {
+			continue
+		}
+		sessions = append(sessions, stored.Session)
+	}
+	return sessions
+}
+
+// ExportSessions copies the sessions in range, so each runs without holding the lock
+func (m *Memory) ExportSessions(ctx context.Context, p analytics.Params, each func(models.Site_session) error) error {
+	m.mu.Lock()
+	sessions := make([]models.Site_session, 0)
+	for _, stored := range m.sessions {
+		activity := stored.Session.LastActivityTime
+		if activity == nil || activity.Before(p.From) || !activity.Before(p.End()) || (p.Site != 0 && stored.SiteID != p.Site) {
+			continue
+		}
+		sessions = append(sessions, *stored)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := *sessions[i].Session.LastActivityTime, *sessions[j].Session.LastActivityTime
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return sessions[i].Session.SessionID < sessions[j].Session.SessionID
+	})
+
+	for _, session := range sessions {
+		if err := each(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ExportEvents(ctx context.Context, p analytics.Params, each func(models.Site_event) error) error {
+	m.mu.Lock()
+	events := make([]models.Site_event, 0)
+	for _, stored := range m.events {
+		at := *stored.event.Timestamp
+		if at.Before(p.From) || !at.Before(p.End()) || (p.Site != 0 && stored.siteID != p.Site) {
+			continue
+		}
+		events = append(events, models.Site_event{SiteID: stored.siteID, Event: stored.event})
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Event.Timestamp.Before(*events[j].Event.Timestamp)
+	})
+
+	for _, event := range events {
+		if err := each(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ExportPageViews(ctx context.Context, p analytics.Params, each func(models.Site_view) error) error {
+	m.mu.Lock()
+	views := make([]models.Site_view, 0)
+	for _, stored := range m.pageViews {
+		entered := *stored.view.Timestamp
+		if entered.Before(p.From) || !entered.Before(p.End()) || (p.Site != 0 && stored.siteID != p.Site) {
+			continue
+		}
+		views = append(views, models.Site_view{SiteID: stored.siteID, View: stored.view})
+	}
+	m.mu.Unlock()
+
+	sort.SliceStable(views, func(i, j int) bool {
+		return views[i].View.Timestamp.Before(*views[j].View.Timestamp)
+	})
+
+	for _, view := range views {
+		if err := each(view); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buckets sums the sessions in range and the rolled up days by bucket start, in order and including empty buckets
+func (m *Memory) buckets(p analytics.Params) ([]time.Time, map[time.Time]dailyCounts) {
+	grouped := make(map[time.Time]dailyCounts)
+	for _, session := range m.inRange(p) {
+		bucket := p.Truncate(activityAt(session).UTC())
+		grouped[bucket] = grouped[bucket].plus(sessionCounts(session))
+	}
+	m.rolledUp(p, rollupSessions, func(day time.Time, _ string, counts dailyCounts) {
+		bucket := p.Truncate(day)
+		grouped[bucket] = grouped[bucket].plus(counts)
+	})
+
+	starts := make([]time.Time, 0)
+	for bucket := p.Truncate(p.From); bucket.Before(p.End()); bucket = p.Next(bucket) {
+		starts = append(starts, bucket)
+	}
+
+	return starts, grouped
+}
+
+func (m *Memory) SessionsOverTime(ctx context.Context, p analytics.Params) ([]models.Time_bucket, error) {
+	starts, grouped := m.buckets(p)
+
+	buckets := make([]models.Time_bucket, 0, len(starts))
+	for _, start := range starts {
+		buckets = append(buckets, models.Time_bucket{Date: start.Format(analytics.DateLayout), Count: grouped[start].sessions})
+	}
+	return buckets, nil
+}
+
+func (m *Memory) AverageDuration(ctx context.Context, p analytics.Params) ([]models.Duration_bucket, error) {
+	starts, grouped := m.buckets(p)
+
+	buckets := make([]models.Duration_bucket, 0, len(starts))
+	for _, start := range starts {
+		bucket := models.Duration_bucket{Date: start.Format(analytics.DateLayout)}
+		if counts := grouped[start]; counts.timed > 0 {
+			bucket.AverageDuration = float64(counts.totalDuration) / float64(counts.timed)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func (m *Memory) TopReferrers(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionReferrer), nil
+}
+
+func (m *Memory) Languages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionLanguage), nil
+}
+
+func (m *Memory) Browsers(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionBrowser), nil
+}
+
+func (m *Memory) OperatingSystems(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionOS), nil
+}
+
+func (m *Memory) Devices(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionDevice), nil
+}
+
+func (m *Memory) Countries(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionCountry), nil
+}
+
+func (m *Memory) Channels(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionChannel), nil
+}
+
+func (m *Memory) Campaigns(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.breakdown(p, dimensionCampaign), nil
+}
+
+// breakdownFields is the session field of every breakdown dimension and the value of sessions without it
+var breakdownFields = map[string]struct {
+	field    func(models.Session) *string
+	fallback string
+}{
+	dimensionReferrer: {func(s models.Session) *string { return s.Referrer }, "(direct)"},
+	dimensionLanguage: {func(s models.Session) *string { return s.Language }, "(unknown)"},
+	dimensionBrowser:  {func(s models.Session) *string { return s.Client.Browser }, "(unknown)"},
+	dimensionOS:       {func(s models.Session) *string { return s.Client.OS }, "(unknown)"},
+	dimensionDevice:   {func(s models.Session) *string { return s.Client.Device }, "(unknown)"},
+	dimensionCountry:  {func(s models.Session) *string { return s.Location.Country }, "(unknown)"},
+	dimensionChannel:  {func(s models.Session) *string { return s.Channel }, "(unknown)"},
+	dimensionCampaign: {func(s models.Session) *string { return s.Campaign.Name }, "(none)"},
+}
+
+func breakdownValue(session models.Session, dimension string) string {
+	breakdown := breakdownFields[dimension]
+	if v := breakdown.field(session); v != nil && *v != "" {
+		return *v
+	}
+	return breakdown.fallback
+}
+
+func (m *Memory) breakdown(p analytics.Params, dimension string) []models.Breakdown_row {
+	counts := make(map[string]int)
+	for _, session := range m.inRange(p) {
+		counts[breakdownValue(session, dimension)]++
+	}
+	m.rolledUp(p, dimension, func(_ time.Time, value string, rolled dailyCounts) {
+		counts[value] += rolled.sessions
+	})
+
+	return topRows(counts, p.Limit)
+}
+
+// topRows orders counts like the Postgres breakdowns do and keeps the first limit rows
+func topRows(counts map[string]int, limit int) []models.Breakdown_row {
+	rows := make([]models.Breakdown_row, 0, len(counts))
+	for value, count := range counts {
+		rows = append(rows, models.Breakdown_row{Value: value, Count: count})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Value < rows[j].Value
+	})
+
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows
+}
+
+// viewsInRange returns the page views entered in the range and site of p whose session is not
+// a bot, in the order they were first posted. Views without a stored session count, as in the rollups.
+func (m *Memory) viewsInRange(p analytics.Params) []models.Page_view {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	views := make([]models.Page_view, 0)
+	for _, stored := range m.pageViews {
+		entered := stored.view.Timestamp
+		if entered.Before(p.From) || !entered.Before(p.End()) || (p.Site != 0 && stored.siteID != p.Site) {
+			continue
+		}
+		if session, ok := m.sessions[stored.view.SessionID]; ok && session.Session.IsBot {
+			continue
+		}
+		views = append(views, stored.view)
+	}
+	return views
+}
+
+func (m *Memory) TopPages(ctx context.Context, p analytics.Params) ([]models.Page_row, error) {
+	byPath := make(map[string]dailyCounts)
+	sessions := make(map[string]map[string]bool)
+
+	for _, view := range m.viewsInRange(p) {
+		byPath[view.Path] = byPath[view.Path].plus(viewCounts(view))
+		if sessions[view.Path] == nil {
+			sessions[view.Path] = make(map[string]bool)
+		}
+		sessions[view.Path][view.SessionID] = true
+	}
+	for path, viewed := range sessions {
+		byPath[path] = byPath[path].plus(dailyCounts{sessions: len(viewed)})
+	}
+	m.rolledUp(p, rollupPages, func(_ time.Time, path string, rolled dailyCounts) {
+		byPath[path] = byPath[path].plus(rolled)
+	})
+
+	pages := make([]models.Page_row, 0, len(byPath))
+	for path, counts := range byPath {
+		page := models.Page_row{Path: path, Views: counts.views, Sessions: counts.sessions}
+		if counts.timed > 0 {
+			page.AverageDuration = float64(counts.totalDuration) / float64(counts.timed)
+		}
+		pages = append(pages, page)
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Views != pages[j].Views {
+			return pages[i].Views > pages[j].Views
+		}
+		return pages[i].Path < pages[j].Path
+	})
+
+	if len(pages) > p.Limit {
+		pages = pages[:p.Limit]
+	}
+	return pages, nil
+}
+
+func (m *Memory) EntryPages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.sessionPages(p, dimensionEntryPage, entersBefore), nil
+}
+
+func (m *Memory) ExitPages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return m.sessionPages(p, dimensionExitPage, entersAfter), nil
+}
+
+// entersBefore and entersAfter pick the entry and exit page of a session for sessionPages
+func entersBefore(view, current models.Page_view) bool {
+	return view.Timestamp.Before(*current.Timestamp)
+}
+
+func entersAfter(view, current models.Page_view) bool {
+	return !view.Timestamp.Before(*current.Timestamp)
+}
+
+// sessionPages counts sessions by the path of one view each, a view replaces the session's current
+// pick when replaces says so. Views are visited in posting order, like the id tiebreak in Postgres.
+// The days rolled up as dimension are added on top.
+func (m *Memory) sessionPages(p analytics.Params, dimension string, replaces func(view, current models.Page_view) bool) []models.Breakdown_row {
+	picked := make(map[string]models.Page_view)
+	for _, view := range m.viewsInRange(p) {
+		if current, ok := picked[view.SessionID]; !ok || replaces(view, current) {
+			picked[view.SessionID] = view
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, view := range picked {
+		counts[view.Path]++
+	}
+	m.rolledUp(p, dimension, func(_ time.Time, path string, rolled dailyCounts) {
+		counts[path] += rolled.sessions
+	})
+	return topRows(counts, p.Limit)
+}
+
+func (m *Memory) Funnel(ctx context.Context, f analytics.Funnel) ([]models.Funnel_step, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Every view and event gets the occurrence of its index, views first
+	type occurrence struct {
+		siteID    int
+		sessionID string
+		stepType  string
+		value     string
+		at        time.Time
+	}
+	occurrences := make([]occurrence, 0, len(m.pageViews)+len(m.events))
+	for _, stored := range m.pageViews {
+		occurrences = append(occurrences, occurrence{stored.siteID, stored.view.SessionID, analytics.StepPageview, stored.view.Path, *stored.view.Timestamp})
+	}
+	for _, stored := range m.events {
+		occurrences = append(occurrences, occurrence{stored.siteID, stored.event.SessionID, analytics.StepEvent, stored.event.Name, *stored.event.Timestamp})
+	}
+
+	// The matches of the previous step by session, like the step CTEs in Postgres
+	type funnelMatch struct {
+		at         time.Time
+		occurrence int
+	}
+	previous := make(map[string][]funnelMatch)
+	reached := make([]int, len(f.Steps))
+
+	for i, step := range f.Steps {
+		current := make(map[string][]funnelMatch)
+
+		for j, o := range occurrences {
+			if o.stepType != step.Type || !step.Matches(o.value) {
+				continue
+			}
+
+			if i == 0 {
+				session, ok := m.sessions[o.sessionID]
+				if !ok || session.Session.IsBot || o.at.Before(f.From) || !o.at.Before(f.End()) || (f.Site != 0 && o.siteID != f.Site) {
+					continue
+				}
+				current[o.sessionID] = append(current[o.sessionID], funnelMatch{at: o.at, occurrence: j})
+				continue
+			}
+
+			for _, before := range previous[o.sessionID] {
+				if o.at.Before(before.at) || !o.at.Before(f.End()) || j == before.occurrence || (f.Within > 0 && o.at.Sub(before.at) > f.Within) {
+					continue
+				}
+				current[o.sessionID] = append(current[o.sessionID], funnelMatch{at: o.at, occurrence: j})
+				break
+			}
+		}
+
+		reached[i] = len(current)
+		previous = current
+	}
+
+	return analytics.FunnelSteps(f, reached), nil
+}
+
+// seenAt is when a session counts for its user, its start or its last activity when no start was sent
+func seenAt(session models.Session) *time.Time {
+	if session.StartTime != nil {
+		return session.StartTime
+	}
+	return session.LastActivityTime
+}
+
+// uniqueUsers derives unique_users from the stored sessions and the pruned ones, m.mu must be held
+func (m *Memory) uniqueUsers() map[userKey]*models.Unique_user {
+	users := make(map[userKey]*models.Unique_user)
+	for key, pruned := range m.prunedUsers {
+		user := *pruned
+		users[key] = &user
+	}
+	for _, stored := range m.sessions {
+		countUserSession(users, stored.SiteID, stored.Session)
+	}
+	return users
+}
+
+// countUserSession adds a session to the unique user it belongs to, if any
+func countUserSession(users map[userKey]*models.Unique_user, siteID int, session models.Session) {
+	seen := seenAt(session)
+	if session.UserID == nil || session.IsBot || seen == nil {
+		return
+	}
+
+	last := latest(session.LastActivityTime, session.StartTime)
+	key := userKey{siteID, *session.UserID}
+	user, ok := users[key]
+	if !ok {
+		users[key] = &models.Unique_user{SiteID: siteID, UserID: *session.UserID, FirstSeen: *seen, LastSeen: *last, SessionCount: 1}
+		return
+	}
+
+	if seen.Before(user.FirstSeen) {
+		user.FirstSeen = *seen
+	}
+	if last.After(user.LastSeen) {
+		user.LastSeen = *last
+	}
+	user.SessionCount++
+}
+
+type userKey struct {
+	siteID int
+	userID string
+}
+
+func (m *Memory) UniqueUser(ctx context.Context, siteID int, userID string) (models.Unique_user, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.uniqueUsers()[userKey{siteID, userID}]
+	if !ok {
+		return models.Unique_user{}, ErrNotFound
+	}
+	return *user, nil
+}
+
+func (m *Memory) Retention(ctx context.Context, p analytics.Params) ([]models.Retention_cohort, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make(map[string]int)
+	cohorts := make(map[userKey]string)
+	for key, user := range m.uniqueUsers() {
+		if user.FirstSeen.Before(p.From) || !user.FirstSeen.Before(p.End()) || (p.Site != 0 && key.siteID != p.Site) {
+			continue
+		}
+		cohort := p.Truncate(user.FirstSeen).Format(analytics.DateLayout)
+		cohorts[key] = cohort
+		users[cohort]++
+	}
+
+	seen := make(map[string]map[string]map[userKey]bool)
+	for _, stored := range m.sessions {
+		session := stored.Session
+		if session.UserID == nil || session.IsBot {
+			continue
+		}
+		key := userKey{stored.SiteID, *session.UserID}
+		cohort, ok := cohorts[key]
+		if !ok || !seenAt(session).Before(p.End()) {
+			continue
+		}
+
+		period := p.Truncate(*seenAt(session)).Format(analytics.DateLayout)
+		if seen[cohort] == nil {
+			seen[cohort] = make(map[string]map[userKey]bool)
+		}
+		if seen[cohort][period] == nil {
+			seen[cohort][period] = make(map[userKey]bool)
+		}
+		seen[cohort][period][key] = true
+	}
+
+	active := make(map[string]map[string]int)
+	for cohort, periods := range seen {
+		active[cohort] = make(map[string]int)
+		for period, keys := range periods {
+			active[cohort][period] = len(keys)
+		}
+	}
+
+	return analytics.RetentionCohorts(p, users, active), nil
+}
+
+// Memory keeps the rollups of all four daily tables in one map, these stand for the tables
+// that are not keyed by a breakdown dimension
+const (
+	rollupSessions = "sessions"
+	rollupPages    = "pages"
+	rollupEvents   = "events"
+)
+
+type dailyKey struct {
+	siteID    int
+	day       time.Time
+	dimension string
+	value     string
+}
+
+// dailyCounts is a row of any of the daily tables, timed is how many durations totalDuration sums
+type dailyCounts struct {
+	sessions      int
+	views         int
+	events        int
+	totalDuration int64
+	timed         int
+}
+
+func (c dailyCounts) plus(other dailyCounts) dailyCounts {
+	return dailyCounts{
+		sessions:      c.sessions + other.sessions,
+		views:         c.views + other.views,
+		events:        c.events + other.events,
+		totalDuration: c.totalDuration + other.totalDuration,
+		timed:         c.timed + other.timed,
+	}
+}
+
+func sessionCounts(session models.Session) dailyCounts {
+	counts := dailyCounts{sessions: 1}
+	if session.SessionDuration != nil {
+		counts.totalDuration = *session.SessionDuration
+		counts.timed = 1
+	}
+	return counts
+}
+
+func viewCounts(view models.Page_view) dailyCounts {
+	counts := dailyCounts{views: 1}
+	if view.Duration != nil {
+		counts.totalDuration = *view.Duration
+		counts.timed = 1
+	}
+	return counts
+}
+
+// rolledUp calls add with every rollup of dimension in the range and site of p
+func (m *Memory) rolledUp(p analytics.Params, dimension string, add func(day time.Time, value string, counts dailyCounts)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, counts := range m.daily {
+		if key.dimension != dimension || key.day.Before(p.From) || !key.day.Before(p.End()) || (p.Site != 0 && key.siteID != p.Site) {
+			continue
+		}
+		add(key.day, key.value, counts)
+	}
+}
+
+// activityAt is the time a session is kept by, its last activity or its start when no activity was sent
+func activityAt(session models.Session) *time.Time {
+	if session.LastActivityTime != nil {
+		return session.LastActivityTime
+	}
+	return session.StartTime
+}
+
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func (m *Memory) RollUpDay(ctx context.Context, before time.Time) (models.Rollup_result, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var oldest *time.Time
+	consider := func(t *time.Time) {
+		if t != nil && (oldest == nil || t.Before(*oldest)) {
+			oldest = t
+		}
+	}
+	for _, stored := range m.sessions {
+		consider(activityAt(stored.Session))
+	}
+	for _, stored := range m.events {
+		consider(stored.event.Timestamp)
+	}
+	for _, stored := range m.pageViews {
+		consider(stored.view.Timestamp)
+	}
+	if oldest == nil || !utcDay(*oldest).Before(before) {
+		return models.Rollup_result{}, false, nil
+	}
+
+	day := utcDay(*oldest)
+	upTo := day.AddDate(0, 0, 1)
+	if upTo.After(before) {
+		upTo = before
+	}
+	result := models.Rollup_result{Day: day.Format(analytics.DateLayout)}
+
+	add := func(siteID int, at time.Time, dimension, value string, counts dailyCounts) {
+		key := dailyKey{siteID, utcDay(at), dimension, value}
+		m.daily[key] = m.daily[key].plus(counts)
+	}
+
+	for _, stored := range m.sessions {
+		session := stored.Session
+		at := activityAt(session)
+		if at == nil || !at.Before(upTo) || session.IsBot {
+			continue
+		}
+		add(stored.SiteID, *at, rollupSessions, "", sessionCounts(session))
+		for dimension := range breakdownFields {
+			add(stored.SiteID, *at, dimension, breakdownValue(session, dimension), dailyCounts{sessions: 1})
+		}
+	}
+
+	// Page sessions are distinct per day, entry and exit pages are picked per session and day
+	type sessionDay struct {
+		sessionID string
+		day       time.Time
+	}
+	pageSessions := make(map[dailyKey]map[string]bool)
+	entries := make(map[sessionDay]siteView)
+	exits := make(map[sessionDay]siteView)
+	for _, stored := range m.pageViews {
+		view := stored.view
+		if !view.Timestamp.Before(upTo) {
+			continue
+		}
+		if session, ok := m.sessions[view.SessionID]; ok && session.Session.IsBot {
+			continue
+		}
+		add(stored.siteID, *view.Timestamp, rollupPages, view.Path, viewCounts(view))
+
+		key := dailyKey{stored.siteID, utcDay(*view.Timestamp), rollupPages, view.Path}
+		if pageSessions[key] == nil {
+			pageSessions[key] = make(map[string]bool)
+		}
+		pageSessions[key][view.SessionID] = true
+
+		sd := sessionDay{view.SessionID, key.day}
+		if current, ok := entries[sd]; !ok || entersBefore(view, current.view) {
+			entries[sd] = stored
+		}
+		if current, ok := exits[sd]; !ok || entersAfter(view, current.view) {
+			exits[sd] = stored
+		}
+	}
+	for key, sessions := range pageSessions {
+		add(key.siteID, key.day, key.dimension, key.value, dailyCounts{sessions: len(sessions)})
+	}
+	for _, stored := range entries {
+		add(stored.siteID, *stored.view.Timestamp, dimensionEntryPage, stored.view.Path, dailyCounts{sessions: 1})
+	}
+	for _, stored := range exits {
+		add(stored.siteID, *stored.view.Timestamp, dimensionExitPage, stored.view.Path, dailyCounts{sessions: 1})
+	}
+
+	eventSessions := make(map[dailyKey]map[string]bool)
+	events := m.events[:0]
+	for _, stored := range m.events {
+		if !stored.event.Timestamp.Before(upTo) {
+			events = append(events, stored)
+			continue
+		}
+		result.Events++
+		if session, ok := m.sessions[stored.event.SessionID]; ok && session.Session.IsBot {
+			continue
+		}
+		add(stored.siteID, *stored.event.Timestamp, rollupEvents, stored.event.Name, dailyCounts{events: 1})
+
+		key := dailyKey{stored.siteID, utcDay(*stored.event.Timestamp), rollupEvents, stored.event.Name}
+		if eventSessions[key] == nil {
+			eventSessions[key] = make(map[string]bool)
+		}
+		eventSessions[key][stored.event.SessionID] = true
+	}
+	m.events = events
+	for key, sessions := range eventSessions {
+		add(key.siteID, key.day, key.dimension, key.value, dailyCounts{sessions: len(sessions)})
+	}
+
+	views := m.pageViews[:0]
+	for _, stored := range m.pageViews {
+		if stored.view.Timestamp.Before(upTo) {
+			result.PageViews++
+			continue
+		}
+		views = append(views, stored)
+	}
+	m.pageViews = views
+
+	for sessionID, stored := range m.sessions {
+		if at := activityAt(stored.Session); at == nil || !at.Before(upTo) {
+			continue
+		}
+		countUserSession(m.prunedUsers, stored.SiteID, stored.Session)
+		delete(m.sessions, sessionID)
+		result.Sessions++
+	}
+
+	return result, true, nil
+}
+
+func (m *Memory) UnparsedUserAgents(ctx context.Context, after string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool)
+	userAgents := make([]string, 0)
+	for _, stored := range m.sessions {
+		userAgent := stored.Session.UserAgent
+		if stored.Session.Client.Device != nil || userAgent == nil || *userAgent <= after || seen[*userAgent] {
+			continue
+		}
+		seen[*userAgent] = true
+		userAgents = append(userAgents, *userAgent)
+	}
+
+	sort.Strings(userAgents)
+	if len(userAgents) > limit {
+		userAgents = userAgents[:limit]
+	}
+	return userAgents, nil
+}
+
+func (m *Memory) SetClient(ctx context.Context, userAgent string, client models.User_agent) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var updated int64
+	for _, stored := range m.sessions {
+		if stored.Session.Client.Device != nil || stored.Session.UserAgent == nil || *stored.Session.UserAgent != userAgent {
+			continue
+		}
+		stored.Session.Client = client
+		updated++
+	}
+	return updated, nil
+}
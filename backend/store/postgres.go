@@ -0,0 +1,617 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"Borea/backend/logging"
+	"Borea/backend/models"
+
+	"github.com/lib/pq"
+)
+
+// Postgres is the production Store, the schema comes from the migrations in db/migrations
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// execer is satisfied by *sql.DB, *sql.Conn and *sql.Tx so writes can run inside a batch transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// sessions is partitioned by month on last_activity_time, so session_id cannot have a unique index
+// there. session_keys holds the unique session_id and the site the session belongs to instead: a write
+// locks the key of its session first, then updates the session or inserts it when there is none yet.
+// A write for a session of another site leaves the key untouched, so no row is affected.
+const sessionKeyLock = `
+INSERT INTO session_keys (session_id, site_id) VALUES ($1, $2)
+ON CONFLICT (session_id) DO UPDATE
+SET site_id = session_keys.site_id
+WHERE session_keys.site_id IS NOT DISTINCT FROM EXCLUDED.site_id`
+
+// sessionMerge resolves a beacon, the row EXCLUDED, for a known session the way SessionStore describes
+const sessionMerge = `
+SET last_activity_time = GREATEST(sessions.last_activity_time, EXCLUDED.last_activity_time),
+	session_duration = GREATEST(sessions.session_duration, EXCLUDED.session_duration),
+	start_time = LEAST(sessions.start_time, EXCLUDED.start_time),
+	user_id = COALESCE(EXCLUDED.user_id, sessions.user_id),
+	token = COALESCE(sessions.token, EXCLUDED.token),
+	user_agent = COALESCE(sessions.user_agent, EXCLUDED.user_agent),
+	referrer = COALESCE(sessions.referrer, EXCLUDED.referrer),
+	language = COALESCE(sessions.language, EXCLUDED.language),
+	browser = COALESCE(sessions.browser, EXCLUDED.browser),
+	browser_version = COALESCE(sessions.browser_version, EXCLUDED.browser_version),
+	os = COALESCE(sessions.os, EXCLUDED.os),
+	os_version = COALESCE(sessions.os_version, EXCLUDED.os_version),
+	device_type = COALESCE(sessions.device_type, EXCLUDED.device_type),
+	country = COALESCE(sessions.country, EXCLUDED.country),
+	region = COALESCE(sessions.region, EXCLUDED.region),
+	city = COALESCE(sessions.city, EXCLUDED.city),
+	utm_source = COALESCE(sessions.utm_source, EXCLUDED.utm_source),
+	utm_medium = COALESCE(sessions.utm_medium, EXCLUDED.utm_medium),
+	utm_campaign = COALESCE(sessions.utm_campaign, EXCLUDED.utm_campaign),
+	utm_term = COALESCE(sessions.utm_term, EXCLUDED.utm_term),
+	utm_content = COALESCE(sessions.utm_content, EXCLUDED.utm_content),
+	channel = COALESCE(sessions.channel, EXCLUDED.channel),
+	is_bot = sessions.is_bot OR EXCLUDED.is_bot`
+
+// userRefresh recomputes the unique_users rows of the (site_id, user_id) pairs selected by %s from
+// their sessions, so a resent beacon or a retried batch never counts a session twice. Sessions the
+// retention job deleted are kept in pruned_sessions and the first and last seen times.
+const userRefresh = `
+INSERT INTO unique_users (site_id, user_id, first_seen, last_seen, session_count)
+SELECT site_id, user_id, MIN(COALESCE(start_time, last_activity_time)), MAX(COALESCE(last_activity_time, start_time)), COUNT(*)
+FROM sessions
+WHERE (site_id, user_id) IN (%s) AND NOT is_bot
+	AND COALESCE(start_time, last_activity_time) IS NOT NULL
+GROUP BY site_id, user_id
+ON CONFLICT (site_id, user_id) DO UPDATE
+SET first_seen = LEAST(unique_users.first_seen, EXCLUDED.first_seen),
+	last_seen = GREATEST(unique_users.last_seen, EXCLUDED.last_seen),
+	session_count = EXCLUDED.session_count + unique_users.pruned_sessions`
+
+// sessions columns are TIMESTAMP without time zone and hold UTC
+func utc(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func sessionValues(siteID int, s models.Session) []interface{} {
+	return []interface{}{
+		siteID, s.SessionID, utc(s.LastActivityTime), s.UserID, s.Token,
+		utc(s.StartTime), s.SessionDuration, s.UserAgent, s.Referrer, s.Language,
+		s.Client.Browser, s.Client.BrowserVersion, s.Client.OS, s.Client.OSVersion, s.Client.Device,
+		s.Location.Country, s.Location.Region, s.Location.City, s.IsBot,
+		s.Campaign.Source, s.Campaign.Medium, s.Campaign.Name, s.Campaign.Term, s.Campaign.Content, s.Channel,
+	}
+}
+
+var sessionColumns = []string{
+	"site_id", "session_id", "last_activity_time", "user_id", "token",
+	"start_time", "session_duration", "user_agent", "referrer", "language",
+	"browser", "browser_version", "os", "os_version", "device_type",
+	"country", "region", "city", "is_bot",
+	"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "channel",
+}
+
+// sessionColumnTypes are the types of the sessionColumns that are not TEXT
+var sessionColumnTypes = map[string]string{
+	"site_id":            "INTEGER",
+	"session_id":         "UUID",
+	"last_activity_time": "TIMESTAMP",
+	"user_id":            "UUID",
+	"start_time":         "TIMESTAMP",
+	"session_duration":   "INTEGER",
+	"is_bot":             "BOOLEAN",
+}
+
+func sessionColumnType(column string) string {
+	if columnType, ok := sessionColumnTypes[column]; ok {
+		return columnType
+	}
+	return "TEXT"
+}
+
+// sessionPlaceholders is "$1, $2, ..." for one row of sessionColumns
+var sessionPlaceholders = placeholders(len(sessionColumns))
+
+// sessionRow selects the parameters of sessionPlaceholders as one typed row of sessionColumns
+var sessionRow = func() string {
+	fields := make([]string, len(sessionColumns))
+	for i, column := range sessionColumns {
+		fields[i] = fmt.Sprintf("$%d::%s AS %s", i+1, sessionColumnType(column), column)
+	}
+	return "SELECT " + strings.Join(fields, ", ")
+}()
+
+// sessionBatchTable is the staging table mergeSessions copies a batch into
+var sessionBatchTable = func() string {
+	definitions := make([]string, len(sessionColumns))
+	for i, column := range sessionColumns {
+		definitions[i] = column + " " + sessionColumnType(column)
+	}
+	return "CREATE TEMP TABLE session_batch (" + strings.Join(definitions, ", ") + ") ON COMMIT DROP"
+}()
+
+func placeholders(n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", i+1)
+	}
+	return strings.Join(list, ", ")
+}
+
+// upsertSession keeps the key of the session locked until tx ends, so concurrent beacons of a new
+// session cannot both insert it
+func upsertSession(ctx context.Context, tx *sql.Tx, siteID int, session models.Session) error {
+	result, err := tx.ExecContext(ctx, sessionKeyLock, session.SessionID, siteID)
+	if err != nil {
+		return fmt.Errorf("locking session: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrSessionOfAnotherSite
+	}
+
+	result, err = tx.ExecContext(ctx, `
+	UPDATE sessions`+sessionMerge+`
+	FROM (`+sessionRow+`) AS EXCLUDED
+	WHERE sessions.session_id = EXCLUDED.session_id`,
+		sessionValues(siteID, session)...)
+	if err != nil {
+		return fmt.Errorf("updating session: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		_, err = tx.ExecContext(ctx, queueSessionStarted(`
+		INSERT INTO sessions (`+strings.Join(sessionColumns, ", ")+`)
+		VALUES (`+sessionPlaceholders+`)`),
+			sessionValues(siteID, session)...)
+		if err != nil {
+			return fmt.Errorf("inserting session: %w", err)
+		}
+	}
+
+	if session.UserID == nil {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(userRefresh, "SELECT $1::integer, $2::uuid"), siteID, *session.UserID)
+	if err != nil {
+		return fmt.Errorf("updating unique user: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) UpsertSession(ctx context.Context, siteID int, session models.Session) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := upsertSession(ctx, tx, siteID, session); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpsertSessions copies the batch into a staging table and merges it with a few statements.
+// If Postgres rejects any of it, the sessions are retried one at a time so a single bad
+// payload only loses itself.
+func (pg *Postgres) UpsertSessions(ctx context.Context, batch []models.Site_session) error {
+	err := pg.mergeSessions(ctx, batch)
+	if err == nil {
+		return nil
+	}
+	log := logging.FromContext(ctx)
+	log.Warn("merging session batch, writing sessions one by one", "sessions", len(batch), "err", err)
+
+	failed := 0
+	for _, s := range batch {
+		if err := pg.UpsertSession(ctx, s.SiteID, s.Session); err != nil {
+			log.Error("writing session", "site", s.SiteID, "session_id", s.Session.SessionID, "err", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions could not be written", failed, len(batch))
+	}
+
+	return nil
+}
+
+// newestInBatch is the newest beacon of every session in session_batch, leaving out the sessions
+// of another site. A statement can update a row only once, so only one beacon per session is merged.
+var newestInBatch = `
+	SELECT DISTINCT ON (b.session_id) ` + strings.Join(sessionColumns, ", ") + `
+	FROM session_batch b
+	WHERE EXISTS (
+		SELECT 1 FROM session_keys k
+		WHERE k.session_id = b.session_id AND k.site_id IS NOT DISTINCT FROM b.site_id
+	)
+	ORDER BY b.session_id, b.last_activity_time DESC NULLS LAST, b.session_duration DESC NULLS LAST`
+
+func (pg *Postgres) mergeSessions(ctx context.Context, batch []models.Site_session) error {
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, sessionBatchTable)
+	if err != nil {
+		return fmt.Errorf("creating staging table: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("session_batch", sessionColumns...))
+	if err != nil {
+		return fmt.Errorf("preparing copy: %w", err)
+	}
+
+	for _, s := range batch {
+		if _, err := stmt.ExecContext(ctx, sessionValues(s.SiteID, s.Session)...); err != nil {
+			stmt.Close()
+			return fmt.Errorf("copying session %s: %w", s.Session.SessionID, err)
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return fmt.Errorf("copying sessions: %w", err)
+	}
+
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("copying sessions: %w", err)
+	}
+
+	// Keys are locked in session_id order, so two batches sharing sessions cannot deadlock
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO session_keys (session_id, site_id)
+	SELECT DISTINCT ON (session_id) session_id, site_id
+	FROM session_batch
+	ORDER BY session_id
+	ON CONFLICT (session_id) DO NOTHING`)
+	if err != nil {
+		return fmt.Errorf("registering sessions: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	SELECT 1 FROM session_keys
+	WHERE session_id IN (SELECT session_id FROM session_batch)
+	ORDER BY session_id
+	FOR UPDATE`)
+	if err != nil {
+		return fmt.Errorf("locking sessions: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	UPDATE sessions`+sessionMerge+`
+	FROM (`+newestInBatch+`) AS EXCLUDED
+	WHERE sessions.session_id = EXCLUDED.session_id`)
+	if err != nil {
+		return fmt.Errorf("updating sessions: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, queueSessionStarted(`
+	INSERT INTO sessions (`+strings.Join(sessionColumns, ", ")+`)
+	SELECT * FROM (`+newestInBatch+`) b
+	WHERE NOT EXISTS (SELECT 1 FROM sessions s WHERE s.session_id = b.session_id)`))
+	if err != nil {
+		return fmt.Errorf("inserting sessions: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(userRefresh, "SELECT DISTINCT site_id, user_id FROM session_batch WHERE user_id IS NOT NULL"))
+	if err != nil {
+		return fmt.Errorf("updating unique users: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func insertEvent(ctx context.Context, q execer, siteID int, event models.Event) error {
+	_, err := q.ExecContext(ctx, queueEvent(`
+	INSERT INTO events (session_id, name, event_time, page_url, properties, site_id)
+	VALUES ($1, $2, COALESCE($3, NOW()), $4, $5, $6)`),
+		event.SessionID, event.Name, utc(event.Timestamp), event.PageURL, string(event.Properties), siteID)
+	if err != nil {
+		return fmt.Errorf("inserting event: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) InsertEvent(ctx context.Context, siteID int, event models.Event) error {
+	return insertEvent(ctx, pg.db, siteID, event)
+}
+
+// upsertPageView lets a resent view fill in its title and time on page. A conflicting view of
+// another session or site is left untouched, so no row is affected.
+func upsertPageView(ctx context.Context, q execer, siteID int, view models.Page_view) error {
+	result, err := q.ExecContext(ctx, `
+	INSERT INTO page_views (view_id, session_id, site_id, path, title, query, viewed_at, duration)
+	VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7, NOW()), $8)
+	ON CONFLICT (view_id) DO UPDATE
+	SET title = COALESCE(page_views.title, EXCLUDED.title),
+		duration = GREATEST(page_views.duration, EXCLUDED.duration)
+	WHERE page_views.session_id = EXCLUDED.session_id
+		AND page_views.site_id IS NOT DISTINCT FROM EXCLUDED.site_id`,
+		view.ViewID, view.SessionID, siteID, view.Path, view.Title, view.Query, utc(view.Timestamp), view.Duration)
+	if err != nil {
+		return fmt.Errorf("upserting page view: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrViewOfAnotherSession
+	}
+
+	return nil
+}
+
+func (pg *Postgres) UpsertPageView(ctx context.Context, siteID int, view models.Page_view) error {
+	return upsertPageView(ctx, pg.db, siteID, view)
+}
+
+// WriteBatch runs the whole batch in one transaction with a savepoint per write,
+// since a failed statement would otherwise abort the transaction for the remaining writes.
+func (pg *Postgres) WriteBatch(ctx context.Context, siteID int, writes []Write) ([]error, error) {
+	errs := make([]error, len(writes))
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, write := range writes {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+			return nil, fmt.Errorf("creating savepoint: %w", err)
+		}
+
+		switch {
+		case write.Session != nil:
+			errs[i] = upsertSession(ctx, tx, siteID, *write.Session)
+		case write.PageView != nil:
+			errs[i] = upsertPageView(ctx, tx, siteID, *write.PageView)
+		default:
+			errs[i] = insertEvent(ctx, tx, siteID, *write.Event)
+		}
+
+		release := "RELEASE SAVEPOINT batch_item"
+		if errs[i] != nil {
+			release = "ROLLBACK TO SAVEPOINT batch_item"
+		}
+
+		if _, err := tx.ExecContext(ctx, release); err != nil {
+			return nil, fmt.Errorf("ending savepoint: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return errs, nil
+}
+
+func (pg *Postgres) siteQuery(ctx context.Context, query string, arg interface{}) (models.Site, error) {
+	var site models.Site
+	err := pg.db.QueryRowContext(ctx, query, arg).Scan(&site.ID, &site.Name, &site.TrackingToken, pq.Array(&site.AllowedOrigins), &site.BotPolicy)
+	if err == sql.ErrNoRows {
+		return models.Site{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Site{}, fmt.Errorf("querying site: %w", err)
+	}
+
+	return site, nil
+}
+
+func (pg *Postgres) SiteByToken(ctx context.Context, token string) (models.Site, error) {
+	return pg.siteQuery(ctx, `
+	SELECT id, name, tracking_token, allowed_origins, bot_policy FROM sites
+	WHERE tracking_token = $1`, token)
+}
+
+func (pg *Postgres) SiteByOrigin(ctx context.Context, origin string) (models.Site, error) {
+	return pg.siteQuery(ctx, `
+	SELECT id, name, tracking_token, allowed_origins, bot_policy FROM sites
+	WHERE $1 = ANY(allowed_origins)
+	ORDER BY id
+	LIMIT 1`, origin)
+}
+
+func (pg *Postgres) ListSites(ctx context.Context) ([]models.Site, error) {
+	rows, err := pg.db.QueryContext(ctx, `SELECT id, name, tracking_token, allowed_origins, bot_policy FROM sites ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("querying sites: %w", err)
+	}
+	defer rows.Close()
+
+	list := make([]models.Site, 0)
+	for rows.Next() {
+		var site models.Site
+		if err := rows.Scan(&site.ID, &site.Name, &site.TrackingToken, pq.Array(&site.AllowedOrigins), &site.BotPolicy); err != nil {
+			return nil, fmt.Errorf("scanning site: %w", err)
+		}
+		list = append(list, site)
+	}
+
+	return list, rows.Err()
+}
+
+func (pg *Postgres) CreateSite(ctx context.Context, site models.Site) (models.Site, error) {
+	err := pg.db.QueryRowContext(ctx, `
+	INSERT INTO sites (name, tracking_token, allowed_origins, bot_policy)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id`,
+		site.Name, site.TrackingToken, pq.Array(site.AllowedOrigins), site.BotPolicy).Scan(&site.ID)
+	if err != nil {
+		return models.Site{}, fmt.Errorf("inserting site: %w", err)
+	}
+
+	return site, nil
+}
+
+func (pg *Postgres) SetBotPolicy(ctx context.Context, siteID int, policy string) error {
+	result, err := pg.db.ExecContext(ctx, `UPDATE sites SET bot_policy = $2 WHERE id = $1`, siteID, policy)
+	if err != nil {
+		return fmt.Errorf("updating bot policy: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (pg *Postgres) AdminUser(ctx context.Context, username string) (models.Auth_item, error) {
+	var user models.Auth_item
+	err := pg.db.QueryRowContext(ctx, `
+	SELECT id, username, password_hash FROM admin_users
+	WHERE username = $1`, username).Scan(&user.ID, &user.Username, &user.PasswordHash)
+	if err == sql.ErrNoRows {
+		return models.Auth_item{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Auth_item{}, fmt.Errorf("querying admin user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (pg *Postgres) APIKey(ctx context.Context, keyHash string) (models.Api_key, error) {
+	key := models.Api_key{KeyHash: keyHash}
+	err := pg.db.QueryRowContext(ctx, `
+	SELECT name, scopes FROM api_keys
+	WHERE key_hash = $1 AND revoked_at IS NULL`,
+		keyHash).Scan(&key.Name, pq.Array(&key.Scopes))
+	if err == sql.ErrNoRows {
+		return models.Api_key{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Api_key{}, fmt.Errorf("looking up api key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (pg *Postgres) CreateAPIKey(ctx context.Context, key models.Api_key) error {
+	_, err := pg.db.ExecContext(ctx, `INSERT INTO api_keys (name, key_hash, scopes) VALUES ($1, $2, $3)`,
+		key.Name, key.KeyHash, pq.Array(key.Scopes))
+	if err != nil {
+		return fmt.Errorf("inserting api key: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) UnparsedUserAgents(ctx context.Context, after string, limit int) ([]string, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT DISTINCT user_agent FROM sessions
+	WHERE device_type IS NULL AND user_agent IS NOT NULL AND user_agent > $1
+	ORDER BY user_agent
+	LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying unparsed user agents: %w", err)
+	}
+	defer rows.Close()
+
+	userAgents := make([]string, 0, limit)
+	for rows.Next() {
+		var userAgent string
+		if err := rows.Scan(&userAgent); err != nil {
+			return nil, fmt.Errorf("scanning user agent: %w", err)
+		}
+		userAgents = append(userAgents, userAgent)
+	}
+
+	return userAgents, rows.Err()
+}
+
+func (pg *Postgres) SetClient(ctx context.Context, userAgent string, client models.User_agent) (int64, error) {
+	result, err := pg.db.ExecContext(ctx, `
+	UPDATE sessions
+	SET browser = $2, browser_version = $3, os = $4, os_version = $5, device_type = $6
+	WHERE user_agent = $1 AND device_type IS NULL`,
+		userAgent, client.Browser, client.BrowserVersion, client.OS, client.OSVersion, client.Device)
+	if err != nil {
+		return 0, fmt.Errorf("updating parsed user agent: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (pg *Postgres) ChannelRules(ctx context.Context) ([]models.Channel_rule, error) {
+	rows, err := pg.db.QueryContext(ctx, `SELECT id, channel, field, pattern, priority FROM channel_rules ORDER BY priority, id`)
+	if err != nil {
+		return nil, fmt.Errorf("querying channel rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := make([]models.Channel_rule, 0)
+	for rows.Next() {
+		var rule models.Channel_rule
+		if err := rows.Scan(&rule.ID, &rule.Channel, &rule.Field, &rule.Pattern, &rule.Priority); err != nil {
+			return nil, fmt.Errorf("scanning channel rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (pg *Postgres) CreateChannelRule(ctx context.Context, rule models.Channel_rule) (models.Channel_rule, error) {
+	err := pg.db.QueryRowContext(ctx, `
+	INSERT INTO channel_rules (channel, field, pattern, priority)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id`,
+		rule.Channel, rule.Field, rule.Pattern, rule.Priority).Scan(&rule.ID)
+	if err != nil {
+		return models.Channel_rule{}, fmt.Errorf("inserting channel rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+func (pg *Postgres) DeleteChannelRule(ctx context.Context, id int) error {
+	result, err := pg.db.ExecContext(ctx, `DELETE FROM channel_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("deleting channel rule: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (pg *Postgres) UniqueUser(ctx context.Context, siteID int, userID string) (models.Unique_user, error) {
+	user := models.Unique_user{SiteID: siteID, UserID: userID}
+	err := pg.db.QueryRowContext(ctx, `
+	SELECT first_seen, last_seen, session_count FROM unique_users
+	WHERE site_id = $1 AND user_id = $2`, siteID, userID).Scan(&user.FirstSeen, &user.LastSeen, &user.SessionCount)
+	if err == sql.ErrNoRows {
+		return models.Unique_user{}, ErrNotFound
+	}
+	if err != nil {
+		return models.Unique_user{}, fmt.Errorf("querying unique user: %w", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,395 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+)
+
+// dailySessionsInRange is the human sessions in the range ($1, $2) and site ($4) of an analytics query,
+// one row per session and one per day that was rolled up. Like the rollups, a session falls on its
+// last activity, or on its start when it has none.
+const dailySessionsInRange = `
+		SELECT COALESCE(last_activity_time, start_time) AS at, 1 AS sessions, COALESCE(session_duration, 0)::bigint AS total_duration,
+			CASE WHEN session_duration IS NULL THEN 0 ELSE 1 END AS duration_count
+		FROM sessions
+		WHERE COALESCE(last_activity_time, start_time) >= $1 AND COALESCE(last_activity_time, start_time) < $2
+			AND ($4 = 0 OR site_id = $4)
+			AND NOT is_bot
+		UNION ALL
+		SELECT day::timestamp, sessions, total_duration, duration_count
+		FROM daily_sessions
+		WHERE day >= $1 AND day < $2
+			AND ($4 = 0 OR site_id = $4)`
+
+// SessionsOverTime counts sessions per bucket, including empty buckets.
+func (pg *Postgres) SessionsOverTime(ctx context.Context, p analytics.Params) ([]models.Time_bucket, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT to_char(b.bucket, 'YYYY-MM-DD'), COALESCE(SUM(s.sessions), 0)
+	FROM generate_series(date_trunc($3, $1::timestamp), $2::timestamp - interval '1 microsecond', ('1 ' || $3)::interval) AS b(bucket)
+	LEFT JOIN (`+dailySessionsInRange+`) s
+		ON s.at >= GREATEST(b.bucket, $1::timestamp)
+		AND s.at < LEAST(b.bucket + ('1 ' || $3)::interval, $2::timestamp)
+	GROUP BY b.bucket
+	ORDER BY b.bucket`,
+		p.From, p.End(), p.Granularity, p.Site)
+	if err != nil {
+		return nil, fmt.Errorf("querying sessions over time: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := make([]models.Time_bucket, 0)
+	for rows.Next() {
+		var bucket models.Time_bucket
+		if err := rows.Scan(&bucket.Date, &bucket.Count); err != nil {
+			return nil, fmt.Errorf("scanning sessions over time: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+// AverageDuration averages session_duration (milliseconds) per bucket, 0 for empty buckets.
+func (pg *Postgres) AverageDuration(ctx context.Context, p analytics.Params) ([]models.Duration_bucket, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT to_char(b.bucket, 'YYYY-MM-DD'), COALESCE(SUM(s.total_duration)::float8 / NULLIF(SUM(s.duration_count), 0), 0)
+	FROM generate_series(date_trunc($3, $1::timestamp), $2::timestamp - interval '1 microsecond', ('1 ' || $3)::interval) AS b(bucket)
+	LEFT JOIN (`+dailySessionsInRange+`) s
+		ON s.at >= GREATEST(b.bucket, $1::timestamp)
+		AND s.at < LEAST(b.bucket + ('1 ' || $3)::interval, $2::timestamp)
+	GROUP BY b.bucket
+	ORDER BY b.bucket`,
+		p.From, p.End(), p.Granularity, p.Site)
+	if err != nil {
+		return nil, fmt.Errorf("querying average duration: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := make([]models.Duration_bucket, 0)
+	for rows.Next() {
+		var bucket models.Duration_bucket
+		if err := rows.Scan(&bucket.Date, &bucket.AverageDuration); err != nil {
+			return nil, fmt.Errorf("scanning average duration: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+// TopReferrers returns the referrers with the most sessions. Sessions without one count as "(direct)".
+func (pg *Postgres) TopReferrers(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionReferrer)
+}
+
+// Languages returns the browser languages with the most sessions.
+func (pg *Postgres) Languages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionLanguage)
+}
+
+// Browsers returns the parsed browsers with the most sessions.
+func (pg *Postgres) Browsers(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionBrowser)
+}
+
+// OperatingSystems returns the parsed operating systems with the most sessions.
+func (pg *Postgres) OperatingSystems(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionOS)
+}
+
+// Devices returns the device classes with the most sessions.
+func (pg *Postgres) Devices(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionDevice)
+}
+
+// Countries returns the countries with the most sessions.
+func (pg *Postgres) Countries(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionCountry)
+}
+
+// Channels returns the acquisition channels with the most sessions.
+func (pg *Postgres) Channels(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionChannel)
+}
+
+// Campaigns returns the utm_campaign values with the most sessions.
+func (pg *Postgres) Campaigns(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.breakdown(ctx, p, dimensionCampaign)
+}
+
+// breakdownValues is the value of a session in each breakdown dimension, these are rolled up too
+var breakdownValues = map[string]string{
+	dimensionReferrer: "COALESCE(NULLIF(referrer, ''), '(direct)')",
+	dimensionLanguage: "COALESCE(NULLIF(language, ''), '(unknown)')",
+	dimensionBrowser:  "COALESCE(browser, '(unknown)')",
+	dimensionOS:       "COALESCE(os, '(unknown)')",
+	dimensionDevice:   "COALESCE(device_type, '(unknown)')",
+	dimensionCountry:  "COALESCE(country, '(unknown)')",
+	dimensionChannel:  "COALESCE(channel, '(unknown)')",
+	dimensionCampaign: "COALESCE(utm_campaign, '(none)')",
+}
+
+// breakdown groups sessions in the range by the value of dimension, adding the days that were rolled up
+func (pg *Postgres) breakdown(ctx context.Context, p analytics.Params, dimension string) ([]models.Breakdown_row, error) {
+	rows, err := pg.db.QueryContext(ctx, fmt.Sprintf(`
+	SELECT value, SUM(sessions) AS count
+	FROM (
+		SELECT %s AS value, 1 AS sessions
+		FROM sessions
+		WHERE COALESCE(last_activity_time, start_time) >= $1 AND COALESCE(last_activity_time, start_time) < $2
+			AND ($4 = 0 OR site_id = $4)
+			AND NOT is_bot
+		UNION ALL
+		SELECT value, sessions
+		FROM daily_breakdowns
+		WHERE day >= $1 AND day < $2
+			AND ($4 = 0 OR site_id = $4)
+			AND dimension = $5
+	) breakdown
+	GROUP BY value
+	ORDER BY count DESC, value
+	LIMIT $3`, breakdownValues[dimension]),
+		p.From, p.End(), p.Limit, p.Site, dimension)
+	if err != nil {
+		return nil, fmt.Errorf("querying breakdown: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]models.Breakdown_row, 0)
+	for rows.Next() {
+		var row models.Breakdown_row
+		if err := rows.Scan(&row.Value, &row.Count); err != nil {
+			return nil, fmt.Errorf("scanning breakdown: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	return results, rows.Err()
+}
+
+// TopPages returns the paths with the most views, with their average time on page. The sessions
+// of a rolled up path are summed per day, a session viewing it on two days counts twice.
+func (pg *Postgres) TopPages(ctx context.Context, p analytics.Params) ([]models.Page_row, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT path, SUM(views) AS views, SUM(sessions), COALESCE(SUM(total_duration)::float8 / NULLIF(SUM(duration_count), 0), 0)
+	FROM (
+		SELECT pv.path, COUNT(*) AS views, COUNT(DISTINCT pv.session_id) AS sessions,
+			COALESCE(SUM(pv.duration), 0) AS total_duration, COUNT(pv.duration) AS duration_count
+		FROM page_views pv
+		LEFT JOIN sessions s ON s.session_id = pv.session_id
+		WHERE pv.viewed_at >= $1 AND pv.viewed_at < $2
+			AND s.is_bot IS NOT TRUE
+			AND ($4 = 0 OR pv.site_id = $4)
+		GROUP BY pv.path
+		UNION ALL
+		SELECT path, views, sessions, total_duration, duration_count
+		FROM daily_pages
+		WHERE day >= $1 AND day < $2
+			AND ($4 = 0 OR site_id = $4)
+	) pages
+	GROUP BY path
+	ORDER BY views DESC, path
+	LIMIT $3`,
+		p.From, p.End(), p.Limit, p.Site)
+	if err != nil {
+		return nil, fmt.Errorf("querying top pages: %w", err)
+	}
+	defer rows.Close()
+
+	pages := make([]models.Page_row, 0)
+	for rows.Next() {
+		var page models.Page_row
+		if err := rows.Scan(&page.Path, &page.Views, &page.Sessions, &page.AverageDuration); err != nil {
+			return nil, fmt.Errorf("scanning top pages: %w", err)
+		}
+		pages = append(pages, page)
+	}
+
+	return pages, rows.Err()
+}
+
+// The order of the page views of a session that puts its entry or exit page first
+const (
+	entryPageOrder = "pv.viewed_at, pv.id"
+	exitPageOrder  = "pv.viewed_at DESC, pv.id DESC"
+)
+
+// EntryPages returns the paths most sessions started on.
+func (pg *Postgres) EntryPages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.sessionPages(ctx, p, dimensionEntryPage, entryPageOrder)
+}
+
+// ExitPages returns the paths most sessions ended on.
+func (pg *Postgres) ExitPages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error) {
+	return pg.sessionPages(ctx, p, dimensionExitPage, exitPageOrder)
+}
+
+// sessionPages counts sessions by the path of the view that sorts first by order within each session,
+// adding the days rolled up as dimension. Rolled up days were picked per day, not for the whole range.
+// order must be a constant from this file, never user input.
+func (pg *Postgres) sessionPages(ctx context.Context, p analytics.Params, dimension, order string) ([]models.Breakdown_row, error) {
+	rows, err := pg.db.QueryContext(ctx, fmt.Sprintf(`
+	SELECT value, SUM(sessions) AS count
+	FROM (
+		SELECT value, 1 AS sessions
+		FROM (
+			SELECT DISTINCT ON (pv.session_id) pv.path AS value
+			FROM page_views pv
+			LEFT JOIN sessions s ON s.session_id = pv.session_id
+			WHERE pv.viewed_at >= $1 AND pv.viewed_at < $2
+				AND s.is_bot IS NOT TRUE
+				AND ($4 = 0 OR pv.site_id = $4)
+			ORDER BY pv.session_id, %s
+		) views
+		UNION ALL
+		SELECT value, sessions
+		FROM daily_breakdowns
+		WHERE day >= $1 AND day < $2
+			AND ($4 = 0 OR site_id = $4)
+			AND dimension = $5
+	) pages
+	GROUP BY value
+	ORDER BY count DESC, value
+	LIMIT $3`, order),
+		p.From, p.End(), p.Limit, p.Site, dimension)
+	if err != nil {
+		return nil, fmt.Errorf("querying session pages: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]models.Breakdown_row, 0)
+	for rows.Next() {
+		var row models.Breakdown_row
+		if err := rows.Scan(&row.Value, &row.Count); err != nil {
+			return nil, fmt.Errorf("scanning session pages: %w", err)
+		}
+		results = append(results, row)
+	}
+
+	return results, rows.Err()
+}
+
+// The rows a funnel step can match, occurrence tells two rows apart across both tables
+var funnelSources = map[string]string{
+	analytics.StepPageview: `(SELECT session_id, site_id, viewed_at AS at, 'v' || id AS occurrence, path AS value FROM page_views)`,
+	analytics.StepEvent:    `(SELECT session_id, site_id, event_time AS at, 'e' || id AS occurrence, name AS value FROM events)`,
+}
+
+// Funnel counts the sessions that reached each step. Every step is a CTE of the matches that follow
+// a match of the step before, so with f.Within a later match of a step can still lead on when an
+// earlier one was too long ago.
+func (pg *Postgres) Funnel(ctx context.Context, f analytics.Funnel) ([]models.Funnel_step, error) {
+	args := []interface{}{f.From, f.End(), f.Site, int64(f.Within / time.Second)}
+	steps := make([]string, len(f.Steps))
+	counts := make([]string, len(f.Steps))
+
+	for i, step := range f.Steps {
+		args = append(args, step.LikePattern())
+		pattern := len(args)
+
+		if i == 0 {
+			steps[i] = fmt.Sprintf(`step1 AS (
+		SELECT o.session_id, o.at, o.occurrence
+		FROM %s o
+		JOIN sessions s ON s.session_id = o.session_id AND NOT s.is_bot
+		WHERE o.at >= $1 AND o.at < $2
+			AND ($3 = 0 OR o.site_id = $3)
+			AND o.value LIKE $%d
+	)`, funnelSources[step.Type], pattern)
+		} else {
+			steps[i] = fmt.Sprintf(`step%d AS (
+		SELECT DISTINCT o.session_id, o.at, o.occurrence
+		FROM %s o
+		JOIN step%d previous ON previous.session_id = o.session_id
+			AND o.at >= previous.at
+			AND o.occurrence <> previous.occurrence
+			AND ($4 = 0 OR o.at <= previous.at + $4 * interval '1 second')
+		WHERE o.at < $2
+			AND o.value LIKE $%d
+	)`, i+1, funnelSources[step.Type], i, pattern)
+		}
+
+		counts[i] = fmt.Sprintf("(SELECT COUNT(DISTINCT session_id) FROM step%d)", i+1)
+	}
+
+	reached := make([]int, len(f.Steps))
+	dest := make([]interface{}, len(reached))
+	for i := range reached {
+		dest[i] = &reached[i]
+	}
+
+	query := fmt.Sprintf("WITH %s\n\tSELECT %s", strings.Join(steps, ",\n\t"), strings.Join(counts, ", "))
+	if err := pg.db.QueryRowContext(ctx, query, args...).Scan(dest...); err != nil {
+		return nil, fmt.Errorf("querying funnel: %w", err)
+	}
+
+	return analytics.FunnelSteps(f, reached), nil
+}
+
+// Retention takes the cohorts from unique_users.first_seen and the periods a cohort was active in
+// from the sessions of its users, a session counts in the period it started in.
+func (pg *Postgres) Retention(ctx context.Context, p analytics.Params) ([]models.Retention_cohort, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT to_char(date_trunc($3, first_seen), 'YYYY-MM-DD') AS cohort, COUNT(*)
+	FROM unique_users
+	WHERE first_seen >= $1 AND first_seen < $2
+		AND ($4 = 0 OR site_id = $4)
+	GROUP BY cohort`,
+		p.From, p.End(), p.Granularity, p.Site)
+	if err != nil {
+		return nil, fmt.Errorf("querying cohorts: %w", err)
+	}
+	defer rows.Close()
+
+	users := make(map[string]int)
+	for rows.Next() {
+		var cohort string
+		var count int
+		if err := rows.Scan(&cohort, &count); err != nil {
+			return nil, fmt.Errorf("scanning cohorts: %w", err)
+		}
+		users[cohort] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = pg.db.QueryContext(ctx, `
+	SELECT to_char(date_trunc($3, u.first_seen), 'YYYY-MM-DD') AS cohort,
+		to_char(date_trunc($3, COALESCE(s.start_time, s.last_activity_time)), 'YYYY-MM-DD') AS period,
+		COUNT(DISTINCT (u.site_id, u.user_id))
+	FROM unique_users u
+	JOIN sessions s ON s.site_id = u.site_id AND s.user_id = u.user_id AND NOT s.is_bot
+	WHERE u.first_seen >= $1 AND u.first_seen < $2
+		AND ($4 = 0 OR u.site_id = $4)
+		AND COALESCE(s.start_time, s.last_activity_time) < $2
+	GROUP BY cohort, period`,
+		p.From, p.End(), p.Granularity, p.Site)
+	if err != nil {
+		return nil, fmt.Errorf("querying retention: %w", err)
+	}
+	defer rows.Close()
+
+	active := make(map[string]map[string]int)
+	for rows.Next() {
+		var cohort, period string
+		var count int
+		if err := rows.Scan(&cohort, &period, &count); err != nil {
+			return nil, fmt.Errorf("scanning retention: %w", err)
+		}
+		if active[cohort] == nil {
+			active[cohort] = make(map[string]int)
+		}
+		active[cohort][period] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return analytics.RetentionCohorts(p, users, active), nil
+}
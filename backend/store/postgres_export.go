@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+)
+
+// ExportSessions passes rows on as they are read, so an export of any size is never held in memory
+func (pg *Postgres) ExportSessions(ctx context.Context, p analytics.Params, each func(models.Site_session) error) error {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT `+strings.Join(sessionColumns, ", ")+`
+	FROM sessions
+	WHERE last_activity_time >= $1 AND last_activity_time < $2
+		AND ($3 = 0 OR site_id = $3)
+	ORDER BY last_activity_time, session_id`,
+		p.From, p.End(), p.Site)
+	if err != nil {
+		return fmt.Errorf("querying sessions to export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var siteID sql.NullInt64
+		var s models.Session
+		err := rows.Scan(
+			&siteID, &s.SessionID, &s.LastActivityTime, &s.UserID, &s.Token,
+			&s.StartTime, &s.SessionDuration, &s.UserAgent, &s.Referrer, &s.Language,
+			&s.Client.Browser, &s.Client.BrowserVersion, &s.Client.OS, &s.Client.OSVersion, &s.Client.Device,
+			&s.Location.Country, &s.Location.Region, &s.Location.City, &s.IsBot,
+			&s.Campaign.Source, &s.Campaign.Medium, &s.Campaign.Name, &s.Campaign.Term, &s.Campaign.Content, &s.Channel,
+		)
+		if err != nil {
+			return fmt.Errorf("scanning session to export: %w", err)
+		}
+
+		if err := each(models.Site_session{SiteID: int(siteID.Int64), Session: s}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (pg *Postgres) ExportEvents(ctx context.Context, p analytics.Params, each func(models.Site_event) error) error {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT site_id, session_id, name, event_time, page_url, properties
+	FROM events
+	WHERE event_time >= $1 AND event_time < $2
+		AND ($3 = 0 OR site_id = $3)
+	ORDER BY event_time, id`,
+		p.From, p.End(), p.Site)
+	if err != nil {
+		return fmt.Errorf("querying events to export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var siteID sql.NullInt64
+		var pageURL sql.NullString
+		var properties []byte
+		var e models.Event
+		if err := rows.Scan(&siteID, &e.SessionID, &e.Name, &e.Timestamp, &pageURL, &properties); err != nil {
+			return fmt.Errorf("scanning event to export: %w", err)
+		}
+		e.PageURL = pageURL.String
+		e.Properties = properties
+
+		if err := each(models.Site_event{SiteID: int(siteID.Int64), Event: e}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (pg *Postgres) ExportPageViews(ctx context.Context, p analytics.Params, each func(models.Site_view) error) error {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT site_id, view_id, session_id, path, title, query, viewed_at, duration
+	FROM page_views
+	WHERE viewed_at >= $1 AND viewed_at < $2
+		AND ($3 = 0 OR site_id = $3)
+	ORDER BY viewed_at, id`,
+		p.From, p.End(), p.Site)
+	if err != nil {
+		return fmt.Errorf("querying page views to export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var siteID sql.NullInt64
+		var v models.Page_view
+		if err := rows.Scan(&siteID, &v.ViewID, &v.SessionID, &v.Path, &v.Title, &v.Query, &v.Timestamp, &v.Duration); err != nil {
+			return fmt.Errorf("scanning page view to export: %w", err)
+		}
+
+		if err := each(models.Site_view{SiteID: int(siteID.Int64), View: v}); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
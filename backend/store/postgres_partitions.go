@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+
+	"github.com/lib/pq"
+)
+
+// Arbitrary key so backends starting together never create or drop the same partition at once
+const partitionLockKey = 7_365_240_019
+
+// sessionsDefault holds the sessions no monthly partition takes, see migration 0013
+const sessionsDefault = "sessions_default"
+
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("sessions_y%04dm%02d", month.Year(), int(month.Month()))
+}
+
+// partitionMonth is the month of a partition named by partitionName
+func partitionMonth(name string) (time.Time, bool) {
+	var year, month int
+	if _, err := fmt.Sscanf(name, "sessions_y%4dm%2d", &year, &month); err != nil || month < 1 || month > 12 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), true
+}
+
+func (pg *Postgres) EnsurePartitions(ctx context.Context, now time.Time, ahead int) ([]string, error) {
+	now = now.UTC()
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	created := make([]string, 0)
+	for i := 0; i <= ahead; i++ {
+		month := first.AddDate(0, i, 0)
+		ok, err := pg.createPartition(ctx, month)
+		if err != nil {
+			return created, fmt.Errorf("creating partition %s: %w", partitionName(month), err)
+		}
+		if ok {
+			created = append(created, partitionName(month))
+		}
+	}
+
+	return created, nil
+}
+
+// createPartition attaches the partition of month unless it exists, taking over the sessions of the
+// month from the default partition. A new partition cannot be attached while the default holds any.
+func (pg *Postgres) createPartition(ctx context.Context, month time.Time) (bool, error) {
+	name := partitionName(month)
+	from, to := month.Format(analytics.DateLayout), month.AddDate(0, 1, 0).Format(analytics.DateLayout)
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", partitionLockKey); err != nil {
+		return false, fmt.Errorf("acquiring partition lock: %w", err)
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+
+	// DDL takes no parameters, name and the dates are built from month above
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE %s (LIKE sessions INCLUDING DEFAULTS INCLUDING CONSTRAINTS)`, pq.QuoteIdentifier(name)),
+		fmt.Sprintf(`
+		WITH moved AS (
+			DELETE FROM %s
+			WHERE last_activity_time >= '%s' AND last_activity_time < '%s'
+			RETURNING *
+		)
+		INSERT INTO %s SELECT * FROM moved`, sessionsDefault, from, to, pq.QuoteIdentifier(name)),
+		fmt.Sprintf(`ALTER TABLE sessions ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')`, pq.QuoteIdentifier(name), from, to),
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// DropPartitions rolls up a whole month at a time, in one transaction, so its sessions are
+// dropped with the partition instead of deleted row by row.
+func (pg *Postgres) DropPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT c.relname
+	FROM pg_inherits i
+	JOIN pg_class c ON c.oid = i.inhrelid
+	WHERE i.inhparent = 'sessions'::regclass
+	ORDER BY c.relname`)
+	if err != nil {
+		return nil, fmt.Errorf("listing partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var old []time.Time
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scanning partitions: %w", err)
+		}
+		if month, ok := partitionMonth(name); ok && !month.AddDate(0, 1, 0).After(before) {
+			old = append(old, month)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	dropped := make([]string, 0)
+	for _, month := range old {
+		ok, err := pg.dropPartition(ctx, month)
+		if err != nil {
+			return dropped, fmt.Errorf("dropping partition %s: %w", partitionName(month), err)
+		}
+		if ok {
+			dropped = append(dropped, partitionName(month))
+		}
+	}
+
+	return dropped, nil
+}
+
+// dropPartition rolls up everything before the end of month, then detaches and drops the month's
+// partition. Older sessions outside of it, such as those in the default partition, are deleted.
+func (pg *Postgres) dropPartition(ctx context.Context, month time.Time) (bool, error) {
+	name := partitionName(month)
+	upTo := month.AddDate(0, 1, 0)
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", partitionLockKey); err != nil {
+		return false, fmt.Errorf("acquiring partition lock: %w", err)
+	}
+
+	// Another backend may have dropped it first
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&exists); err != nil || !exists {
+		return false, err
+	}
+
+	// Nothing can write to the partition between the rollup and the detach
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE", pq.QuoteIdentifier(name))); err != nil {
+		return false, err
+	}
+
+	var result models.Rollup_result
+	if err := rollUpBefore(ctx, tx, upTo, &result); err != nil {
+		return false, err
+	}
+
+	for _, statement := range []string{
+		fmt.Sprintf("ALTER TABLE sessions DETACH PARTITION %s", pq.QuoteIdentifier(name)),
+		fmt.Sprintf("DROP TABLE %s", pq.QuoteIdentifier(name)),
+	} {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE COALESCE(last_activity_time, start_time) < $1`, upTo); err != nil {
+		return false, fmt.Errorf("deleting sessions: %w", err)
+	}
+
+	return true, tx.Commit()
+}
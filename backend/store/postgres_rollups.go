@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+)
+
+// The rollups of a day are added to what is already stored, so a day cut short by before
+// can be rolled up again later. $1 is the end of what is rolled up. Sessions are bucketed by
+// the time they are deleted by. Nothing of a bot session is counted, but page views and events
+// without a session, such as those of a session rolled up before them, are: they are deleted too.
+const (
+	rollUpSessions = `
+	INSERT INTO daily_sessions (site_id, day, sessions, total_duration, duration_count)
+	SELECT COALESCE(site_id, 0), COALESCE(last_activity_time, start_time)::date, COUNT(*), COALESCE(SUM(session_duration), 0), COUNT(session_duration)
+	FROM sessions
+	WHERE COALESCE(last_activity_time, start_time) < $1 AND NOT is_bot
+	GROUP BY 1, 2
+	ON CONFLICT (site_id, day) DO UPDATE
+	SET sessions = daily_sessions.sessions + EXCLUDED.sessions,
+		total_duration = daily_sessions.total_duration + EXCLUDED.total_duration,
+		duration_count = daily_sessions.duration_count + EXCLUDED.duration_count`
+
+	// rollUpBreakdowns takes the (dimension, value) rows of every session from %s
+	rollUpBreakdowns = `
+	INSERT INTO daily_breakdowns (site_id, day, dimension, value, sessions)
+	SELECT COALESCE(site_id, 0), COALESCE(last_activity_time, start_time)::date, d.dimension, d.value, COUNT(*)
+	FROM sessions CROSS JOIN LATERAL (VALUES %s) AS d(dimension, value)
+	WHERE COALESCE(last_activity_time, start_time) < $1 AND NOT is_bot
+	GROUP BY 1, 2, 3, 4` + breakdownConflict
+
+	// rollUpSessionPages picks the entry or exit page of every session and day, by the order in %s
+	rollUpSessionPages = `
+	INSERT INTO daily_breakdowns (site_id, day, dimension, value, sessions)
+	SELECT site_id, day, $2::text, path, COUNT(*)
+	FROM (
+		SELECT DISTINCT ON (pv.session_id, pv.viewed_at::date) COALESCE(pv.site_id, 0) AS site_id, pv.viewed_at::date AS day, pv.path
+		FROM page_views pv
+		LEFT JOIN sessions s ON s.session_id = pv.session_id
+		WHERE pv.viewed_at < $1 AND s.is_bot IS NOT TRUE
+		ORDER BY pv.session_id, pv.viewed_at::date, %s
+	) views
+	GROUP BY site_id, day, path` + breakdownConflict
+
+	breakdownConflict = `
+	ON CONFLICT (site_id, day, dimension, value) DO UPDATE
+	SET sessions = daily_breakdowns.sessions + EXCLUDED.sessions`
+
+	rollUpPages = `
+	INSERT INTO daily_pages (site_id, day, path, views, sessions, total_duration, duration_count)
+	SELECT COALESCE(pv.site_id, 0), pv.viewed_at::date, pv.path, COUNT(*), COUNT(DISTINCT pv.session_id),
+		COALESCE(SUM(pv.duration), 0), COUNT(pv.duration)
+	FROM page_views pv
+	LEFT JOIN sessions s ON s.session_id = pv.session_id
+	WHERE pv.viewed_at < $1 AND s.is_bot IS NOT TRUE
+	GROUP BY 1, 2, 3
+	ON CONFLICT (site_id, day, path) DO UPDATE
+	SET views = daily_pages.views + EXCLUDED.views,
+		sessions = daily_pages.sessions + EXCLUDED.sessions,
+		total_duration = daily_pages.total_duration + EXCLUDED.total_duration,
+		duration_count = daily_pages.duration_count + EXCLUDED.duration_count`
+
+	rollUpEvents = `
+	INSERT INTO daily_events (site_id, day, name, events, sessions)
+	SELECT COALESCE(e.site_id, 0), e.event_time::date, e.name, COUNT(*), COUNT(DISTINCT e.session_id)
+	FROM events e
+	LEFT JOIN sessions s ON s.session_id = e.session_id
+	WHERE e.event_time < $1 AND s.is_bot IS NOT TRUE
+	GROUP BY 1, 2, 3
+	ON CONFLICT (site_id, day, name) DO UPDATE
+	SET events = daily_events.events + EXCLUDED.events,
+		sessions = daily_events.sessions + EXCLUDED.sessions`
+
+	// pruneUsers keeps the sessions about to be deleted in the session_count of their users,
+	// counting them the way userRefresh does
+	pruneUsers = `
+	UPDATE unique_users u
+	SET pruned_sessions = u.pruned_sessions + p.sessions
+	FROM (
+		SELECT site_id, user_id, COUNT(*) AS sessions
+		FROM sessions
+		WHERE COALESCE(last_activity_time, start_time) < $1
+			AND user_id IS NOT NULL AND NOT is_bot
+		GROUP BY site_id, user_id
+	) p
+	WHERE u.site_id = p.site_id AND u.user_id = p.user_id`
+)
+
+// breakdownLateral is the VALUES list of rollUpBreakdowns, one row per dimension in breakdownValues
+var breakdownLateral = func() string {
+	dimensions := make([]string, 0, len(breakdownValues))
+	for dimension := range breakdownValues {
+		dimensions = append(dimensions, dimension)
+	}
+	sort.Strings(dimensions)
+
+	rows := make([]string, len(dimensions))
+	for i, dimension := range dimensions {
+		rows[i] = fmt.Sprintf("('%s', %s)", dimension, breakdownValues[dimension])
+	}
+	return strings.Join(rows, ", ")
+}()
+
+// RollUpDay finds the oldest day across sessions, events and page views. A session belongs to
+// the day of its last activity, or of its start when it has none.
+func (pg *Postgres) RollUpDay(ctx context.Context, before time.Time) (models.Rollup_result, bool, error) {
+	var oldest sql.NullTime
+	err := pg.db.QueryRowContext(ctx, `
+	SELECT LEAST(
+		(SELECT MIN(COALESCE(last_activity_time, start_time)) FROM sessions),
+		(SELECT MIN(event_time) FROM events),
+		(SELECT MIN(viewed_at) FROM page_views)
+	)::date`).Scan(&oldest)
+	if err != nil {
+		return models.Rollup_result{}, false, fmt.Errorf("querying oldest day: %w", err)
+	}
+	if !oldest.Valid || !oldest.Time.Before(before) {
+		return models.Rollup_result{}, false, nil
+	}
+
+	day := oldest.Time
+	upTo := day.AddDate(0, 0, 1)
+	if upTo.After(before) {
+		upTo = before
+	}
+	result := models.Rollup_result{Day: day.Format(analytics.DateLayout)}
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return result, false, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := rollUpBefore(ctx, tx, upTo, &result); err != nil {
+		return result, false, err
+	}
+
+	deleted, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE COALESCE(last_activity_time, start_time) < $1`, upTo.UTC())
+	if err != nil {
+		return result, false, fmt.Errorf("deleting sessions: %w", err)
+	}
+	if result.Sessions, err = deleted.RowsAffected(); err != nil {
+		return result, false, fmt.Errorf("deleting sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, false, fmt.Errorf("committing rollup: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// rollUpBefore adds everything before upTo to the daily tables and deletes the page views and events
+// it rolled up. The sessions are left to the caller, RollUpDay deletes them and DropPartitions drops
+// their partition.
+func rollUpBefore(ctx context.Context, tx *sql.Tx, upTo time.Time, result *models.Rollup_result) error {
+	steps := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{"sessions", rollUpSessions, nil},
+		{"breakdowns", fmt.Sprintf(rollUpBreakdowns, breakdownLateral), nil},
+		{"entry pages", fmt.Sprintf(rollUpSessionPages, entryPageOrder), []interface{}{dimensionEntryPage}},
+		{"exit pages", fmt.Sprintf(rollUpSessionPages, exitPageOrder), []interface{}{dimensionExitPage}},
+		{"pages", rollUpPages, nil},
+		{"events", rollUpEvents, nil},
+		{"unique users", pruneUsers, nil},
+	}
+	for _, step := range steps {
+		if _, err := tx.ExecContext(ctx, step.query, append([]interface{}{upTo.UTC()}, step.args...)...); err != nil {
+			return fmt.Errorf("rolling up %s: %w", step.name, err)
+		}
+	}
+
+	deletes := []struct {
+		name  string
+		query string
+		count *int64
+	}{
+		{"page views", `DELETE FROM page_views WHERE viewed_at < $1`, &result.PageViews},
+		{"events", `DELETE FROM events WHERE event_time < $1`, &result.Events},
+		{"session keys", `
+		DELETE FROM session_keys k USING sessions s
+		WHERE s.session_id = k.session_id AND COALESCE(s.last_activity_time, s.start_time) < $1`, nil},
+	}
+	for _, d := range deletes {
+		deleted, err := tx.ExecContext(ctx, d.query, upTo.UTC())
+		if err != nil {
+			return fmt.Errorf("deleting %s: %w", d.name, err)
+		}
+		if d.count == nil {
+			continue
+		}
+		if *d.count, err = deleted.RowsAffected(); err != nil {
+			return fmt.Errorf("deleting %s: %w", d.name, err)
+		}
+	}
+
+	return nil
+}
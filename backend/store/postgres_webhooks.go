@@ -0,0 +1,215 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"Borea/backend/models"
+
+	"github.com/lib/pq"
+)
+
+// webhookTime formats the times of delivery payloads, webhookTimeLayout in Go
+const webhookTime = `'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'`
+
+// queueSessionStarted adds a "session.started" delivery for every new human session the
+// INSERT INTO sessions statement insert stores
+func queueSessionStarted(insert string) string {
+	return `
+	WITH created AS (` + insert + `
+		RETURNING site_id, session_id, user_id, start_time, referrer, language, country, channel,
+			utm_source, utm_medium, utm_campaign, is_bot
+	)
+	INSERT INTO webhook_deliveries (webhook_id, type, payload)
+	SELECT w.id, 'session.started', jsonb_build_object(
+		'type', 'session.started', 'site_id', c.site_id, 'session_id', c.session_id, 'user_id', c.user_id,
+		'start_time', to_char(c.start_time, ` + webhookTime + `), 'referrer', c.referrer, 'language', c.language,
+		'country', c.country, 'channel', c.channel,
+		'utm_source', c.utm_source, 'utm_medium', c.utm_medium, 'utm_campaign', c.utm_campaign)
+	FROM created c
+	JOIN webhooks w ON w.site_id = c.site_id AND 'session.started' = ANY(w.types)
+	WHERE NOT c.is_bot`
+}
+
+// queueEvent adds an "event" delivery for the event the INSERT INTO events statement insert stores,
+// unless its session is a bot, which receivers were never told about. Sessions go through the ingest
+// queue, so an event whose session is not stored yet is sent.
+func queueEvent(insert string) string {
+	return `
+	WITH created AS (` + insert + `
+		RETURNING site_id, session_id, name, event_time, page_url, properties
+	)
+	INSERT INTO webhook_deliveries (webhook_id, type, payload)
+	SELECT w.id, 'event', jsonb_build_object(
+		'type', 'event', 'site_id', c.site_id, 'session_id', c.session_id, 'name', c.name,
+		'timestamp', to_char(c.event_time, ` + webhookTime + `), 'page_url', c.page_url, 'properties', c.properties)
+	FROM created c
+	LEFT JOIN sessions s ON s.session_id = c.session_id
+	JOIN webhooks w ON w.site_id = c.site_id AND 'event' = ANY(w.types)
+		AND (cardinality(w.events) = 0 OR c.name = ANY(w.events))
+	WHERE s.is_bot IS NOT TRUE`
+}
+
+func (pg *Postgres) Webhooks(ctx context.Context, siteID int) ([]models.Webhook, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT id, site_id, url, types, events, created_at
+	FROM webhooks
+	WHERE $1 = 0 OR site_id = $1
+	ORDER BY id`, siteID)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := make([]models.Webhook, 0)
+	for rows.Next() {
+		var webhook models.Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.SiteID, &webhook.URL, pq.Array(&webhook.Types), pq.Array(&webhook.Events), &webhook.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+func (pg *Postgres) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	if webhook.Events == nil {
+		webhook.Events = []string{}
+	}
+
+	err := pg.db.QueryRowContext(ctx, `
+	INSERT INTO webhooks (site_id, url, secret, types, events)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`,
+		webhook.SiteID, webhook.URL, webhook.Secret, pq.Array(webhook.Types), pq.Array(webhook.Events)).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, fmt.Errorf("inserting webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+func (pg *Postgres) DeleteWebhook(ctx context.Context, id int) error {
+	result, err := pg.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("deleting webhook: %w", err)
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const deliveryColumns = `d.id, d.webhook_id, d.type, d.payload, d.status, d.attempts, d.status_code, d.error,
+	d.created_at, d.next_attempt_at, d.delivered_at`
+
+func scanDelivery(rows *sql.Rows, extra ...interface{}) (models.Webhook_delivery, error) {
+	var delivery models.Webhook_delivery
+	var payload []byte
+	dest := []interface{}{
+		&delivery.ID, &delivery.WebhookID, &delivery.Type, &payload, &delivery.Status, &delivery.Attempts, &delivery.StatusCode, &delivery.Error,
+		&delivery.CreatedAt, &delivery.NextAttemptAt, &delivery.DeliveredAt,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return models.Webhook_delivery{}, err
+	}
+	delivery.Payload = payload
+	return delivery, nil
+}
+
+func (pg *Postgres) WebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.Webhook_delivery, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	SELECT `+deliveryColumns+`
+	FROM webhook_deliveries d
+	WHERE d.webhook_id = $1
+	ORDER BY d.id DESC
+	LIMIT $2`, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("querying webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.Webhook_delivery, 0)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// ClaimDeliveries leases the deliveries by moving their next attempt past the lease. Rows another
+// backend is claiming are skipped, so every backend can send deliveries.
+func (pg *Postgres) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.Webhook_delivery, error) {
+	rows, err := pg.db.QueryContext(ctx, `
+	WITH claimed AS (
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns+`, w.url, w.secret
+	)
+	SELECT * FROM claimed ORDER BY id`,
+		now.UTC(), now.Add(lease).UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.Webhook_delivery, 0)
+	for rows.Next() {
+		var url, secret string
+		delivery, err := scanDelivery(rows, &url, &secret)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook delivery: %w", err)
+		}
+		delivery.URL, delivery.Secret = url, secret
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// RecordAttempt is a no-op for a delivery deleted with its webhook in the meantime
+func (pg *Postgres) RecordAttempt(ctx context.Context, attempt models.Delivery_attempt) error {
+	_, err := pg.db.ExecContext(ctx, `
+	UPDATE webhook_deliveries
+	SET attempts = attempts + 1,
+		status_code = $2,
+		error = $3,
+		status = CASE WHEN $4::boolean THEN 'delivered' WHEN $5::timestamp IS NULL THEN 'failed' ELSE 'pending' END,
+		next_attempt_at = $5,
+		delivered_at = CASE WHEN $4::boolean THEN $6::timestamp END
+	WHERE id = $1`,
+		attempt.DeliveryID, attempt.StatusCode, attempt.Error, attempt.Delivered, utc(attempt.NextAttemptAt), attempt.At.UTC())
+	if err != nil {
+		return fmt.Errorf("recording webhook delivery attempt: %w", err)
+	}
+
+	return nil
+}
+
+func (pg *Postgres) PruneDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := pg.db.ExecContext(ctx, `
+	DELETE FROM webhook_deliveries
+	WHERE status <> 'pending' AND created_at < $1`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("pruning webhook deliveries: %w", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,208 @@
+// Package store is the storage boundary of the backend. Handlers only talk to a Store,
+// which is backed by Postgres in production and kept in memory by the handler tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+)
+
+var (
+	ErrNotFound             = errors.New("not found")
+	ErrSessionOfAnotherSite = errors.New("session belongs to another site")
+	ErrViewOfAnotherSession = errors.New("page view belongs to another session")
+)
+
+type Store interface {
+	SessionStore
+	EventStore
+	PageViewStore
+	BatchWriter
+	SiteStore
+	AdminUserStore
+	APIKeyStore
+	AnalyticsStore
+	UserAgentBackfill
+	ChannelRuleStore
+	UserStore
+	RollupStore
+	ExportStore
+	WebhookStore
+}
+
+// SessionStore writes session beacons. A known session only moves forward: last activity and
+// duration never decrease, the first seen attributes are kept and the session never changes site.
+type SessionStore interface {
+	UpsertSession(ctx context.Context, siteID int, session models.Session) error
+	// UpsertSessions writes a batch from the ingest queue, a failing session does not stop the rest
+	UpsertSessions(ctx context.Context, batch []models.Site_session) error
+}
+
+type EventStore interface {
+	InsertEvent(ctx context.Context, siteID int, event models.Event) error
+}
+
+// PageViewStore writes page views. Posting a known view again only fills in what it was missing
+// and never shortens its time on page; the view cannot move to another session or site.
+type PageViewStore interface {
+	UpsertPageView(ctx context.Context, siteID int, view models.Page_view) error
+}
+
+// Write is one item of a /batch request, exactly one of the fields is set
+type Write struct {
+	Session  *models.Session
+	Event    *models.Event
+	PageView *models.Page_view
+}
+
+// BatchWriter applies the items of a /batch request. Each write succeeds or fails on its own,
+// errs has one entry per write and err is only set when the batch as a whole failed.
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, siteID int, writes []Write) (errs []error, err error)
+}
+
+// UserStore reads unique_users, which session writes keep up to date for every session with a user_id
+type UserStore interface {
+	UniqueUser(ctx context.Context, siteID int, userID string) (models.Unique_user, error)
+}
+
+type SiteStore interface {
+	SiteByToken(ctx context.Context, token string) (models.Site, error)
+	// SiteByOrigin returns the oldest site allowing the normalized origin
+	SiteByOrigin(ctx context.Context, origin string) (models.Site, error)
+	ListSites(ctx context.Context) ([]models.Site, error)
+	// CreateSite assigns the ID and returns the stored site
+	CreateSite(ctx context.Context, site models.Site) (models.Site, error)
+	SetBotPolicy(ctx context.Context, siteID int, policy string) error
+}
+
+type AdminUserStore interface {
+	AdminUser(ctx context.Context, username string) (models.Auth_item, error)
+}
+
+type APIKeyStore interface {
+	// APIKey returns the unrevoked key with the given hash
+	APIKey(ctx context.Context, keyHash string) (models.Api_key, error)
+	CreateAPIKey(ctx context.Context, key models.Api_key) error
+}
+
+// The session breakdowns kept per day in daily_breakdowns once their sessions are deleted
+const (
+	dimensionReferrer  = "referrer"
+	dimensionLanguage  = "language"
+	dimensionBrowser   = "browser"
+	dimensionOS        = "os"
+	dimensionDevice    = "device"
+	dimensionCountry   = "country"
+	dimensionChannel   = "channel"
+	dimensionCampaign  = "campaign"
+	dimensionEntryPage = "entry_page"
+	dimensionExitPage  = "exit_page"
+)
+
+// RollupStore enforces the data retention policy. RollUpDay aggregates the sessions, page views
+// and events of the oldest day before before into daily rollups and deletes them, in one transaction.
+// ok is false when there was nothing older left. Analytics queries read the rollups along with
+// what is left of the raw data, funnels and cohort retention only see the raw data.
+type RollupStore interface {
+	RollUpDay(ctx context.Context, before time.Time) (result models.Rollup_result, ok bool, err error)
+}
+
+// ExportStore streams the raw rows in the range and site of p to each, bots included, until each
+// returns an error. Sessions are ordered by last activity, events by their timestamp and page views
+// by when they were entered; what the retention job rolled up is gone and not exported.
+type ExportStore interface {
+	ExportSessions(ctx context.Context, p analytics.Params, each func(models.Site_session) error) error
+	ExportEvents(ctx context.Context, p analytics.Params, each func(models.Site_event) error) error
+	ExportPageViews(ctx context.Context, p analytics.Params, each func(models.Site_view) error) error
+}
+
+// WebhookStore keeps webhooks and their deliveries. Storing a new human session queues a
+// "session.started" delivery and storing an event an "event" delivery for every webhook of the
+// site that asks for it, in the same transaction as the write.
+type WebhookStore interface {
+	// Webhooks lists the webhooks of a site, of every site for 0, without their secrets
+	Webhooks(ctx context.Context, siteID int) ([]models.Webhook, error)
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error)
+	// DeleteWebhook deletes a webhook with its deliveries
+	DeleteWebhook(ctx context.Context, id int) error
+	// WebhookDeliveries returns the newest deliveries of a webhook first
+	WebhookDeliveries(ctx context.Context, webhookID int, limit int) ([]models.Webhook_delivery, error)
+	// ClaimDeliveries returns up to limit pending deliveries due at now, oldest first, and keeps
+	// them from being claimed again until lease has passed
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.Webhook_delivery, error)
+	RecordAttempt(ctx context.Context, attempt models.Delivery_attempt) error
+	// PruneDeliveries deletes the delivered and failed deliveries created before before
+	PruneDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+// AnalyticsStore answers the named dashboard queries, see analytics.Params for the semantics of the range.
+// Sessions flagged as bots are never counted, and page views only count once their session is stored.
+type AnalyticsStore interface {
+	// SessionsOverTime counts sessions per bucket, including empty buckets
+	SessionsOverTime(ctx context.Context, p analytics.Params) ([]models.Time_bucket, error)
+	// AverageDuration averages session duration (milliseconds) per bucket, 0 for empty buckets
+	AverageDuration(ctx context.Context, p analytics.Params) ([]models.Duration_bucket, error)
+	// TopReferrers returns the referrers with the most sessions. Sessions without one count as "(direct)"
+	TopReferrers(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// Languages returns the browser languages with the most sessions, "(unknown)" when not sent
+	Languages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// Browsers, OperatingSystems and Devices break sessions down by their parsed user agent,
+	// "(unknown)" when it could not be parsed
+	Browsers(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	OperatingSystems(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	Devices(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// Countries breaks sessions down by the ISO code of their GeoIP country, "(unknown)" without one
+	Countries(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// Channels breaks sessions down by acquisition channel, "(unknown)" for sessions stored before
+	// channels were classified
+	Channels(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// Campaigns breaks sessions down by utm_campaign, "(none)" without one
+	Campaigns(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// TopPages returns the paths with the most views entered in the range
+	TopPages(ctx context.Context, p analytics.Params) ([]models.Page_row, error)
+	// EntryPages and ExitPages count sessions by the path of their first and last view in the range
+	EntryPages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	ExitPages(ctx context.Context, p analytics.Params) ([]models.Breakdown_row, error)
+	// Funnel counts the human sessions that reached each step of f, in order and with at most
+	// f.Within between two steps
+	Funnel(ctx context.Context, f analytics.Funnel) ([]models.Funnel_step, error)
+	// Retention groups the users first seen in the range into cohorts by granularity and counts
+	// the ones with a session in each period since, up to the end of the range
+	Retention(ctx context.Context, p analytics.Params) ([]models.Retention_cohort, error)
+}
+
+// UserAgentBackfill parses the user agents of sessions stored before the backend parsed them.
+// A raw user agent the parser gives up on keeps matching, so callers page with after.
+type UserAgentBackfill interface {
+	// UnparsedUserAgents returns up to limit distinct raw user agents sorting after after,
+	// taken from sessions without a device type
+	UnparsedUserAgents(ctx context.Context, after string, limit int) ([]string, error)
+	// SetClient fills the parsed columns of the unparsed sessions with this raw user agent
+	// and returns how many sessions were updated
+	SetClient(ctx context.Context, userAgent string, client models.User_agent) (int64, error)
+}
+
+// ChannelRuleStore keeps the rules the channels package classifies sessions with
+type ChannelRuleStore interface {
+	// ChannelRules returns every rule ordered by priority, then ID
+	ChannelRules(ctx context.Context) ([]models.Channel_rule, error)
+	// CreateChannelRule assigns the ID and returns the stored rule
+	CreateChannelRule(ctx context.Context, rule models.Channel_rule) (models.Channel_rule, error)
+	DeleteChannelRule(ctx context.Context, id int) error
+}
+
+// Partitioner is implemented by stores that split sessions into monthly partitions on last activity.
+// Sessions of a month without a partition wait in a default partition until it is created.
+type Partitioner interface {
+	// EnsurePartitions creates the missing partitions from the month of now through ahead months
+	// later and returns their names
+	EnsurePartitions(ctx context.Context, now time.Time, ahead int) ([]string, error)
+	// DropPartitions rolls up the months that end at or before before, like RollUpDay, then detaches
+	// and drops their partitions instead of deleting the sessions. It returns the dropped names.
+	DropPartitions(ctx context.Context, before time.Time) ([]string, error)
+}
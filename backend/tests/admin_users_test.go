@@ -0,0 +1,47 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/store"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetAdminUser(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	admin := mem.AddAdminUser("admin", "$2a$10$hash")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/getAdminUser", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		h.GetAdminUser(w, req)
+		return w
+	}
+
+	t.Run("Found", func(t *testing.T) {
+		w := post(`{"username": "admin"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var user models.Auth_item
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &user))
+		assert.Equal(t, admin, user)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, post(`{"username": "nobody"}`).Code)
+	})
+
+	t.Run("MissingUsername", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, post(`{}`).Code)
+	})
+}
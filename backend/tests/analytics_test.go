@@ -0,0 +1,181 @@
+package main
+
+import (
+	"Borea/backend/analytics"
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnalyticsParams(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		params, err := analytics.ParseParams(url.Values{"from": {"2024-10-01"}, "to": {"2024-10-31"}})
+		require.NoError(t, err)
+		assert.Equal(t, "day", params.Granularity)
+		assert.Equal(t, 10, params.Limit)
+		assert.Equal(t, "2024-10-01", params.From.Format(analytics.DateLayout))
+		assert.Equal(t, "2024-10-31", params.To.Format(analytics.DateLayout))
+	})
+
+	invalid := map[string]url.Values{
+		"MissingFrom":        {"to": {"2024-10-31"}},
+		"MalformedTo":        {"from": {"2024-10-01"}, "to": {"31/10/2024"}},
+		"ToBeforeFrom":       {"from": {"2024-10-31"}, "to": {"2024-10-01"}},
+		"RangeTooLarge":      {"from": {"2020-01-01"}, "to": {"2024-10-01"}},
+		"UnknownGranularity": {"from": {"2024-10-01"}, "to": {"2024-10-31"}, "granularity": {"hour; DROP TABLE sessions"}},
+		"LimitTooLarge":      {"from": {"2024-10-01"}, "to": {"2024-10-31"}, "limit": {"1000"}},
+		"LimitNotNumber":     {"from": {"2024-10-01"}, "to": {"2024-10-31"}, "limit": {"ten"}},
+		"InvalidSite":        {"from": {"2024-10-01"}, "to": {"2024-10-31"}, "site": {"0"}},
+	}
+
+	for name, query := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, err := analytics.ParseParams(query)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetSessionsOverTime(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	os.Setenv("DOMAIN", "http://example.com")
+
+	// Sessions reference their site, so the two sites below get ids 1 and 2
+	_, err := sites.Create(ctx, mem, "first", []string{"http://first.example.com"})
+	require.NoError(t, err, "Failed to create site")
+	_, err = sites.Create(ctx, mem, "second", []string{"http://second.example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	seed := []struct {
+		id       string
+		activity string
+		duration int64
+		referrer string
+		language string
+		site     int
+	}{
+		{"0b1c6c9e-6f5e-4f3a-8c7e-111111111111", "2024-10-01T10:00:00Z", 1000, "http://google.com", "en", 1},
+		{"0b1c6c9e-6f5e-4f3a-8c7e-222222222222", "2024-10-01T23:59:59Z", 3000, "http://google.com", "en", 2},
+		{"0b1c6c9e-6f5e-4f3a-8c7e-333333333333", "2024-10-03T08:00:00Z", 2000, "", "de", 1},
+		{"0b1c6c9e-6f5e-4f3a-8c7e-444444444444", "2024-10-04T00:00:00Z", 5000, "", "de", 1},
+	}
+
+	for _, row := range seed {
+		activity, err := time.Parse(time.RFC3339, row.activity)
+		require.NoError(t, err)
+
+		session := models.Session{SessionID: row.id, LastActivityTime: &activity, SessionDuration: &row.duration, Language: &row.language}
+		if row.referrer != "" {
+			session.Referrer = &row.referrer
+		}
+		require.NoError(t, mem.UpsertSession(ctx, row.site, session), "Failed to insert sessions")
+	}
+
+	t.Run("DailyBucketsIncludeEmptyDays", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/sessions?from=2024-10-01&to=2024-10-03", nil)
+		w := httptest.NewRecorder()
+
+		h.GetSessionsOverTime(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var buckets []models.Time_bucket
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &buckets))
+		assert.Equal(t, []models.Time_bucket{
+			{Date: "2024-10-01", Count: 2},
+			{Date: "2024-10-02", Count: 0},
+			{Date: "2024-10-03", Count: 1},
+		}, buckets)
+	})
+
+	t.Run("WeeklyBucketsStartOnMonday", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/sessions?from=2024-10-01&to=2024-10-08&granularity=week", nil)
+		w := httptest.NewRecorder()
+
+		h.GetSessionsOverTime(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var buckets []models.Time_bucket
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &buckets))
+		assert.Equal(t, []models.Time_bucket{
+			{Date: "2024-09-30", Count: 4},
+			{Date: "2024-10-07", Count: 0},
+		}, buckets)
+	})
+
+	t.Run("FilterBySite", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/sessions?from=2024-10-01&to=2024-10-01&site=2", nil)
+		w := httptest.NewRecorder()
+
+		h.GetSessionsOverTime(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var buckets []models.Time_bucket
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &buckets))
+		assert.Equal(t, []models.Time_bucket{{Date: "2024-10-01", Count: 1}}, buckets)
+	})
+
+	t.Run("AverageDuration", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/duration?from=2024-10-01&to=2024-10-01", nil)
+		w := httptest.NewRecorder()
+
+		h.GetAverageDuration(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var buckets []models.Duration_bucket
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &buckets))
+		require.Len(t, buckets, 1)
+		assert.Equal(t, 2000.0, buckets[0].AverageDuration)
+	})
+
+	t.Run("TopReferrers", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/referrers?from=2024-10-01&to=2024-10-31&limit=1", nil)
+		w := httptest.NewRecorder()
+
+		h.GetTopReferrers(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var rows []models.Breakdown_row
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rows))
+		require.Len(t, rows, 1)
+		assert.Equal(t, "(direct)", rows[0].Value)
+		assert.Equal(t, 2, rows[0].Count)
+	})
+
+	t.Run("InvalidParams", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/languages?from=2024-10-31&to=2024-10-01", nil)
+		w := httptest.NewRecorder()
+
+		h.GetLanguages(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/analytics/sessions", nil)
+		w := httptest.NewRecorder()
+
+		h.GetSessionsOverTime(w, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"Borea/backend/auth"
+	"Borea/backend/store"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServerKey = "7c1f3a0e-2b9d-4a57-9e3c-5d8b6f4a2c10"
+
+func signTestJWT(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	require.NoError(t, err, "Error signing token")
+	return token
+}
+
+// protectedStatus runs a request with the given Authorization header through Require
+func protectedStatus(a *auth.Authenticator, scope, method, authorization string) int {
+	handler := a.Require(scope, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(method, "/getSites", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestRequireJWT(t *testing.T) {
+	os.Setenv("SERVER_KEY", testServerKey)
+	a := auth.NewAuthenticator(store.NewMemory())
+
+	validClaims := jwt.MapClaims{"username": "admin", "exp": time.Now().Add(time.Hour).Unix()}
+
+	t.Run("ValidToken", func(t *testing.T) {
+		token := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), validClaims)
+		assert.Equal(t, http.StatusOK, protectedStatus(a, auth.ScopeWrite, http.MethodPost, "Bearer "+token))
+	})
+
+	t.Run("MissingCredentials", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, ""))
+	})
+
+	t.Run("WrongScheme", func(t *testing.T) {
+		token := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), validClaims)
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Basic "+token))
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		token := signTestJWT(t, jwt.SigningMethodHS256, []byte("not the server key"), validClaims)
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+token))
+	})
+
+	t.Run("ExpiredToken", func(t *testing.T) {
+		claims := jwt.MapClaims{"username": "admin", "exp": time.Now().Add(-time.Minute).Unix()}
+		token := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), claims)
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+token))
+	})
+
+	t.Run("MissingExpiry", func(t *testing.T) {
+		token := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), jwt.MapClaims{"username": "admin"})
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+token))
+	})
+
+	t.Run("UnsignedToken", func(t *testing.T) {
+		token := signTestJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, validClaims)
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+token))
+	})
+
+	t.Run("EmptyServerKey", func(t *testing.T) {
+		os.Setenv("SERVER_KEY", "")
+		defer os.Setenv("SERVER_KEY", testServerKey)
+
+		token := signTestJWT(t, jwt.SigningMethodHS256, []byte(""), validClaims)
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+token))
+	})
+
+	t.Run("LoginScope", func(t *testing.T) {
+		admin := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), validClaims)
+		assert.Equal(t, http.StatusForbidden, protectedStatus(a, auth.ScopeLogin, http.MethodPost, "Bearer "+admin), "Sessions can't read password hashes")
+
+		login := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), jwt.MapClaims{"scope": "login", "exp": time.Now().Add(time.Minute).Unix()})
+		assert.Equal(t, http.StatusOK, protectedStatus(a, auth.ScopeLogin, http.MethodPost, "Bearer "+login))
+		assert.Equal(t, http.StatusForbidden, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+login), "A login token reads nothing else")
+
+		other := signTestJWT(t, jwt.SigningMethodHS256, []byte(testServerKey), jwt.MapClaims{"scope": "read", "exp": time.Now().Add(time.Minute).Unix()})
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+other))
+	})
+
+	t.Run("PreflightPassesThrough", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, protectedStatus(a, auth.ScopeRead, http.MethodOptions, ""))
+	})
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	a := auth.NewAuthenticator(mem)
+
+	readKey, err := auth.CreateAPIKey(ctx, mem, "reader", []string{auth.ScopeRead})
+	require.NoError(t, err, "Failed to create api key")
+
+	t.Run("ScopeGranted", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+readKey))
+	})
+
+	t.Run("ScopeMissing", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, protectedStatus(a, auth.ScopeWrite, http.MethodPost, "Bearer "+readKey))
+	})
+
+	t.Run("APIKeyHeader", func(t *testing.T) {
+		handler := a.Require(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := auth.FromContext(r.Context())
+			assert.True(t, ok)
+			assert.Equal(t, "reader", principal.Name)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/analytics/sessions", nil)
+		req.Header.Set("X-API-Key", readKey)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+auth.APIKeyPrefix+"0000"))
+	})
+
+	t.Run("RevokedKey", func(t *testing.T) {
+		mem.RevokeAPIKey(auth.HashAPIKey(readKey))
+
+		assert.Equal(t, http.StatusUnauthorized, protectedStatus(a, auth.ScopeRead, http.MethodPost, "Bearer "+readKey))
+	})
+
+	t.Run("UnknownScope", func(t *testing.T) {
+		_, err := auth.CreateAPIKey(ctx, mem, "admin", []string{"admin"})
+		assert.Error(t, err)
+	})
+}
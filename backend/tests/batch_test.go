@@ -0,0 +1,166 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostBatch(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	batchURL := "/batch?token=" + site.TrackingToken
+
+	sessionID := "5f0c2f1e-8d44-4a53-a1a4-7a3b3d7c9e21"
+	session := map[string]interface{}{
+		"sessionId":        sessionID,
+		"lastActivityTime": time.Now().Format(time.RFC3339),
+		"sessionDuration":  3000,
+		"userAgent":        "Mozilla/5.0",
+		"referrer":         "http://google.com",
+		"startTime":        time.Now().Format(time.RFC3339),
+		"language":         "en",
+	}
+
+	t.Run("JSONArrayWithInvalidItem", func(t *testing.T) {
+		items := []map[string]interface{}{
+			{"type": "session", "data": session},
+			{"type": "event", "data": map[string]interface{}{"sessionId": sessionID, "name": "signup"}},
+			{"type": "event", "data": map[string]interface{}{"sessionId": sessionID}},
+			{"type": "unknown", "data": map[string]interface{}{}},
+		}
+
+		body, _ := json.Marshal(items)
+		req := httptest.NewRequest(http.MethodPost, batchURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var results []models.Batch_result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		require.Len(t, results, 4)
+		assert.True(t, results[0].Success)
+		assert.True(t, results[1].Success)
+		assert.False(t, results[2].Success)
+		assert.NotEmpty(t, results[2].Error)
+		assert.False(t, results[3].Success)
+
+		stored, ok := mem.Session(sessionID)
+		require.True(t, ok)
+		assert.Equal(t, site.ID, stored.SiteID)
+		assert.Len(t, mem.Events(site.ID), 1)
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		lines := []string{
+			`{"type": "event", "data": {"sessionId": "` + sessionID + `", "name": "purchase", "properties": {"total": 42}}}`,
+			`{not json}`,
+			``,
+			`{"type": "event", "data": {"sessionId": "` + sessionID + `", "name": "purchase"}}`,
+		}
+
+		req := httptest.NewRequest(http.MethodPost, batchURL, strings.NewReader(strings.Join(lines, "\n")))
+		req.Header.Set("Origin", "http://example.com")
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var results []models.Batch_result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		require.Len(t, results, 3)
+		assert.True(t, results[0].Success)
+		assert.False(t, results[1].Success)
+		assert.True(t, results[2].Success)
+
+		count := 0
+		for _, event := range mem.Events(site.ID) {
+			if event.Name == "purchase" {
+				count++
+			}
+		}
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("WriteErrorOnlyFailsItem", func(t *testing.T) {
+		// The session already belongs to another site, so the store refuses to write it
+		otherSessionID := "0e3b8f6a-2f4f-4d1b-9a59-1b6f0c2e8d33"
+		other, err := sites.Create(context.Background(), mem, "other", []string{"http://other.example.com"})
+		require.NoError(t, err, "Failed to create site")
+		require.NoError(t, mem.UpsertSession(context.Background(), other.ID, models.Session{SessionID: otherSessionID}))
+
+		items := []map[string]interface{}{
+			{"type": "session", "data": map[string]interface{}{"sessionId": otherSessionID}},
+			{"type": "event", "data": map[string]interface{}{"sessionId": sessionID, "name": "after-error"}},
+		}
+
+		body, _ := json.Marshal(items)
+		req := httptest.NewRequest(http.MethodPost, batchURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var results []models.Batch_result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		require.Len(t, results, 2)
+		assert.False(t, results[0].Success)
+		assert.True(t, results[1].Success)
+
+		stored, ok := mem.Session(otherSessionID)
+		require.True(t, ok)
+		assert.Equal(t, other.ID, stored.SiteID)
+	})
+
+	t.Run("EmptyBatch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, batchURL, strings.NewReader(`[]`))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("MalformedArray", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, batchURL, strings.NewReader(`[{"type": "event"`))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/batch", nil)
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
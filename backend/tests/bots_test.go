@@ -0,0 +1,209 @@
+package main
+
+import (
+	"Borea/backend/bots"
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"Borea/backend/useragent"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyBots(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-time.Minute)
+	future := now.Add(time.Hour)
+
+	// beacon builds a session the way a browser would send it, before the case changes it
+	beacon := func(change func(s *models.Session)) models.Session {
+		userAgent, language, duration := windowsChrome, "en-US", int64(60000)
+		session := models.Session{
+			SessionID:        "2e4f6a8b-1c3d-4e5f-8a9b-0c1d2e3f4a5b",
+			LastActivityTime: &now,
+			StartTime:        &earlier,
+			SessionDuration:  &duration,
+			UserAgent:        &userAgent,
+			Language:         &language,
+		}
+		if change != nil {
+			change(&session)
+		}
+		useragent.Apply(&session)
+		return session
+	}
+	withUserAgent := func(userAgent string) func(s *models.Session) {
+		return func(s *models.Session) { s.UserAgent = &userAgent }
+	}
+
+	cases := map[string]struct {
+		session models.Session
+		bot     bool
+	}{
+		"Person":             {beacon(nil), false},
+		"MobilePerson":       {beacon(withUserAgent(iPhoneSafari)), false},
+		"KnownCrawler":       {beacon(withUserAgent(googlebot)), true},
+		"HeadlessChrome":     {beacon(withUserAgent("Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36")), true},
+		"UptimeMonitor":      {beacon(withUserAgent("Mozilla/5.0+(compatible; UptimeRobot/2.0; http://www.uptimerobot.com/)")), true},
+		"CommandLineClient":  {beacon(withUserAgent("curl/8.4.0")), true},
+		"FutureActivity":     {beacon(func(s *models.Session) { s.LastActivityTime = &future }), true},
+		"StartAfterActivity": {beacon(func(s *models.Session) { s.StartTime, s.LastActivityTime = &now, &earlier }), true},
+		"NegativeDuration": {beacon(func(s *models.Session) {
+			duration := int64(-1)
+			s.SessionDuration = &duration
+		}), true},
+		"OnlyMissingLanguage": {beacon(func(s *models.Session) { s.Language = nil }), false},
+		"MissingLanguageAndZeroDuration": {beacon(func(s *models.Session) {
+			duration := int64(0)
+			s.Language, s.SessionDuration = nil, &duration
+		}), true},
+		"NoUserAgentNoLanguage": {beacon(func(s *models.Session) { s.UserAgent, s.Language = nil, nil }), true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			verdict := bots.Classify(c.session, now)
+			assert.Equal(t, c.bot, verdict.Bot, "reasons: %v", verdict.Reasons)
+			assert.Equal(t, c.bot, len(verdict.Reasons) > 0)
+		})
+	}
+}
+
+func TestBotPolicy(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	headless := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36"
+
+	post := func(site models.Site, sessionID, userAgent string) int {
+		body, _ := json.Marshal(map[string]interface{}{
+			"sessionId":        sessionID,
+			"lastActivityTime": "2024-10-01T10:00:00Z",
+			"sessionDuration":  4000,
+			"userAgent":        userAgent,
+			"language":         "en",
+		})
+		req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", site.AllowedOrigins[0])
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+		return w.Code
+	}
+
+	newSite := func(name, policy string) models.Site {
+		site, err := sites.Create(ctx, mem, name, []string{"https://" + name + ".example.com"})
+		require.NoError(t, err, "Failed to create site")
+		assert.Equal(t, bots.PolicyFlag, site.BotPolicy, "New sites flag bots")
+
+		require.NoError(t, sites.SetBotPolicy(ctx, mem, site.ID, policy))
+		site, err = mem.SiteByToken(ctx, site.TrackingToken)
+		require.NoError(t, err)
+		return site
+	}
+
+	t.Run("Drop", func(t *testing.T) {
+		site := newSite("drop", bots.PolicyDrop)
+
+		assert.Equal(t, http.StatusAccepted, post(site, "a1b2c3d4-0000-4000-8000-000000000001", headless))
+		_, ok := mem.Session("a1b2c3d4-0000-4000-8000-000000000001")
+		assert.False(t, ok, "Dropped bots are never stored")
+
+		assert.Equal(t, http.StatusOK, post(site, "a1b2c3d4-0000-4000-8000-000000000002", windowsChrome))
+		_, ok = mem.Session("a1b2c3d4-0000-4000-8000-000000000002")
+		assert.True(t, ok)
+	})
+
+	t.Run("Flag", func(t *testing.T) {
+		site := newSite("flag", bots.PolicyFlag)
+
+		assert.Equal(t, http.StatusOK, post(site, "a1b2c3d4-0000-4000-8000-000000000003", headless))
+		assert.Equal(t, http.StatusOK, post(site, "a1b2c3d4-0000-4000-8000-000000000004", windowsChrome))
+
+		stored, ok := mem.Session("a1b2c3d4-0000-4000-8000-000000000003")
+		require.True(t, ok)
+		assert.True(t, stored.Session.IsBot)
+
+		// A later beacon that looks human does not clear the flag
+		assert.Equal(t, http.StatusOK, post(site, "a1b2c3d4-0000-4000-8000-000000000003", windowsChrome))
+		stored, _ = mem.Session("a1b2c3d4-0000-4000-8000-000000000003")
+		assert.True(t, stored.Session.IsBot)
+
+		counts, err := mem.SessionsOverTime(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Time_bucket{{Date: "2024-10-01", Count: 1}}, counts, "Flagged bots are left out of analytics")
+	})
+
+	t.Run("Keep", func(t *testing.T) {
+		site := newSite("keep", bots.PolicyKeep)
+
+		assert.Equal(t, http.StatusOK, post(site, "a1b2c3d4-0000-4000-8000-000000000005", headless))
+
+		stored, ok := mem.Session("a1b2c3d4-0000-4000-8000-000000000005")
+		require.True(t, ok)
+		assert.False(t, stored.Session.IsBot)
+	})
+
+	t.Run("DropInBatch", func(t *testing.T) {
+		site := newSite("batch", bots.PolicyDrop)
+
+		items := []map[string]interface{}{
+			{"type": "session", "data": map[string]interface{}{"sessionId": "a1b2c3d4-0000-4000-8000-000000000006", "userAgent": headless, "language": "en"}},
+			{"type": "session", "data": map[string]interface{}{"sessionId": "a1b2c3d4-0000-4000-8000-000000000007", "userAgent": windowsChrome, "language": "en"}},
+		}
+		body, _ := json.Marshal(items)
+		req := httptest.NewRequest(http.MethodPost, "/batch?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", site.AllowedOrigins[0])
+		w := httptest.NewRecorder()
+
+		h.PostBatch(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var results []models.Batch_result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+		require.Len(t, results, 2)
+		assert.True(t, results[0].Success)
+		assert.True(t, results[1].Success)
+
+		_, ok := mem.Session("a1b2c3d4-0000-4000-8000-000000000006")
+		assert.False(t, ok)
+		_, ok = mem.Session("a1b2c3d4-0000-4000-8000-000000000007")
+		assert.True(t, ok)
+	})
+}
+
+func TestSetBotPolicyHandler(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/setBotPolicy", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		h.SetBotPolicy(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(`{"siteId": 1, "botPolicy": "drop"}`))
+	site, err = mem.SiteByToken(context.Background(), site.TrackingToken)
+	require.NoError(t, err)
+	assert.Equal(t, bots.PolicyDrop, site.BotPolicy)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"siteId": 1, "botPolicy": "ban"}`))
+	assert.Equal(t, http.StatusNotFound, post(`{"siteId": 42, "botPolicy": "keep"}`))
+	assert.Equal(t, http.StatusBadRequest, post(`{not json}`))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"Borea/backend/channels"
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCampaign(t *testing.T) {
+	campaign := channels.ParseCampaign("https://example.com/pricing?utm_source=Newsletter&utm_medium=email&utm_campaign=spring%20sale&utm_term=analytics&utm_content=header&ref=x")
+	require.NotNil(t, campaign.Source)
+	require.NotNil(t, campaign.Medium)
+	require.NotNil(t, campaign.Name)
+	require.NotNil(t, campaign.Term)
+	require.NotNil(t, campaign.Content)
+	assert.Equal(t, "Newsletter", *campaign.Source)
+	assert.Equal(t, "email", *campaign.Medium)
+	assert.Equal(t, "spring sale", *campaign.Name)
+	assert.Equal(t, "analytics", *campaign.Term)
+	assert.Equal(t, "header", *campaign.Content)
+
+	assert.Equal(t, models.Campaign{}, channels.ParseCampaign("https://example.com/?utm_source="), "Empty parameters are not kept")
+	assert.Equal(t, models.Campaign{}, channels.ParseCampaign("https://example.com/"))
+	assert.Equal(t, models.Campaign{}, channels.ParseCampaign("%zz"))
+}
+
+func TestClassifyChannels(t *testing.T) {
+	site := models.Site{AllowedOrigins: []string{"https://example.com", "https://shop.example.com"}}
+
+	session := func(referrer, landingPage string) models.Session {
+		var s models.Session
+		if referrer != "" {
+			s.Referrer = &referrer
+		}
+		if landingPage != "" {
+			s.LandingPage = &landingPage
+			s.Campaign = channels.ParseCampaign(landingPage)
+		}
+		return s
+	}
+
+	cases := map[string]struct {
+		referrer    string
+		landingPage string
+		expected    string
+	}{
+		"NoReferrer":          {"", "https://example.com/", channels.Direct},
+		"SearchEngine":        {"https://www.google.com/", "https://example.com/", channels.Search},
+		"CountrySearchDomain": {"https://www.google.co.uk/", "https://example.com/", channels.Search},
+		"SocialNetwork":       {"https://l.facebook.com/", "https://example.com/", channels.Social},
+		"ShortLink":           {"https://t.co/abc", "https://example.com/", channels.Social},
+		"Webmail":             {"https://mail.google.com/", "https://example.com/", channels.Email},
+		"OtherSite":           {"https://blog.someone.org/post", "https://example.com/", channels.Referral},
+		"SameHost":            {"https://example.com/about", "https://example.com/pricing", channels.Internal},
+		"OtherAllowedOrigin":  {"https://shop.example.com/cart", "https://example.com/", channels.Internal},
+		"PaidMedium":          {"https://www.google.com/", "https://example.com/?utm_source=google&utm_medium=cpc", channels.Paid},
+		"EmailMedium":         {"", "https://example.com/?utm_source=mailchimp&utm_medium=Email", channels.Email},
+		"NewsletterSource":    {"", "https://example.com/?utm_source=newsletter", channels.Email},
+		"TaggedInternalLink":  {"https://example.com/", "https://example.com/?utm_medium=social", channels.Social},
+		"UnknownCampaignOnly": {"", "https://example.com/?utm_source=partner", channels.Referral},
+		"ReferrerWithoutHost": {"not a url", "https://example.com/", channels.Direct},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.expected, channels.Classify(channels.DefaultRules(), session(c.referrer, c.landingPage), site))
+		})
+	}
+
+	t.Run("FirstMatchWins", func(t *testing.T) {
+		rules := append([]models.Channel_rule{{Channel: channels.Paid, Field: channels.FieldReferrerHost, Pattern: `^google\.com$`}}, channels.DefaultRules()...)
+		assert.Equal(t, channels.Paid, channels.Classify(rules, session("https://google.com/", ""), site))
+	})
+
+	t.Run("InvalidStoredPatternIsSkipped", func(t *testing.T) {
+		rules := []models.Channel_rule{{Channel: channels.Paid, Field: channels.FieldReferrerHost, Pattern: `(`}}
+		assert.Equal(t, channels.Referral, channels.Classify(rules, session("https://google.com/", ""), site))
+	})
+}
+
+func TestSessionChannel(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	require.NoError(t, channels.EnsureDefaults(ctx, mem))
+	require.NoError(t, channels.EnsureDefaults(ctx, mem), "Defaults are only stored into an empty table")
+
+	rules, err := mem.ChannelRules(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rules, len(channels.DefaultRules()))
+
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(ctx, mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	post := func(sessionID, referrer, landingPage string) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"sessionId":        sessionID,
+			"lastActivityTime": "2024-10-01T10:00:00Z",
+			"userAgent":        windowsChrome,
+			"language":         "en",
+			"referrer":         referrer,
+			"landingPage":      landingPage,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	post("c0ffee00-1111-4222-8333-444455556666", "https://www.bing.com/", "http://example.com/?utm_source=bing&utm_medium=cpc&utm_campaign=launch")
+	post("c0ffee00-1111-4222-8333-444455556667", "https://news.ycombinator.com/item?id=1", "http://example.com/blog")
+
+	stored, ok := mem.Session("c0ffee00-1111-4222-8333-444455556666")
+	require.True(t, ok)
+	require.NotNil(t, stored.Session.Channel)
+	require.NotNil(t, stored.Session.Campaign.Name)
+	assert.Equal(t, channels.Paid, *stored.Session.Channel)
+	assert.Equal(t, "launch", *stored.Session.Campaign.Name)
+	assert.Equal(t, "bing", *stored.Session.Campaign.Source)
+
+	// The next page of the same visit is internal, the channel of the landing beacon stays
+	post("c0ffee00-1111-4222-8333-444455556666", "http://example.com/?utm_source=bing", "http://example.com/pricing")
+	stored, _ = mem.Session("c0ffee00-1111-4222-8333-444455556666")
+	assert.Equal(t, channels.Paid, *stored.Session.Channel)
+
+	req := httptest.NewRequest(http.MethodGet, "/analytics/channels?from=2024-10-01&to=2024-10-01", nil)
+	w := httptest.NewRecorder()
+
+	h.GetChannels(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"value": "paid", "count": 1}, {"value": "social", "count": 1}]`, w.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/analytics/campaigns?from=2024-10-01&to=2024-10-01", nil)
+	w = httptest.NewRecorder()
+
+	h.GetCampaigns(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"value": "(none)", "count": 1}, {"value": "launch", "count": 1}]`, w.Body.String())
+}
+
+func TestChannelRuleHandlers(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	post := func(handler http.HandlerFunc, route, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, route, bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+		return w
+	}
+
+	track := func(sessionID string) string {
+		body, _ := json.Marshal(map[string]interface{}{
+			"sessionId":        sessionID,
+			"lastActivityTime": "2024-10-01T10:00:00Z",
+			"userAgent":        windowsChrome,
+			"language":         "en",
+			"referrer":         "https://partner.example.org/deals",
+		})
+		req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stored, ok := mem.Session(sessionID)
+		require.True(t, ok)
+		return *stored.Session.Channel
+	}
+
+	assert.Equal(t, channels.Referral, track("d00dfeed-0000-4000-8000-000000000001"))
+
+	w := post(h.CreateChannelRule, "/createChannelRule", `{"channel": "paid", "field": "referrer_host", "pattern": "^partner\\.example\\.org$", "priority": 5}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var rule models.Channel_rule
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rule))
+	assert.NotZero(t, rule.ID)
+
+	assert.Equal(t, channels.Paid, track("d00dfeed-0000-4000-8000-000000000002"), "A new rule applies to the next session")
+
+	req := httptest.NewRequest(http.MethodGet, "/getChannelRules", nil)
+	w = httptest.NewRecorder()
+	h.GetChannelRules(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"id": 1, "channel": "paid", "field": "referrer_host", "pattern": "^partner\\.example\\.org$", "priority": 5}]`, w.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, post(h.CreateChannelRule, "/createChannelRule", `{"channel": "tv", "field": "utm_source", "pattern": "x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(h.CreateChannelRule, "/createChannelRule", `{"channel": "paid", "field": "path", "pattern": "x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(h.CreateChannelRule, "/createChannelRule", `{"channel": "paid", "field": "utm_source", "pattern": "("}`).Code)
+
+	assert.Equal(t, http.StatusOK, post(h.DeleteChannelRule, "/deleteChannelRule", `{"id": 1}`).Code)
+	assert.Equal(t, http.StatusNotFound, post(h.DeleteChannelRule, "/deleteChannelRule", `{"id": 1}`).Code)
+
+	assert.Equal(t, channels.Referral, track("d00dfeed-0000-4000-8000-000000000003"), "A deleted rule stops applying")
+}
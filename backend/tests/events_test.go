@@ -0,0 +1,127 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostEvent(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	eventURL := "/event?token=" + site.TrackingToken
+
+	sessionID := "b9a0a6c2-6a53-4c0e-9d55-0f0d3c6b6f11"
+
+	t.Run("InsertEvent", func(t *testing.T) {
+		event := map[string]interface{}{
+			"sessionId":  sessionID,
+			"name":       "signup",
+			"timestamp":  time.Now().Format(time.RFC3339),
+			"pageUrl":    "http://example.com/pricing",
+			"properties": map[string]interface{}{"plan": "pro", "seats": 3},
+		}
+
+		body, _ := json.Marshal(event)
+		req := httptest.NewRequest(http.MethodPost, eventURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		events := mem.Events(site.ID)
+		require.Len(t, events, 1)
+		assert.Equal(t, sessionID, events[0].SessionID)
+		assert.Equal(t, "signup", events[0].Name)
+
+		var properties map[string]interface{}
+		require.NoError(t, json.Unmarshal(events[0].Properties, &properties))
+		assert.Equal(t, "pro", properties["plan"])
+	})
+
+	t.Run("InsertEventWithoutProperties", func(t *testing.T) {
+		body := []byte(`{"sessionId": "` + sessionID + `", "name": "click"}`)
+		req := httptest.NewRequest(http.MethodPost, eventURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		events := mem.Events(site.ID)
+		require.Len(t, events, 2)
+		assert.Equal(t, "click", events[1].Name)
+		assert.Equal(t, "{}", string(events[1].Properties))
+		assert.NotNil(t, events[1].Timestamp, "The event time should default to now")
+	})
+
+	t.Run("InvalidSessionID", func(t *testing.T) {
+		body := []byte(`{"sessionId": "not-a-uuid", "name": "click"}`)
+		req := httptest.NewRequest(http.MethodPost, eventURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("MissingName", func(t *testing.T) {
+		body := []byte(`{"sessionId": "` + sessionID + `"}`)
+		req := httptest.NewRequest(http.MethodPost, eventURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("PropertiesNotAnObject", func(t *testing.T) {
+		body := []byte(`{"sessionId": "` + sessionID + `", "name": "click", "properties": [1, 2]}`)
+		req := httptest.NewRequest(http.MethodPost, eventURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("UnknownSite", func(t *testing.T) {
+		body := []byte(`{"sessionId": "` + sessionID + `", "name": "click"}`)
+		req := httptest.NewRequest(http.MethodPost, "/event", bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://unknown.example.com")
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/event", nil)
+		w := httptest.NewRecorder()
+
+		h.PostEvent(w, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"Borea/backend/export"
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exportedSession reads back the sessions of a Parquet export
+type exportedSession struct {
+	SessionID string    `parquet:"session_id"`
+	Duration  *int64    `parquet:"duration_ms,optional"`
+	StartTime time.Time `parquet:"start_time,optional,timestamp(microsecond)"`
+	IsBot     bool      `parquet:"is_bot"`
+	Referrer  *string   `parquet:"referrer,optional"`
+}
+
+type exportedEvent struct {
+	Name       string `parquet:"name"`
+	Properties string `parquet:"properties,optional,json"`
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	os.Setenv("DOMAIN", "http://example.com")
+
+	_, err := sites.Create(ctx, mem, "first", []string{"http://first.example.com"})
+	require.NoError(t, err, "Failed to create site")
+	_, err = sites.Create(ctx, mem, "second", []string{"http://second.example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	at := func(value string) *time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err)
+		return &parsed
+	}
+	text := func(value string) *string { return &value }
+	duration := int64(4000)
+
+	require.NoError(t, mem.UpsertSession(ctx, 1, models.Session{
+		SessionID:        "0b1c6c9e-6f5e-4f3a-8c7e-222222222222",
+		LastActivityTime: at("2024-10-02T09:00:00Z"),
+		StartTime:        at("2024-10-02T08:59:56Z"),
+		SessionDuration:  &duration,
+		Referrer:         text("http://google.com/search?q=a,b"),
+		Token:            text("secret"),
+	}))
+	require.NoError(t, mem.UpsertSession(ctx, 1, models.Session{
+		SessionID:        "0b1c6c9e-6f5e-4f3a-8c7e-111111111111",
+		LastActivityTime: at("2024-10-01T10:00:00Z"),
+		Language:         text("en"),
+		IsBot:            true,
+	}))
+	require.NoError(t, mem.UpsertSession(ctx, 2, models.Session{
+		SessionID:        "0b1c6c9e-6f5e-4f3a-8c7e-333333333333",
+		LastActivityTime: at("2024-10-01T12:00:00Z"),
+	}))
+	require.NoError(t, mem.UpsertSession(ctx, 1, models.Session{
+		SessionID:        "0b1c6c9e-6f5e-4f3a-8c7e-444444444444",
+		LastActivityTime: at("2024-10-04T00:00:00Z"),
+	}))
+
+	require.NoError(t, mem.InsertEvent(ctx, 1, models.Event{
+		SessionID:  "0b1c6c9e-6f5e-4f3a-8c7e-222222222222",
+		Name:       "signup",
+		Timestamp:  at("2024-10-02T09:00:00Z"),
+		PageURL:    "http://first.example.com/signup",
+		Properties: json.RawMessage(`{"plan":"pro"}`),
+	}))
+
+	title := "Pricing"
+	require.NoError(t, mem.UpsertPageView(ctx, 1, models.Page_view{
+		ViewID:    "9d6b1f5e-3c2a-4b8e-9f1d-111111111111",
+		SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-222222222222",
+		Path:      "/pricing",
+		Title:     &title,
+		Timestamp: at("2024-10-02T08:59:58Z"),
+	}))
+
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		w := httptest.NewRecorder()
+
+		if strings.HasPrefix(target, "/export/events") {
+			h.ExportEvents(w, req)
+		} else if strings.HasPrefix(target, "/export/pageviews") {
+			h.ExportPageViews(w, req)
+		} else {
+			h.ExportSessions(w, req)
+		}
+		return w
+	}
+
+	t.Run("SessionsAsCSV", func(t *testing.T) {
+		w := get("/export/sessions?from=2024-10-01&to=2024-10-03&site=1&columns=session_id,referrer,duration_ms,is_bot,start_time", nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "sessions-2024-10-01-2024-10-03.csv")
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"session_id", "referrer", "duration_ms", "is_bot", "start_time"},
+			{"0b1c6c9e-6f5e-4f3a-8c7e-111111111111", "", "", "true", ""},
+			{"0b1c6c9e-6f5e-4f3a-8c7e-222222222222", "http://google.com/search?q=a,b", "4000", "false", "2024-10-02T08:59:56Z"},
+		}, records, "Rows should be ordered by last activity, bots included")
+	})
+
+	t.Run("TokenIsNotExported", func(t *testing.T) {
+		w := get("/export/sessions?from=2024-10-01&to=2024-10-03", nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "secret")
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		require.NoError(t, err)
+		assert.Len(t, records, 4, "A header and the three sessions of both sites")
+	})
+
+	t.Run("EventsAsNDJSON", func(t *testing.T) {
+		w := get("/export/events?from=2024-10-01&to=2024-10-03&format=ndjson", nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		assert.Equal(t,
+			`{"site_id":1,"session_id":"0b1c6c9e-6f5e-4f3a-8c7e-222222222222","name":"signup","timestamp":"2024-10-02T09:00:00Z","page_url":"http://first.example.com/signup","properties":{"plan":"pro"}}`+"\n",
+			w.Body.String())
+	})
+
+	t.Run("Gzip", func(t *testing.T) {
+		w := get("/export/sessions?from=2024-10-01&to=2024-10-03&format=ndjson&columns=session_id", http.Header{"Accept-Encoding": {"br, gzip"}})
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+		reader, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, 3, bytes.Count(body, []byte("\n")))
+		assert.Contains(t, string(body), `{"session_id":"0b1c6c9e-6f5e-4f3a-8c7e-333333333333"}`)
+	})
+
+	t.Run("SessionsAsParquet", func(t *testing.T) {
+		w := get("/export/sessions?from=2024-10-01&to=2024-10-03&site=1&format=parquet&columns=session_id,duration_ms,start_time,is_bot,referrer", http.Header{"Accept-Encoding": {"gzip"}})
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/vnd.apache.parquet", w.Header().Get("Content-Type"))
+		assert.Empty(t, w.Header().Get("Content-Encoding"), "Parquet is compressed on its own")
+
+		file, err := parquet.OpenFile(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), file.NumRows())
+
+		schema := file.Schema()
+		duration, ok := schema.Lookup("duration_ms")
+		require.True(t, ok)
+		assert.Equal(t, parquet.Int64, duration.Node.Type().Kind())
+		assert.True(t, duration.Node.Optional())
+		startTime, ok := schema.Lookup("start_time")
+		require.True(t, ok)
+		assert.NotNil(t, startTime.Node.Type().LogicalType().Timestamp, "Times are typed as timestamps")
+		_, ok = schema.Lookup("language")
+		assert.False(t, ok, "Only the selected columns are written")
+
+		rows, err := parquet.Read[exportedSession](bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		assert.Equal(t, exportedSession{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-111111111111", IsBot: true}, rows[0])
+		assert.Equal(t, "http://google.com/search?q=a,b", *rows[1].Referrer)
+		assert.Equal(t, int64(4000), *rows[1].Duration)
+		assert.True(t, rows[1].StartTime.Equal(time.Date(2024, 10, 2, 8, 59, 56, 0, time.UTC)), rows[1].StartTime.String())
+	})
+
+	t.Run("PageViews", func(t *testing.T) {
+		w := get("/export/pageviews?from=2024-10-02&to=2024-10-02&format=ndjson", nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t,
+			`{"site_id":1,"view_id":"9d6b1f5e-3c2a-4b8e-9f1d-111111111111","session_id":"0b1c6c9e-6f5e-4f3a-8c7e-222222222222","path":"/pricing","title":"Pricing","query":null,"timestamp":"2024-10-02T08:59:58Z","duration_ms":null}`+"\n",
+			w.Body.String())
+	})
+
+	t.Run("ToFile", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "events.parquet")
+
+		require.NoError(t, export.ToFile(ctx, mem, export.Events, analyticsParams(t, "2024-10-01", "2024-10-03", 1), export.FormatParquet, path))
+
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "The temporary file is renamed into place")
+
+		rows, err := parquet.ReadFile[exportedEvent](path)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		assert.Equal(t, "signup", rows[0].Name)
+		assert.JSONEq(t, `{"plan":"pro"}`, rows[0].Properties)
+	})
+
+	t.Run("EmptyExportHasHeader", func(t *testing.T) {
+		w := get("/export/events?from=2024-09-01&to=2024-09-30", nil)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "site_id,session_id,name,timestamp,page_url,properties\n", w.Body.String())
+	})
+
+	invalid := map[string]string{
+		"UnknownColumn": "/export/sessions?from=2024-10-01&to=2024-10-03&columns=session_id,password",
+		"UnknownFormat": "/export/sessions?from=2024-10-01&to=2024-10-03&format=xml",
+		"MissingRange":  "/export/events?format=csv",
+	}
+
+	for name, target := range invalid {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, get(target, nil).Code)
+		})
+	}
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/export/sessions", nil)
+		w := httptest.NewRecorder()
+
+		h.ExportSessions(w, req)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
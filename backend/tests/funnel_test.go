@@ -0,0 +1,101 @@
+package main
+
+import (
+	"Borea/backend/analytics"
+	"Borea/backend/handlers"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFunnel(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	sessionID := "2b3c4d5e-6f70-4819-8a2b-3c4d5e6f7081"
+	items := []map[string]interface{}{
+		{"type": "pageview", "data": map[string]interface{}{
+			"viewId": "2b3c4d5e-6f70-4819-8a2b-000000000001", "sessionId": sessionID, "path": "/pricing", "timestamp": "2024-10-01T10:00:00Z",
+		}},
+		{"type": "pageview", "data": map[string]interface{}{
+			"viewId": "2b3c4d5e-6f70-4819-8a2b-000000000002", "sessionId": sessionID, "path": "/signup", "timestamp": "2024-10-01T10:01:00Z",
+		}},
+		{"type": "event", "data": map[string]interface{}{"sessionId": sessionID, "name": "signup", "timestamp": "2024-10-01T10:02:00Z"}},
+		{"type": "event", "data": map[string]interface{}{"sessionId": sessionID, "name": "checkout", "timestamp": "2024-10-02T00:01:00Z"}},
+		{"type": "session", "data": map[string]interface{}{
+			"sessionId": sessionID, "lastActivityTime": "2024-10-01T10:02:00Z", "userAgent": windowsChrome, "language": "en",
+		}},
+	}
+	body, _ := json.Marshal(items)
+	req := httptest.NewRequest(http.MethodPost, "/batch?token="+site.TrackingToken, bytes.NewBuffer(body))
+	req.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+
+	h.PostBatch(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/funnel?from=2024-10-01&to=2024-10-01&"+query, nil)
+		w := httptest.NewRecorder()
+
+		h.GetFunnel(w, req)
+		return w
+	}
+
+	w = get("step=pageview:/pricing&step=pageview:/signup&step=event:signup&step=event:checkout&within=300")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `[
+		{"step": "pageview:/pricing", "sessions": 1, "conversion": 1, "dropOff": 0, "dropOffRate": 0},
+		{"step": "pageview:/signup", "sessions": 1, "conversion": 1, "dropOff": 0, "dropOffRate": 0},
+		{"step": "event:signup", "sessions": 1, "conversion": 1, "dropOff": 0, "dropOffRate": 0},
+		{"step": "event:checkout", "sessions": 0, "conversion": 0, "dropOff": 1, "dropOffRate": 1}
+	]`, w.Body.String())
+
+	w = get("step=event:signup&step=event:checkout")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `[
+		{"step": "event:signup", "sessions": 1, "conversion": 1, "dropOff": 0, "dropOffRate": 0},
+		{"step": "event:checkout", "sessions": 0, "conversion": 0, "dropOff": 1, "dropOffRate": 1}
+	]`, w.Body.String(), "The checkout after the range does not count towards it")
+
+	t.Run("InvalidFunnels", func(t *testing.T) {
+		cases := map[string]string{
+			"OneStep":         "step=pageview:/pricing",
+			"UnknownStepType": "step=click:/pricing&step=pageview:/signup",
+			"EmptyMatch":      "step=pageview:&step=pageview:/signup",
+			"NegativeWithin":  "step=pageview:/pricing&step=pageview:/signup&within=-1",
+			"TooManySteps":    "step=event:a&step=event:b&step=event:c&step=event:d&step=event:e&step=event:f&step=event:g&step=event:h&step=event:i&step=event:j&step=event:k",
+		}
+
+		for name, query := range cases {
+			t.Run(name, func(t *testing.T) {
+				assert.Equal(t, http.StatusBadRequest, get(query).Code)
+			})
+		}
+	})
+}
+
+func TestFunnelStepPatterns(t *testing.T) {
+	step := analytics.FunnelStep{Type: analytics.StepPageview, Match: "/docs/*/setup*"}
+	assert.True(t, step.Matches("/docs/go/setup"))
+	assert.True(t, step.Matches("/docs/go/js/setup-guide"))
+	assert.False(t, step.Matches("/docs/setup"))
+	assert.False(t, step.Matches("/blog/docs/go/setup"))
+	assert.Equal(t, `/docs/%/setup%`, step.LikePattern())
+
+	literal := analytics.FunnelStep{Type: analytics.StepEvent, Match: `100%_off\`}
+	assert.True(t, literal.Matches(`100%_off\`))
+	assert.False(t, literal.Matches("100x_off"))
+	assert.Equal(t, `100\%\_off\\`, literal.LikePattern(), "LIKE wildcards in a step match themselves")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"Borea/backend/geoip"
+	"Borea/backend/handlers"
+	"Borea/backend/helper"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeGeoIPFixture builds a tiny City database, so the tests never need a real one
+func writeGeoIPFixture(t *testing.T) string {
+	t.Helper()
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-City", RecordSize: 24})
+	require.NoError(t, err)
+
+	entries := map[string]mmdbtype.Map{
+		"81.2.69.0/24": {
+			"country":      mmdbtype.Map{"iso_code": mmdbtype.String("GB")},
+			"subdivisions": mmdbtype.Slice{mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("England")}}},
+			"city":         mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("London")}},
+		},
+		"2a02:ec00::/32": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+		},
+	}
+
+	for cidr, entry := range entries {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, entry))
+	}
+
+	path := filepath.Join(t.TempDir(), "GeoLite2-City-Test.mmdb")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = tree.WriteTo(file)
+	require.NoError(t, err)
+
+	return path
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := helper.ParseTrustedProxies("10.0.0.0/8, 192.168.1.4")
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		"DirectConnection":       {"81.2.69.142:51234", "", "81.2.69.142"},
+		"UntrustedForwardedFor":  {"81.2.69.142:51234", "1.2.3.4", "81.2.69.142"},
+		"TrustedProxy":           {"10.1.2.3:443", "81.2.69.142", "81.2.69.142"},
+		"ChainOfTrustedProxies":  {"192.168.1.4:443", "81.2.69.142, 10.0.0.7", "81.2.69.142"},
+		"SpoofedLeftmostEntry":   {"10.1.2.3:443", "6.6.6.6, 81.2.69.142", "81.2.69.142"},
+		"MalformedEntry":         {"10.1.2.3:443", "81.2.69.142, garbage", "10.1.2.3"},
+		"TrustedProxyWithoutXFF": {"10.1.2.3:443", "", "10.1.2.3"},
+		"IPv6":                   {"[2a02:ec00::1]:443", "", "2a02:ec00::1"},
+		"UntrustedNeighbour":     {"192.168.1.5:443", "81.2.69.142", "192.168.1.5"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/postSession", nil)
+			req.RemoteAddr = c.remoteAddr
+			if c.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", c.forwarded)
+			}
+
+			assert.Equal(t, c.expected, helper.ClientIP(req, trusted).String())
+		})
+	}
+
+	t.Run("InvalidTrustedProxy", func(t *testing.T) {
+		_, err := helper.ParseTrustedProxies("10.0.0.0/8, not-an-ip")
+		assert.Error(t, err)
+	})
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	locator, err := geoip.Open(writeGeoIPFixture(t), nil)
+	require.NoError(t, err)
+	defer locator.Close()
+
+	t.Run("City", func(t *testing.T) {
+		location := locator.Lookup(net.ParseIP("81.2.69.142"))
+		require.NotNil(t, location.Country)
+		require.NotNil(t, location.Region)
+		require.NotNil(t, location.City)
+		assert.Equal(t, "GB", *location.Country)
+		assert.Equal(t, "England", *location.Region)
+		assert.Equal(t, "London", *location.City)
+	})
+
+	t.Run("CountryOnly", func(t *testing.T) {
+		location := locator.Lookup(net.ParseIP("2a02:ec00::1"))
+		require.NotNil(t, location.Country)
+		assert.Equal(t, "DE", *location.Country)
+		assert.Nil(t, location.Region)
+		assert.Nil(t, location.City)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		location := locator.Lookup(net.ParseIP("127.0.0.1"))
+		assert.Nil(t, location.Country)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		var disabled *geoip.Locator
+		assert.Nil(t, disabled.Lookup(net.ParseIP("81.2.69.142")).Country)
+	})
+
+	t.Run("MissingDatabase", func(t *testing.T) {
+		_, err := geoip.Open(filepath.Join(t.TempDir(), "missing.mmdb"), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestSessionLocation(t *testing.T) {
+	trusted, err := helper.ParseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+
+	locator, err := geoip.Open(writeGeoIPFixture(t), trusted)
+	require.NoError(t, err)
+	defer locator.Close()
+
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, locator, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	sessionID := "8d3f1c2e-7a6b-4e5d-9c8b-1a2f3e4d5c6b"
+	body, _ := json.Marshal(map[string]interface{}{
+		"sessionId":        sessionID,
+		"lastActivityTime": "2024-10-01T10:00:00Z",
+		"userAgent":        windowsChrome,
+		"language":         "en-GB",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("X-Forwarded-For", "81.2.69.142")
+	req.RemoteAddr = "10.0.0.2:443"
+	w := httptest.NewRecorder()
+
+	h.PostSessionData(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored, ok := mem.Session(sessionID)
+	require.True(t, ok)
+	require.NotNil(t, stored.Session.Location.Country)
+	assert.Equal(t, "GB", *stored.Session.Location.Country)
+	assert.Equal(t, "London", *stored.Session.Location.City)
+
+	// Nothing kept about the session should contain the address it came from
+	encoded, err := json.Marshal(stored)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "81.2.69.142")
+
+	req = httptest.NewRequest(http.MethodGet, "/analytics/countries?from=2024-10-01&to=2024-10-01", nil)
+	w = httptest.NewRecorder()
+
+	h.GetCountries(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"value": "GB", "count": 1}]`, w.Body.String())
+}
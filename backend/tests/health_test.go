@@ -0,0 +1,179 @@
+package main
+
+import (
+	"Borea/backend/db"
+	"Borea/backend/handlers"
+	"Borea/backend/health"
+	"Borea/backend/ingest"
+	"Borea/backend/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func probe(t *testing.T, handler http.HandlerFunc, path string) (int, models.Health_report) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var report models.Health_report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return w.Code, report
+}
+
+func passing(name string, critical bool) health.Check {
+	return health.Check{Name: name, Critical: critical, Run: func(ctx context.Context) (string, error) {
+		return name + " fine", nil
+	}}
+}
+
+func failing(name string, critical bool) health.Check {
+	return health.Check{Name: name, Critical: critical, Run: func(ctx context.Context) (string, error) {
+		return "", errors.New(name + " broken")
+	}}
+}
+
+func TestHealth(t *testing.T) {
+	config := health.DefaultConfig()
+
+	t.Run("Liveness", func(t *testing.T) {
+		code, report := probe(t, handlers.LivenessHandler, "/livez")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusOK, report.Status)
+		assert.Empty(t, report.Checks)
+	})
+
+	t.Run("Ready", func(t *testing.T) {
+		checker := health.NewChecker(config, passing("database", true), passing("geoip", false))
+
+		code, report := probe(t, handlers.ReadinessHandler(checker), "/readyz")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusOK, report.Status)
+		require.Len(t, report.Checks, 2)
+		assert.Equal(t, "database", report.Checks[0].Name)
+		assert.Equal(t, "database fine", *report.Checks[0].Detail)
+		assert.True(t, report.Checks[0].Critical)
+		assert.Nil(t, report.Checks[0].Error)
+	})
+
+	t.Run("Degraded", func(t *testing.T) {
+		checker := health.NewChecker(config, passing("database", true), failing("geoip", false))
+
+		code, report := probe(t, handlers.ReadinessHandler(checker), "/readyz")
+		assert.Equal(t, http.StatusOK, code, "The backend can still write")
+		assert.Equal(t, health.StatusDegraded, report.Status)
+		assert.Equal(t, health.StatusFail, report.Checks[1].Status)
+		assert.Equal(t, "geoip broken", *report.Checks[1].Error)
+	})
+
+	t.Run("NotReady", func(t *testing.T) {
+		checker := health.NewChecker(config, failing("database", true), failing("geoip", false))
+
+		code, report := probe(t, handlers.ReadinessHandler(checker), "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, health.StatusFail, report.Status)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		hung := health.Check{Name: "database", Critical: true, Run: func(ctx context.Context) (string, error) {
+			time.Sleep(time.Second) // Ignores ctx like a stuck driver would
+			return "", nil
+		}}
+		checker := health.NewChecker(health.Config{Timeout: 50 * time.Millisecond}, hung, passing("geoip", false))
+
+		start := time.Now()
+		code, report := probe(t, handlers.ReadinessHandler(checker), "/readyz")
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, "timed out after 50ms", *report.Checks[0].Error)
+		assert.Equal(t, health.StatusOK, report.Checks[1].Status)
+	})
+
+	t.Run("QueueSaturation", func(t *testing.T) {
+		queue := ingest.NewQueue(ingest.Config{BufferSize: 10, Workers: 1}, func(batch []models.Site_session) error {
+			return nil
+		})
+		check := health.Queue(queue, 90)
+
+		detail, err := check.Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "0 of 10 sessions buffered, fullest worker holds 0 of 10", detail)
+
+		// Not started, so nothing is flushed
+		for i := 0; i < 9; i++ {
+			require.NoError(t, queue.Enqueue(models.Site_session{SiteID: 1, Session: models.Session{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-111111111111"}}))
+		}
+		_, err = check.Run(context.Background())
+		assert.EqualError(t, err, "9 of 10 sessions buffered, fullest worker holds 9 of 10, over 90%")
+		assert.True(t, check.Critical)
+	})
+
+	t.Run("QueueSingleFullShard", func(t *testing.T) {
+		queue := ingest.NewQueue(ingest.Config{BufferSize: 40, Workers: 4}, func(batch []models.Site_session) error {
+			return nil
+		})
+		check := health.Queue(queue, 90)
+
+		// One session ID always hashes to the same worker, its share fills while the rest stay empty
+		session := models.Site_session{SiteID: 1, Session: models.Session{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-222222222222"}}
+		for i := 0; i < 10; i++ {
+			require.NoError(t, queue.Enqueue(session))
+		}
+		require.ErrorIs(t, queue.Enqueue(session), ingest.ErrQueueFull)
+
+		_, err := check.Run(context.Background())
+		assert.EqualError(t, err, "10 of 40 sessions buffered, fullest worker holds 10 of 10, over 90%", "A quarter of the buffer is in use, but this session's beacons get 503")
+	})
+
+	t.Run("GeoIP", func(t *testing.T) {
+		detail, err := health.GeoIP(nil, false).Run(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "disabled", detail)
+
+		_, err = health.GeoIP(nil, true).Run(context.Background())
+		assert.Error(t, err)
+	})
+
+	t.Run("Settings", func(t *testing.T) {
+		t.Setenv("SERVER_KEY", "")
+		_, err := health.Settings().Run(context.Background())
+		assert.Error(t, err)
+
+		t.Setenv("SERVER_KEY", "0b1c6c9e-6f5e-4f3a-8c7e-111111111111")
+		_, err = health.Settings().Run(context.Background())
+		assert.NoError(t, err)
+	})
+
+	t.Run("ConfigFromEnv", func(t *testing.T) {
+		t.Setenv("HEALTH_TIMEOUT_MS", "500")
+		loaded, err := health.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, 500*time.Millisecond, loaded.Timeout)
+		assert.Equal(t, 90, loaded.QueueThreshold)
+
+		t.Setenv("HEALTH_QUEUE_THRESHOLD_PERCENT", "101")
+		_, err = health.ConfigFromEnv()
+		assert.Error(t, err)
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		skipWithoutPostgres(t)
+
+		require.NoError(t, db.InitDB(), "Database initialization error")
+		defer db.DB.Close()
+		require.NoError(t, SetUpTestSchema())
+
+		checker := health.NewChecker(config, health.Database(db.DB), health.Migrations(db.DB))
+		code, report := probe(t, handlers.ReadinessHandler(checker), "/readyz")
+		assert.Equal(t, http.StatusOK, code, report)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/ingest"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingFlush collects flushed sessions in place of the database
+type recordingFlush struct {
+	mu       sync.Mutex
+	sessions []models.Site_session
+	batches  int
+}
+
+func (f *recordingFlush) flush(batch []models.Site_session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sessions = append(f.sessions, batch...)
+	f.batches++
+	return nil
+}
+
+func TestIngestQueue(t *testing.T) {
+	t.Run("FullBufferIsReported", func(t *testing.T) {
+		recorder := &recordingFlush{}
+		queue := ingest.NewQueue(ingest.Config{BufferSize: 2, Workers: 1, BatchSize: 10, FlushInterval: time.Hour}, recorder.flush)
+
+		// Not started, so nothing drains the buffer
+		require.NoError(t, queue.Enqueue(models.Site_session{Session: models.Session{SessionID: "a"}}))
+		require.NoError(t, queue.Enqueue(models.Site_session{Session: models.Session{SessionID: "b"}}))
+		assert.ErrorIs(t, queue.Enqueue(models.Site_session{Session: models.Session{SessionID: "c"}}), ingest.ErrQueueFull)
+		assert.Equal(t, 2, queue.Len())
+
+		require.NoError(t, queue.Close(context.Background()))
+		assert.Len(t, recorder.sessions, 2, "Close should flush the buffered sessions")
+	})
+
+	t.Run("ClosedQueueRejects", func(t *testing.T) {
+		queue := ingest.NewQueue(ingest.DefaultConfig(), (&recordingFlush{}).flush)
+		queue.Start()
+		require.NoError(t, queue.Close(context.Background()))
+
+		assert.ErrorIs(t, queue.Enqueue(models.Site_session{Session: models.Session{SessionID: "a"}}), ingest.ErrQueueClosed)
+	})
+
+	t.Run("FlushesFullBatches", func(t *testing.T) {
+		recorder := &recordingFlush{}
+		queue := ingest.NewQueue(ingest.Config{BufferSize: 100, Workers: 1, BatchSize: 5, FlushInterval: time.Hour}, recorder.flush)
+		queue.Start()
+
+		for i := int64(0); i < 10; i++ {
+			duration := i
+			require.NoError(t, queue.Enqueue(models.Site_session{Session: models.Session{SessionID: "a", SessionDuration: &duration}}))
+		}
+
+		require.NoError(t, queue.Close(context.Background()))
+		assert.Equal(t, 2, recorder.batches)
+
+		// Updates to one session stay in the order they were received
+		for i, session := range recorder.sessions {
+			assert.Equal(t, int64(i), *session.Session.SessionDuration)
+		}
+	})
+
+	t.Run("FlushesOnInterval", func(t *testing.T) {
+		recorder := &recordingFlush{}
+		queue := ingest.NewQueue(ingest.Config{BufferSize: 100, Workers: 2, BatchSize: 100, FlushInterval: 10 * time.Millisecond}, recorder.flush)
+		queue.Start()
+		defer queue.Close(context.Background())
+
+		require.NoError(t, queue.Enqueue(models.Site_session{Session: models.Session{SessionID: "a"}}))
+
+		assert.Eventually(t, func() bool {
+			recorder.mu.Lock()
+			defer recorder.mu.Unlock()
+			return len(recorder.sessions) == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("ConfigFromEnv", func(t *testing.T) {
+		t.Setenv("INGEST_BUFFER_SIZE", "50")
+		t.Setenv("INGEST_FLUSH_INTERVAL_MS", "250")
+
+		config, err := ingest.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, 50, config.BufferSize)
+		assert.Equal(t, 250*time.Millisecond, config.FlushInterval)
+		assert.Equal(t, ingest.DefaultConfig().Workers, config.Workers)
+
+		t.Setenv("INGEST_WORKERS", "zero")
+		_, err = ingest.ConfigFromEnv()
+		assert.Error(t, err)
+	})
+}
+
+func TestPostSessionDataQueued(t *testing.T) {
+	mem := store.NewMemory()
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	// Not started until the end, so the test controls when the buffer is flushed
+	queue := ingest.NewQueue(ingest.Config{BufferSize: 3, Workers: 1, BatchSize: 10, FlushInterval: time.Hour}, func(batch []models.Site_session) error {
+		return mem.UpsertSessions(context.Background(), batch)
+	})
+	h := handlers.New(mem, queue, nil, nil)
+
+	post := func(sessionData map[string]interface{}) int {
+		body, _ := json.Marshal(sessionData)
+		req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+		return w.Code
+	}
+
+	session := func(id string, duration interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"sessionId":        id,
+			"lastActivityTime": time.Now().Format(time.RFC3339),
+			"sessionDuration":  duration,
+			"startTime":        time.Now().Format(time.RFC3339),
+			"language":         "en",
+		}
+	}
+
+	first := "1d7c5a52-0d9e-4a8e-9a43-6f2c0c8b1a01"
+	second := "1d7c5a52-0d9e-4a8e-9a43-6f2c0c8b1a02"
+	third := "1d7c5a52-0d9e-4a8e-9a43-6f2c0c8b1a03"
+
+	assert.Equal(t, http.StatusAccepted, post(session(first, 1000)))
+	assert.Equal(t, http.StatusAccepted, post(session(first, 2000)))
+	assert.Equal(t, http.StatusAccepted, post(session(second, 3000)))
+	assert.Equal(t, http.StatusServiceUnavailable, post(session(third, 4000)), "A full buffer should shed load")
+	assert.Equal(t, http.StatusBadRequest, post(session(third, "not a number")), "Invalid sessions should be rejected before they are queued")
+
+	queue.Start()
+	require.NoError(t, queue.Close(context.Background()))
+
+	stored, ok := mem.Session(first)
+	require.True(t, ok)
+	assert.Equal(t, int64(2000), *stored.Session.SessionDuration, "Updates in one batch should be merged into one session")
+
+	_, ok = mem.Session(second)
+	assert.True(t, ok)
+
+	_, ok = mem.Session(third)
+	assert.False(t, ok)
+
+	assert.Equal(t, http.StatusServiceUnavailable, post(session(third, 3000)), "A closed queue should shed load")
+}
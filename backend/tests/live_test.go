@@ -0,0 +1,177 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/live"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveHub(t *testing.T) {
+	hub := live.NewHub(live.Config{Window: 5 * time.Minute, Interval: time.Second})
+	start := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+
+	siteOne := hub.Subscribe(1)
+	allSites := hub.Subscribe(0)
+
+	duration := int64(1000)
+	hub.PageView(1, models.Page_view{SessionID: "a", Path: "/"}, start)
+	hub.Session(1, models.Session{SessionID: "a"}, start)
+	hub.Session(1, models.Session{SessionID: "a"}, start.Add(time.Minute))
+	hub.PageView(1, models.Page_view{SessionID: "a", Path: "/", Duration: &duration}, start.Add(time.Minute))
+	hub.Event(1, models.Event{SessionID: "a", Name: "signup"}, start.Add(time.Minute))
+	hub.Session(1, models.Session{SessionID: "bot", IsBot: true}, start)
+	hub.Event(1, models.Event{SessionID: "bot", Name: "signup"}, start)
+	hub.Session(2, models.Session{SessionID: "b"}, start.Add(2*time.Minute))
+
+	received := func(sub *live.Subscription) []string {
+		types := make([]string, 0)
+		for {
+			select {
+			case item := <-sub.Items:
+				types = append(types, item.Type+":"+item.SessionID)
+			default:
+				return types
+			}
+		}
+	}
+
+	assert.Equal(t, []string{"pageview:a", "session:a", "event:a"}, received(siteOne), "A session is announced once and a page once, bots not at all")
+	assert.Equal(t, []string{"pageview:a", "session:a", "event:a", "session:b"}, received(allSites))
+
+	assert.Equal(t, 1, hub.Active(1, start.Add(2*time.Minute)), "Bots are not counted")
+	assert.Equal(t, 2, hub.Active(0, start.Add(2*time.Minute)))
+	assert.Equal(t, 1, hub.Active(0, start.Add(6*time.Minute+30*time.Second)), "Quiet sessions drop out after the window")
+	assert.Equal(t, 0, hub.Active(0, start.Add(8*time.Minute)))
+
+	t.Run("ReturningSessionIsAnnouncedAgain", func(t *testing.T) {
+		hub.Session(1, models.Session{SessionID: "a"}, start.Add(time.Hour))
+		assert.Equal(t, []string{"session:a"}, received(siteOne))
+	})
+
+	t.Run("SlowSubscriberDoesNotBlock", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			hub.Event(1, models.Event{SessionID: "a", Name: "scroll"}, start.Add(time.Hour))
+		}
+		assert.NotEmpty(t, received(siteOne))
+	})
+
+	t.Run("CloseEndsSubscriptions", func(t *testing.T) {
+		hub.Close()
+
+		_, ok := <-hub.Subscribe(1).Items
+		assert.False(t, ok)
+		for range allSites.Items {
+			// Items buffered before Close are still delivered
+		}
+	})
+}
+
+func TestGetLive(t *testing.T) {
+	mem := store.NewMemory()
+	hub := live.NewHub(live.Config{Window: time.Minute, Interval: time.Hour})
+	h := handlers.New(mem, nil, nil, hub)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	server := httptest.NewServer(http.HandlerFunc(h.GetLive))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?site="+strconv.Itoa(site.ID), nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	next := func() (string, string) {
+		var event, data string
+		for {
+			line, err := reader.ReadString('\n')
+			require.NoError(t, err)
+			line = strings.TrimRight(line, "\n")
+
+			switch {
+			case line == "":
+				return event, data
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+
+	event, data := next()
+	assert.Equal(t, "active", event)
+	assert.JSONEq(t, `{"site": `+strconv.Itoa(site.ID)+`, "active": 0}`, data)
+
+	post := func(handler http.HandlerFunc, route string, body map[string]interface{}) {
+		payload, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, route+"?token="+site.TrackingToken, bytes.NewBuffer(payload))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	sessionID := "9e8d7c6b-5a49-4382-9716-a5b4c3d2e1f0"
+	post(h.PostSessionData, "/postSession", map[string]interface{}{
+		"sessionId": sessionID, "lastActivityTime": "2024-10-01T10:00:00Z", "userAgent": windowsChrome, "language": "en",
+	})
+	post(h.PostEvent, "/event", map[string]interface{}{"sessionId": sessionID, "name": "signup"})
+
+	event, data = next()
+	assert.Equal(t, "session", event)
+	var item models.Live_item
+	require.NoError(t, json.Unmarshal([]byte(data), &item))
+	assert.Equal(t, sessionID, item.SessionID)
+	require.NotNil(t, item.Browser)
+	assert.Equal(t, "Chrome", *item.Browser)
+
+	event, data = next()
+	assert.Equal(t, "event", event)
+	require.NoError(t, json.Unmarshal([]byte(data), &item))
+	assert.Equal(t, "signup", item.Name)
+
+	assert.Equal(t, 1, hub.Active(site.ID, time.Now()))
+
+	t.Run("InvalidSite", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/live?site=abc", nil)
+		w := httptest.NewRecorder()
+
+		h.GetLive(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("WithoutHub", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/live", nil)
+		w := httptest.NewRecorder()
+
+		handlers.New(mem, nil, nil, nil).GetLive(w, req)
+
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/logging"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs makes the default logger write JSON lines at the given level into the returned buffer
+func captureLogs(t *testing.T, level slog.Level) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(logging.Config{Level: level, Format: logging.FormatJSON}, &buf))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestLogging(t *testing.T) {
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		logging.With(r.Context(), "site", 7)
+		logging.FromContext(r.Context()).Error("writing session", "err", errors.New("connection refused"))
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/livez", handlers.LivenessHandler)
+	server := logging.Middleware(mux)
+
+	serve := func(path, requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if requestID != "" {
+			req.Header.Set(logging.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("AssignsRequestID", func(t *testing.T) {
+		buf := captureLogs(t, slog.LevelInfo)
+
+		w := serve("/ok", "")
+		id := w.Header().Get(logging.RequestIDHeader)
+		assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), id)
+		assert.NotEqual(t, id, serve("/ok", "").Header().Get(logging.RequestIDHeader), "Every request gets its own ID")
+
+		lines := logLines(t, buf)
+		require.Len(t, lines, 2)
+		line := lines[0]
+		assert.Equal(t, "INFO", line["level"])
+		assert.Equal(t, "request", line["msg"])
+		assert.Equal(t, id, line["request_id"])
+		assert.Equal(t, "GET", line["method"])
+		assert.Equal(t, "/ok", line["handler"])
+		assert.Equal(t, float64(http.StatusOK), line["status"])
+		assert.Contains(t, line, "latency_ms")
+	})
+
+	t.Run("PropagatesRequestID", func(t *testing.T) {
+		buf := captureLogs(t, slog.LevelInfo)
+
+		w := serve("/ok", "lb-5f2c9a:1")
+		assert.Equal(t, "lb-5f2c9a:1", w.Header().Get(logging.RequestIDHeader))
+		assert.Equal(t, "lb-5f2c9a:1", logLines(t, buf)[0]["request_id"])
+
+		for _, invalid := range []string{"two words", strings.Repeat("a", 129), "line\nbreak"} {
+			w := serve("/ok", invalid)
+			assert.Len(t, w.Header().Get(logging.RequestIDHeader), 32, "%q is replaced", invalid)
+		}
+	})
+
+	t.Run("CorrelatesErrors", func(t *testing.T) {
+		buf := captureLogs(t, slog.LevelInfo)
+
+		w := serve("/fail", "beacon-1")
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		lines := logLines(t, buf)
+		require.Len(t, lines, 2)
+		assert.Equal(t, "writing session", lines[0]["msg"])
+		assert.Equal(t, "connection refused", lines[0]["err"])
+		assert.Equal(t, "ERROR", lines[1]["level"], "The request line of a 5xx is an error")
+		for _, line := range lines {
+			assert.Equal(t, "beacon-1", line["request_id"])
+			assert.Equal(t, float64(7), line["site"])
+		}
+	})
+
+	t.Run("Levels", func(t *testing.T) {
+		buf := captureLogs(t, slog.LevelInfo)
+
+		serve("/livez", "")
+		serve("/missing", "")
+
+		lines := logLines(t, buf)
+		require.Len(t, lines, 1, "Probes only show at debug level")
+		assert.Equal(t, "WARN", lines[0]["level"])
+		assert.Equal(t, float64(http.StatusNotFound), lines[0]["status"])
+		assert.Equal(t, "", lines[0]["handler"])
+
+		buf = captureLogs(t, slog.LevelDebug)
+		serve("/livez", "")
+		assert.Equal(t, "DEBUG", logLines(t, buf)[0]["level"])
+	})
+
+	t.Run("TrackingSite", func(t *testing.T) {
+		buf := captureLogs(t, slog.LevelInfo)
+
+		mem := store.NewMemory()
+		site, err := sites.Create(ctx, mem, "example", []string{"http://example.com"})
+		require.NoError(t, err, "Failed to create site")
+
+		tracking := http.NewServeMux()
+		tracking.HandleFunc("/event", handlers.New(mem, nil, nil, nil).PostEvent)
+
+		req := httptest.NewRequest(http.MethodPost, "/event?token="+site.TrackingToken, bytes.NewBufferString(`{"name":`))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+		logging.Middleware(tracking).ServeHTTP(w, req)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		lines := logLines(t, buf)
+		require.Len(t, lines, 1)
+		assert.Equal(t, float64(site.ID), lines[0]["site"])
+		assert.Equal(t, "/event", lines[0]["handler"])
+	})
+
+	t.Run("OutsideRequests", func(t *testing.T) {
+		assert.Equal(t, slog.Default(), logging.FromContext(ctx))
+		assert.Equal(t, "", logging.RequestID(ctx))
+		logging.With(ctx, "site", 1) // Nothing to add the field to, and no panic
+
+		logger := slog.Default().With("component", "rollup")
+		assert.Equal(t, logger, logging.FromContext(logging.NewContext(ctx, logger)))
+	})
+
+	t.Run("ConfigFromEnv", func(t *testing.T) {
+		config, err := logging.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, logging.DefaultConfig(), config)
+
+		t.Setenv("LOG_LEVEL", "WARN")
+		t.Setenv("LOG_FORMAT", "text")
+		config, err = logging.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, slog.LevelWarn, config.Level)
+		assert.Equal(t, logging.FormatText, config.Format)
+
+		t.Setenv("LOG_LEVEL", "verbose")
+		_, err = logging.ConfigFromEnv()
+		assert.Error(t, err)
+
+		t.Setenv("LOG_LEVEL", "debug")
+		t.Setenv("LOG_FORMAT", "xml")
+		_, err = logging.ConfigFromEnv()
+		assert.Error(t, err)
+	})
+}
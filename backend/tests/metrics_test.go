@@ -0,0 +1,138 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/ingest"
+	"Borea/backend/metrics"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metricValue scrapes the metrics endpoint for one series, e.g. `borea_rejected_requests_total{reason="bad_json"}`.
+// Series that were never touched read as 0.
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			require.NoError(t, err)
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Requests", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metricsTest", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		})
+		mux.HandleFunc("/metricsTest/stream", func(w http.ResponseWriter, r *http.Request) {
+			flusher, ok := w.(http.Flusher)
+			require.True(t, ok, "Streaming handlers can still flush")
+			w.Write([]byte("data: 1\n\n"))
+			flusher.Flush()
+		})
+		server := metrics.Instrument(mux)
+
+		created := `borea_http_requests_total{code="201",handler="/metricsTest"}`
+		streamed := `borea_http_requests_total{code="200",handler="/metricsTest/stream"}`
+		notFound := `borea_http_requests_total{code="404",handler="unmatched"}`
+		before := []float64{metricValue(t, created), metricValue(t, streamed), metricValue(t, notFound)}
+
+		for _, path := range []string{"/metricsTest", "/metricsTest", "/metricsTest/stream", "/no/such/route?x=1"} {
+			server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+		}
+
+		assert.Equal(t, before[0]+2, metricValue(t, created))
+		assert.Equal(t, before[1]+1, metricValue(t, streamed))
+		assert.Equal(t, before[2]+1, metricValue(t, notFound), "Unknown paths share one label")
+		assert.Positive(t, metricValue(t, `borea_http_request_duration_seconds_count{code="201",handler="/metricsTest"}`))
+	})
+
+	t.Run("Rejections", func(t *testing.T) {
+		mem := store.NewMemory()
+		h := handlers.New(mem, nil, nil, nil)
+		site, err := sites.Create(ctx, mem, "example", []string{"http://example.com"})
+		require.NoError(t, err, "Failed to create site")
+
+		post := func(token, origin, body string) {
+			req := httptest.NewRequest(http.MethodPost, "/event?token="+token, bytes.NewBufferString(body))
+			req.Header.Set("Origin", origin)
+			h.PostEvent(httptest.NewRecorder(), req)
+		}
+
+		reasons := []string{metrics.ReasonBadToken, metrics.ReasonBadDomain, metrics.ReasonBadJSON, metrics.ReasonInvalid}
+		before := map[string]float64{}
+		for _, reason := range reasons {
+			before[reason] = metricValue(t, `borea_rejected_requests_total{reason="`+reason+`"}`)
+		}
+
+		post("unknown", "http://example.com", `{}`)
+		post(site.TrackingToken, "http://unknown.example.com", `{}`)
+		post(site.TrackingToken, "http://example.com", `{"name":`)
+		post(site.TrackingToken, "http://example.com", `{"sessionId": "not-a-uuid", "name": "signup"}`)
+
+		for _, reason := range reasons {
+			assert.Equal(t, before[reason]+1, metricValue(t, `borea_rejected_requests_total{reason="`+reason+`"}`), reason)
+		}
+	})
+
+	t.Run("SessionsIngested", func(t *testing.T) {
+		mem := store.NewMemory()
+		queue := ingest.NewQueue(ingest.DefaultConfig(), func(batch []models.Site_session) error {
+			return mem.UpsertSessions(ctx, batch)
+		})
+		metrics.WatchQueue(queue.Len)
+		h := handlers.New(mem, queue, nil, nil)
+
+		first, err := sites.Create(ctx, mem, "first", []string{"http://first.example.com"})
+		require.NoError(t, err)
+		second, err := sites.Create(ctx, mem, "second", []string{"http://second.example.com"})
+		require.NoError(t, err)
+
+		post := func(site models.Site, origin string) {
+			req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBufferString(`{"sessionId": "0b1c6c9e-6f5e-4f3a-8c7e-111111111111"}`))
+			req.Header.Set("Origin", origin)
+			w := httptest.NewRecorder()
+			h.PostSessionData(w, req)
+			require.Equal(t, http.StatusAccepted, w.Code)
+		}
+
+		firstSeries := `borea_sessions_ingested_total{site="` + strconv.Itoa(first.ID) + `"}`
+		secondSeries := `borea_sessions_ingested_total{site="` + strconv.Itoa(second.ID) + `"}`
+
+		// Other tests post sessions for the same site ids
+		beforeFirst, beforeSecond := metricValue(t, firstSeries), metricValue(t, secondSeries)
+
+		post(first, "http://first.example.com")
+		post(first, "http://first.example.com")
+		post(second, "http://second.example.com")
+
+		assert.Equal(t, beforeFirst+2, metricValue(t, firstSeries))
+		assert.Equal(t, beforeSecond+1, metricValue(t, secondSeries))
+		assert.Equal(t, float64(3), metricValue(t, "borea_ingest_queue_depth"), "Nothing was flushed yet")
+
+		require.NoError(t, queue.Close(ctx))
+		assert.Equal(t, float64(0), metricValue(t, "borea_ingest_queue_depth"))
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"Borea/backend/commands"
+	"Borea/backend/db"
+	"Borea/backend/store"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// skipWithoutPostgres skips tests that need a live database when none is configured,
+// handler tests run against the in-memory store instead
+func skipWithoutPostgres(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv("PG_HOST") == "" {
+		t.Skip("PG_HOST not set, skipping Postgres test")
+	}
+}
+
+// SetUpTestSchema gives each test a fresh database at the latest schema version
+func SetUpTestSchema() error {
+	if err := TearDownTestSchema(); err != nil {
+		return err
+	}
+
+	if _, err := db.MigrateUp(); err != nil {
+		log.Printf("Error migrating test schema: %v", err)
+		return err
+	}
+
+	log.Println("test schema migrated successfully")
+	return nil
+}
+
+func TearDownTestSchema() error {
+	migrations, err := db.Migrations()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.MigrateDown(len(migrations)); err != nil {
+		log.Printf("Error reverting test schema: %v", err)
+		return err
+	}
+
+	log.Println("test schema reverted successfully")
+	return nil
+}
+
+func TestMigrations(t *testing.T) {
+	skipWithoutPostgres(t)
+
+	err := db.InitDB()
+	require.NoError(t, err, "Database initialization error")
+	defer db.DB.Close()
+
+	err = SetUpTestSchema()
+	require.NoError(t, err, "Failed to migrate test schema")
+	defer TearDownTestSchema()
+
+	latest, err := db.LatestVersion()
+	require.NoError(t, err)
+
+	t.Run("UpReachesLatest", func(t *testing.T) {
+		current, err := db.CurrentVersion()
+		require.NoError(t, err)
+		assert.Equal(t, latest, current)
+		assert.NoError(t, db.CheckVersion())
+
+		applied, err := db.MigrateUp()
+		require.NoError(t, err)
+		assert.Equal(t, 0, applied, "Migrating an up to date schema should be a no-op")
+	})
+
+	t.Run("DownAndUpAgain", func(t *testing.T) {
+		reverted, err := db.MigrateDown(1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, reverted)
+
+		current, err := db.CurrentVersion()
+		require.NoError(t, err)
+		assert.Equal(t, latest-1, current)
+
+		applied, err := db.MigrateUp()
+		require.NoError(t, err)
+		assert.Equal(t, 1, applied)
+	})
+
+	t.Run("SchemaNewerThanBinary", func(t *testing.T) {
+		_, err := db.DB.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, 'from_the_future')", latest+1)
+		require.NoError(t, err)
+		defer db.DB.Exec("DELETE FROM schema_migrations WHERE version = $1", latest+1)
+
+		assert.Error(t, db.CheckVersion())
+
+		_, err = db.MigrateUp()
+		assert.Error(t, err)
+
+		pg := store.NewPostgres(db.DB)
+		for _, args := range [][]string{
+			{"roll-up", "-days", "1"},
+			{"create-site", "-name", "blog", "-origins", "https://blog.example.com"},
+			{"backfill-user-agents"},
+		} {
+			assert.Error(t, commands.Run(pg, args), "An older binary must not touch a newer schema: %s", args[0])
+		}
+		assert.NoError(t, commands.Run(pg, []string{"migrate", "status"}), "migrate is how a newer schema is rolled back")
+	})
+
+	t.Run("DownAll", func(t *testing.T) {
+		require.NoError(t, TearDownTestSchema())
+
+		current, err := db.CurrentVersion()
+		require.NoError(t, err)
+		assert.Equal(t, 0, current)
+
+		var sessions bool
+		err = db.DB.QueryRow("SELECT to_regclass('sessions') IS NOT NULL").Scan(&sessions)
+		require.NoError(t, err)
+		assert.False(t, sessions)
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostPageView(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	sessionID := "0f1e2d3c-4b5a-4968-8776-655443322110"
+	viewID := "1a2b3c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+
+	post := func(view map[string]interface{}) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(view)
+		req := httptest.NewRequest(http.MethodPost, "/pageview?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostPageView(w, req)
+		return w
+	}
+
+	t.Run("EnterAndLeave", func(t *testing.T) {
+		w := post(map[string]interface{}{
+			"viewId":    viewID,
+			"sessionId": sessionID,
+			"path":      "/pricing",
+			"title":     "",
+			"query":     "?plan=team",
+			"timestamp": "2024-10-01T10:00:00Z",
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = post(map[string]interface{}{
+			"viewId":    viewID,
+			"sessionId": sessionID,
+			"path":      "/pricing",
+			"title":     "Pricing",
+			"query":     "plan=team",
+			"timestamp": "2024-10-01T10:00:00Z",
+			"duration":  42000,
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		views := mem.PageViews(site.ID)
+		require.Len(t, views, 1, "The view is updated, not stored twice")
+		require.NotNil(t, views[0].Title)
+		require.NotNil(t, views[0].Query)
+		require.NotNil(t, views[0].Duration)
+		assert.Equal(t, "Pricing", *views[0].Title, "An empty title is filled in later")
+		assert.Equal(t, "plan=team", *views[0].Query)
+		assert.Equal(t, int64(42000), *views[0].Duration)
+	})
+
+	t.Run("ViewOfAnotherSession", func(t *testing.T) {
+		w := post(map[string]interface{}{
+			"viewId":    viewID,
+			"sessionId": "0f1e2d3c-4b5a-4968-8776-655443322111",
+			"path":      "/pricing",
+		})
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("InvalidViews", func(t *testing.T) {
+		cases := map[string]map[string]interface{}{
+			"MissingViewID":    {"sessionId": sessionID, "path": "/"},
+			"InvalidSessionID": {"viewId": viewID, "sessionId": "abc", "path": "/"},
+			"RelativePath":     {"viewId": viewID, "sessionId": sessionID, "path": "pricing"},
+			"NegativeDuration": {"viewId": viewID, "sessionId": sessionID, "path": "/", "duration": -1},
+		}
+
+		for name, view := range cases {
+			t.Run(name, func(t *testing.T) {
+				assert.Equal(t, http.StatusBadRequest, post(view).Code)
+			})
+		}
+	})
+
+	t.Run("ForbiddenOrigin", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{"viewId": viewID, "sessionId": sessionID, "path": "/"})
+		req := httptest.NewRequest(http.MethodPost, "/pageview?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://evil.com")
+		w := httptest.NewRecorder()
+
+		h.PostPageView(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestPageAnalytics(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	// What Borea.js sends over a visit: the session, and each page on entry and again when left
+	sessionID := "5d4c3b2a-1f0e-4d9c-8b7a-695847362514"
+	pageview := func(viewID, path, timestamp string, duration interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "pageview", "data": map[string]interface{}{
+			"viewId": viewID, "sessionId": sessionID, "path": path, "timestamp": timestamp, "duration": duration,
+		}}
+	}
+	items := []map[string]interface{}{
+		pageview("7c6b5a49-3827-4165-9483-726150493821", "/", "2024-10-01T10:00:00Z", nil),
+		pageview("7c6b5a49-3827-4165-9483-726150493821", "/", "2024-10-01T10:00:00Z", 20000),
+		pageview("7c6b5a49-3827-4165-9483-726150493822", "/docs", "2024-10-01T10:00:20Z", nil),
+		pageview("7c6b5a49-3827-4165-9483-726150493822", "/docs", "2024-10-01T10:00:20Z", 60000),
+		pageview("7c6b5a49-3827-4165-9483-726150493823", "/", "2024-10-01T10:01:20Z", nil),
+		{"type": "pageview", "data": map[string]interface{}{"viewId": "7c6b5a49-3827-4165-9483-726150493824", "sessionId": sessionID, "path": "docs"}},
+		{"type": "session", "data": map[string]interface{}{
+			"sessionId": sessionID, "lastActivityTime": "2024-10-01T10:01:30Z", "userAgent": windowsChrome, "language": "en",
+		}},
+	}
+	body, _ := json.Marshal(items)
+	req := httptest.NewRequest(http.MethodPost, "/batch?token="+site.TrackingToken, bytes.NewBuffer(body))
+	req.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+
+	h.PostBatch(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var results []models.Batch_result
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	for i, result := range results {
+		if i == 5 {
+			assert.Equal(t, "path must start with /", result.Error)
+			continue
+		}
+		assert.True(t, result.Success, "item %d: %s", i, result.Error)
+	}
+
+	get := func(handler http.HandlerFunc, route string) string {
+		req := httptest.NewRequest(http.MethodGet, route+"?from=2024-10-01&to=2024-10-01", nil)
+		w := httptest.NewRecorder()
+
+		handler(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	assert.JSONEq(t, `[
+		{"path": "/", "views": 2, "sessions": 1, "averageDuration": 20000},
+		{"path": "/docs", "views": 1, "sessions": 1, "averageDuration": 60000}
+	]`, get(h.GetTopPages, "/analytics/pages"))
+	assert.JSONEq(t, `[{"value": "/", "count": 1}]`, get(h.GetEntryPages, "/analytics/entry-pages"))
+	assert.JSONEq(t, `[{"value": "/", "count": 1}]`, get(h.GetExitPages, "/analytics/exit-pages"))
+}
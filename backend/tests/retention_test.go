@@ -0,0 +1,71 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRetention(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(ctx, mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	userID := "7a6b5c4d-3e2f-4a1b-9c8d-7e6f5a4b3c2d"
+	for _, s := range []struct{ sessionID, start string }{
+		{"7a6b5c4d-0000-4a1b-9c8d-000000000001", "2024-10-01T10:00:00Z"},
+		{"7a6b5c4d-0000-4a1b-9c8d-000000000002", "2024-10-08T10:00:00Z"},
+		{"7a6b5c4d-0000-4a1b-9c8d-000000000003", "2024-11-04T10:00:00Z"},
+	} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"sessionId":        s.sessionID,
+			"userId":           userID,
+			"startTime":        s.start,
+			"lastActivityTime": s.start,
+			"userAgent":        windowsChrome,
+			"language":         "en",
+		})
+		req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	user, err := mem.UniqueUser(ctx, site.ID, userID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, user.SessionCount)
+
+	get := func(query string) string {
+		req := httptest.NewRequest(http.MethodGet, "/analytics/retention?"+query, nil)
+		w := httptest.NewRecorder()
+
+		h.GetRetention(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w.Body.String()
+	}
+
+	assert.JSONEq(t, `[
+		{"cohort": "2024-09-30", "users": 1, "active": [1, 1], "retention": [1, 1]},
+		{"cohort": "2024-10-07", "users": 0, "active": [0], "retention": [0]}
+	]`, get("from=2024-10-01&to=2024-10-08"), "Cohorts are weekly by default")
+
+	assert.JSONEq(t, `[
+		{"cohort": "2024-10-01", "users": 1, "active": [1, 1], "retention": [1, 1]},
+		{"cohort": "2024-11-01", "users": 0, "active": [0], "retention": [0]}
+	]`, get("from=2024-10-01&to=2024-11-30&granularity=month"))
+}
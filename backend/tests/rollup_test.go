@@ -0,0 +1,132 @@
+package main
+
+import (
+	"Borea/backend/analytics"
+	"Borea/backend/models"
+	"Borea/backend/rollup"
+	"Borea/backend/store"
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// partitionedMemory stands in for a store with session partitions and records what the job asks of it
+type partitionedMemory struct {
+	*store.Memory
+
+	mu      sync.Mutex
+	ensured []string
+	dropped []time.Time
+}
+
+func (m *partitionedMemory) EnsurePartitions(ctx context.Context, now time.Time, ahead int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ensured = append(m.ensured, fmt.Sprintf("%s+%d", now.Format(analytics.DateLayout), ahead))
+	return nil, nil
+}
+
+func (m *partitionedMemory) DropPartitions(ctx context.Context, before time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dropped = append(m.dropped, before)
+	return nil, nil
+}
+
+func TestRollupJob(t *testing.T) {
+	now := time.Date(2024, 10, 10, 15, 0, 0, 0, time.UTC)
+
+	t.Run("RollsUpDaysBeforeTheCutoff", func(t *testing.T) {
+		ctx := context.Background()
+		mem := store.NewMemory()
+
+		for day := 1; day <= 10; day++ {
+			at := time.Date(2024, 10, day, 12, 0, 0, 0, time.UTC)
+			require.NoError(t, mem.InsertEvent(ctx, 1, models.Event{SessionID: "a", Name: "signup", Timestamp: &at}))
+		}
+
+		job := rollup.NewJob(mem, rollup.Config{RetentionDays: 7})
+		assert.Equal(t, time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC), job.Cutoff(now), "Today and the seven whole days before it are kept")
+
+		results, err := job.Run(ctx, now)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Rollup_result{
+			{Day: "2024-10-01", Events: 1},
+			{Day: "2024-10-02", Events: 1},
+		}, results)
+		assert.Len(t, mem.Events(1), 8)
+
+		results, err = job.Run(ctx, now)
+		require.NoError(t, err)
+		assert.Empty(t, results, "A day is only rolled up once")
+	})
+
+	t.Run("StartsOnlyWithRetention", func(t *testing.T) {
+		mem := store.NewMemory()
+		at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, mem.InsertEvent(context.Background(), 1, models.Event{SessionID: "a", Name: "signup", Timestamp: &at}))
+
+		disabled := rollup.NewJob(mem, rollup.DefaultConfig())
+		disabled.Start()
+		require.NoError(t, disabled.Close(context.Background()))
+		assert.Len(t, mem.Events(1), 1)
+
+		job := rollup.NewJob(mem, rollup.Config{RetentionDays: 30, Interval: time.Hour})
+		job.Start()
+		assert.Eventually(t, func() bool { return len(mem.Events(1)) == 0 }, time.Second, 5*time.Millisecond)
+		require.NoError(t, job.Close(context.Background()))
+	})
+
+	t.Run("MaintainsPartitions", func(t *testing.T) {
+		ctx := context.Background()
+		partitioned := &partitionedMemory{Memory: store.NewMemory()}
+
+		_, _, err := rollup.NewJob(partitioned, rollup.DefaultConfig()).Partition(ctx, now)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2024-10-10+3"}, partitioned.ensured)
+		assert.Empty(t, partitioned.dropped, "Without retention no month is dropped")
+
+		_, _, err = rollup.NewJob(partitioned, rollup.Config{RetentionDays: 7, PartitionsAhead: 1}).Partition(ctx, now)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2024-10-10+3", "2024-10-10+1"}, partitioned.ensured)
+		assert.Equal(t, []time.Time{time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC)}, partitioned.dropped)
+
+		_, _, err = rollup.NewJob(store.NewMemory(), rollup.DefaultConfig()).Partition(ctx, now)
+		assert.NoError(t, err, "Stores without partitions have nothing to maintain")
+
+		job := rollup.NewJob(partitioned, rollup.DefaultConfig())
+		job.Start()
+		assert.Eventually(t, func() bool {
+			partitioned.mu.Lock()
+			defer partitioned.mu.Unlock()
+			return len(partitioned.ensured) == 3
+		}, time.Second, 5*time.Millisecond, "Partitions are kept without a retention period too")
+		require.NoError(t, job.Close(ctx))
+	})
+
+	t.Run("ConfigFromEnv", func(t *testing.T) {
+		config, err := rollup.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, 0, config.RetentionDays, "Retention is off by default")
+		assert.Equal(t, time.Hour, config.Interval)
+		assert.Equal(t, 3, config.PartitionsAhead)
+
+		t.Setenv("DATA_RETENTION_DAYS", "90")
+		t.Setenv("ROLLUP_INTERVAL_MINUTES", "15")
+		config, err = rollup.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, 90, config.RetentionDays)
+		assert.Equal(t, 15*time.Minute, config.Interval)
+
+		t.Setenv("DATA_RETENTION_DAYS", "-1")
+		_, err = rollup.ConfigFromEnv()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSites(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	blog, err := sites.Create(ctx, mem, "blog", []string{"https://Blog.example.com/", "https://www.blog.example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	shop, err := sites.Create(ctx, mem, "shop", []string{"https://shop.example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	t.Run("OriginsAreNormalized", func(t *testing.T) {
+		assert.Equal(t, []string{"https://blog.example.com", "https://www.blog.example.com"}, blog.AllowedOrigins)
+		assert.True(t, sites.AllowsOrigin(blog, "https://blog.example.com/"))
+		assert.False(t, sites.AllowsOrigin(blog, "https://shop.example.com"))
+	})
+
+	t.Run("ResolveByToken", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/event?token="+shop.TrackingToken, nil)
+		req.Header.Set("Origin", "https://shop.example.com")
+
+		site, err := sites.FromRequest(mem, req)
+		require.NoError(t, err)
+		assert.Equal(t, shop.ID, site.ID)
+	})
+
+	t.Run("ResolveByOrigin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/event", nil)
+		req.Header.Set("Origin", "https://www.blog.example.com")
+
+		site, err := sites.FromRequest(mem, req)
+		require.NoError(t, err)
+		assert.Equal(t, blog.ID, site.ID)
+	})
+
+	t.Run("TokenOfAnotherSite", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/event?token="+shop.TrackingToken, nil)
+		req.Header.Set("Origin", "https://blog.example.com")
+
+		_, err := sites.FromRequest(mem, req)
+		assert.ErrorIs(t, err, sites.ErrOriginNotAllowed)
+	})
+
+	t.Run("EnsureDefaultIsIdempotent", func(t *testing.T) {
+		require.NoError(t, sites.EnsureDefault(ctx, mem, "legacy-token", "http://legacy.example.com"))
+		require.NoError(t, sites.EnsureDefault(ctx, mem, "legacy-token", "http://legacy.example.com"))
+
+		list, err := mem.ListSites(ctx)
+		require.NoError(t, err)
+		assert.Len(t, list, 3)
+
+		site, err := mem.SiteByToken(ctx, "legacy-token")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://legacy.example.com"}, site.AllowedOrigins)
+	})
+
+	t.Run("CreateSiteHandler", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{"name": "docs", "allowedOrigins": []string{"https://docs.example.com"}})
+		req := httptest.NewRequest(http.MethodPost, "/createSite", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+
+		h.CreateSite(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var site models.Site
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &site))
+		assert.NotZero(t, site.ID)
+		assert.NotEmpty(t, site.TrackingToken)
+	})
+
+	t.Run("CreateSiteHandlerMissingOrigins", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/createSite", bytes.NewBufferString(`{"name": "docs"}`))
+		w := httptest.NewRecorder()
+
+		h.CreateSite(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
@@ -0,0 +1,829 @@
+package main
+
+import (
+	"Borea/backend/analytics"
+	"Borea/backend/bots"
+	"Borea/backend/db"
+	"Borea/backend/models"
+	"Borea/backend/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forEachStore runs the same checks against every Store implementation, so the memory store
+// the handler tests use keeps behaving like Postgres
+func forEachStore(t *testing.T, test func(t *testing.T, s store.Store)) {
+	t.Run("Memory", func(t *testing.T) {
+		test(t, store.NewMemory())
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		skipWithoutPostgres(t)
+
+		err := db.InitDB()
+		require.NoError(t, err, "Database initialization error")
+		defer db.DB.Close()
+
+		err = SetUpTestSchema()
+		require.NoError(t, err, "Failed to migrate test schema")
+		defer TearDownTestSchema()
+
+		test(t, store.NewPostgres(db.DB))
+	})
+}
+
+func TestStoreSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+		other, err := s.CreateSite(ctx, models.Site{Name: "other", TrackingToken: "other-token", AllowedOrigins: []string{"http://other.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		start := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		beacon := func(offset time.Duration, duration int64, language string) models.Session {
+			activity := start.Add(offset)
+			return models.Session{
+				SessionID:        "7a0e4c55-2c1b-4d6e-8f3a-9b2d1c0e5f47",
+				LastActivityTime: &activity,
+				StartTime:        &start,
+				SessionDuration:  &duration,
+				Language:         &language,
+			}
+		}
+
+		require.NoError(t, s.UpsertSession(ctx, site.ID, beacon(time.Minute, 60000, "en")))
+		require.NoError(t, s.UpsertSession(ctx, site.ID, beacon(30*time.Second, 30000, "de")), "A stale beacon is not an error")
+
+		err = s.UpsertSession(ctx, other.ID, beacon(2*time.Minute, 120000, "en"))
+		assert.True(t, errors.Is(err, store.ErrSessionOfAnotherSite))
+
+		buckets, err := s.AverageDuration(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Duration_bucket{{Date: "2024-10-01", AverageDuration: 60000}}, buckets, "The stale beacon should not rewind the session")
+
+		rows, err := s.Languages(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Breakdown_row{{Value: "en", Count: 1}}, rows, "The first seen language should be kept")
+
+		count, err := s.SessionsOverTime(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", other.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Time_bucket{{Date: "2024-10-01", Count: 0}}, count, "The session should not move to the other site")
+	})
+}
+
+func TestStoreWriteBatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+		other, err := s.CreateSite(ctx, models.Site{Name: "other", TrackingToken: "other-token", AllowedOrigins: []string{"http://other.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		taken := models.Session{SessionID: "3c9d2b1a-0f8e-4d7c-b6a5-948372615049"}
+		require.NoError(t, s.UpsertSession(ctx, other.ID, taken))
+
+		activity := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		fresh := models.Session{SessionID: "3c9d2b1a-0f8e-4d7c-b6a5-94837261504a", LastActivityTime: &activity}
+		event := models.Event{SessionID: fresh.SessionID, Name: "signup", Properties: []byte(`{}`)}
+
+		errs, err := s.WriteBatch(ctx, site.ID, []store.Write{{Session: &taken}, {Session: &fresh}, {Event: &event}})
+		require.NoError(t, err)
+		require.Len(t, errs, 3)
+		assert.True(t, errors.Is(errs[0], store.ErrSessionOfAnotherSite))
+		assert.NoError(t, errs[1], "A failed write should not affect the rest of the batch")
+		assert.NoError(t, errs[2])
+
+		count, err := s.SessionsOverTime(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Time_bucket{{Date: "2024-10-01", Count: 1}}, count)
+	})
+}
+
+func TestStoreSitesAndKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		first, err := s.CreateSite(ctx, models.Site{Name: "first", TrackingToken: "first-token", AllowedOrigins: []string{"https://shared.example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+		assert.NotZero(t, first.ID)
+
+		_, err = s.CreateSite(ctx, models.Site{Name: "second", TrackingToken: "second-token", AllowedOrigins: []string{"https://shared.example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		_, err = s.CreateSite(ctx, models.Site{Name: "duplicate", TrackingToken: "first-token", AllowedOrigins: []string{"https://dup.example.com"}, BotPolicy: bots.PolicyFlag})
+		assert.Error(t, err, "Tracking tokens are unique")
+
+		site, err := s.SiteByOrigin(ctx, "https://shared.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, first.ID, site.ID, "The oldest site should win a shared origin")
+
+		site, err = s.SiteByToken(ctx, "first-token")
+		require.NoError(t, err)
+		assert.Equal(t, first, site)
+
+		_, err = s.SiteByToken(ctx, "missing")
+		assert.True(t, errors.Is(err, store.ErrNotFound))
+
+		list, err := s.ListSites(ctx)
+		require.NoError(t, err)
+		assert.Len(t, list, 2)
+
+		require.NoError(t, s.CreateAPIKey(ctx, models.Api_key{Name: "reader", KeyHash: "hash", Scopes: []string{"read"}}))
+
+		key, err := s.APIKey(ctx, "hash")
+		require.NoError(t, err)
+		assert.Equal(t, models.Api_key{Name: "reader", KeyHash: "hash", Scopes: []string{"read"}}, key)
+
+		_, err = s.APIKey(ctx, "unknown")
+		assert.True(t, errors.Is(err, store.ErrNotFound))
+
+		_, err = s.AdminUser(ctx, "nobody")
+		assert.True(t, errors.Is(err, store.ErrNotFound))
+	})
+}
+
+func TestStoreChannelRules(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		late, err := s.CreateChannelRule(ctx, models.Channel_rule{Channel: "social", Field: "referrer_host", Pattern: `^t\.co$`, Priority: 20})
+		require.NoError(t, err)
+		early, err := s.CreateChannelRule(ctx, models.Channel_rule{Channel: "paid", Field: "utm_medium", Pattern: "^cpc$", Priority: 10})
+		require.NoError(t, err)
+		tied, err := s.CreateChannelRule(ctx, models.Channel_rule{Channel: "email", Field: "utm_source", Pattern: "^newsletter$", Priority: 20})
+		require.NoError(t, err)
+
+		rules, err := s.ChannelRules(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Channel_rule{early, late, tied}, rules, "Rules are ordered by priority, then ID")
+
+		require.NoError(t, s.DeleteChannelRule(ctx, late.ID))
+		assert.True(t, errors.Is(s.DeleteChannelRule(ctx, late.ID), store.ErrNotFound))
+
+		rules, err = s.ChannelRules(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Channel_rule{early, tied}, rules)
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		activity := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		campaign, medium, paid, direct := "spring-sale", "cpc", "paid", "direct"
+		first := models.Session{
+			SessionID:        "5b7c9d1e-2f3a-4b5c-8d6e-7f8091a2b3c4",
+			LastActivityTime: &activity,
+			Campaign:         models.Campaign{Name: &campaign, Medium: &medium},
+			Channel:          &paid,
+		}
+		later := models.Session{SessionID: first.SessionID, LastActivityTime: &activity, Channel: &direct}
+		require.NoError(t, s.UpsertSession(ctx, site.ID, first))
+		require.NoError(t, s.UpsertSession(ctx, site.ID, later))
+
+		channels, err := s.Channels(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Breakdown_row{{Value: "paid", Count: 1}}, channels, "The first seen channel should be kept")
+
+		campaigns, err := s.Campaigns(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Breakdown_row{{Value: "spring-sale", Count: 1}}, campaigns)
+	})
+}
+
+func TestStorePageViews(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+		other, err := s.CreateSite(ctx, models.Site{Name: "other", TrackingToken: "other-token", AllowedOrigins: []string{"http://other.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		start := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		human, crawler, unknown := "6a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3d", "6a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3e", "6a1b2c3d-4e5f-4a6b-8c7d-8e9f0a1b2c3f"
+		require.NoError(t, s.UpsertSession(ctx, site.ID, models.Session{SessionID: human, LastActivityTime: &start}))
+		require.NoError(t, s.UpsertSession(ctx, site.ID, models.Session{SessionID: crawler, LastActivityTime: &start, IsBot: true}))
+
+		view := func(n int, sessionID, path string, duration int64) models.Page_view {
+			entered := start.Add(time.Duration(n) * time.Minute)
+			return models.Page_view{
+				ViewID:    fmt.Sprintf("9e8d7c6b-5a49-4382-9170-%012d", n),
+				SessionID: sessionID,
+				Path:      path,
+				Timestamp: &entered,
+				Duration:  &duration,
+			}
+		}
+
+		for _, v := range []models.Page_view{
+			view(1, human, "/", 1000),
+			view(2, human, "/pricing", 3000),
+			view(3, human, "/signup", 5000),
+			view(4, crawler, "/pricing", 10),
+			view(5, unknown, "/pricing", 10),
+		} {
+			require.NoError(t, s.UpsertPageView(ctx, site.ID, v))
+		}
+
+		// Resending a view records its time on page, but never shortens it
+		require.NoError(t, s.UpsertPageView(ctx, site.ID, view(2, human, "/pricing", 7000)))
+		require.NoError(t, s.UpsertPageView(ctx, site.ID, view(2, human, "/pricing", 2000)))
+
+		err = s.UpsertPageView(ctx, site.ID, view(2, crawler, "/pricing", 2000))
+		assert.True(t, errors.Is(err, store.ErrViewOfAnotherSession))
+		err = s.UpsertPageView(ctx, other.ID, view(2, human, "/pricing", 2000))
+		assert.True(t, errors.Is(err, store.ErrViewOfAnotherSession))
+
+		p := analyticsParams(t, "2024-10-01", "2024-10-01", site.ID)
+
+		pages, err := s.TopPages(ctx, p)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []models.Page_row{
+			{Path: "/", Views: 1, Sessions: 1, AverageDuration: 1000},
+			{Path: "/pricing", Views: 2, Sessions: 2, AverageDuration: 3505},
+			{Path: "/signup", Views: 1, Sessions: 1, AverageDuration: 5000},
+		}, pages, "Views of bots are left out, views of sessions never stored count like the rollups count them")
+
+		entries, err := s.EntryPages(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Breakdown_row{{Value: "/", Count: 1}, {Value: "/pricing", Count: 1}}, entries)
+
+		exits, err := s.ExitPages(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Breakdown_row{{Value: "/pricing", Count: 1}, {Value: "/signup", Count: 1}}, exits)
+
+		pages, err = s.TopPages(ctx, analyticsParams(t, "2024-10-01", "2024-10-01", other.ID))
+		require.NoError(t, err)
+		assert.Empty(t, pages)
+	})
+}
+
+func TestStoreFunnel(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		start := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		n := 0
+		visit := func(sessionID string, isBot bool, steps ...string) {
+			require.NoError(t, s.UpsertSession(ctx, site.ID, models.Session{SessionID: sessionID, LastActivityTime: &start, IsBot: isBot}))
+
+			for i, step := range steps {
+				n++
+				at := start.Add(time.Duration(i) * 10 * time.Minute)
+				if name, ok := strings.CutPrefix(step, "event:"); ok {
+					require.NoError(t, s.InsertEvent(ctx, site.ID, models.Event{SessionID: sessionID, Name: name, Timestamp: &at, Properties: json.RawMessage("{}")}))
+					continue
+				}
+				viewID := fmt.Sprintf("4d3c2b1a-0f9e-4d8c-9b7a-%012d", n)
+				require.NoError(t, s.UpsertPageView(ctx, site.ID, models.Page_view{ViewID: viewID, SessionID: sessionID, Path: step, Timestamp: &at}))
+			}
+		}
+
+		visit("3f2e1d0c-0000-4000-8000-000000000001", false, "/pricing", "/signup", "event:checkout")
+		visit("3f2e1d0c-0000-4000-8000-000000000002", false, "/pricing", "/signup/team")
+		visit("3f2e1d0c-0000-4000-8000-000000000003", false, "/pricing", "/blog", "/blog", "/signup")
+		visit("3f2e1d0c-0000-4000-8000-000000000004", false, "/signup", "/pricing", "event:checkout")
+		visit("3f2e1d0c-0000-4000-8000-000000000005", true, "/pricing", "/signup", "event:checkout")
+
+		funnel := analytics.Funnel{
+			Params: analyticsParams(t, "2024-10-01", "2024-10-01", site.ID),
+			Steps: []analytics.FunnelStep{
+				{Type: analytics.StepPageview, Match: "/pricing"},
+				{Type: analytics.StepPageview, Match: "/signup*"},
+				{Type: analytics.StepEvent, Match: "checkout"},
+			},
+		}
+
+		steps, err := s.Funnel(ctx, funnel)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Funnel_step{
+			{Step: "pageview:/pricing", Sessions: 4, Conversion: 1},
+			{Step: "pageview:/signup*", Sessions: 3, Conversion: 0.75, DropOff: 1, DropOffRate: 0.25},
+			{Step: "event:checkout", Sessions: 1, Conversion: 0.25, DropOff: 2, DropOffRate: 2.0 / 3},
+		}, steps, "Steps count in order only, bots are left out")
+
+		funnel.Within = 15 * time.Minute
+		steps, err = s.Funnel(ctx, funnel)
+		require.NoError(t, err)
+		assert.Equal(t, []int{4, 2, 1}, []int{steps[0].Sessions, steps[1].Sessions, steps[2].Sessions}, "/signup came 30 minutes after /pricing")
+
+		funnel.Steps = []analytics.FunnelStep{{Type: analytics.StepPageview, Match: "/blog"}, {Type: analytics.StepPageview, Match: "/blog"}}
+		steps, err = s.Funnel(ctx, funnel)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 1}, []int{steps[0].Sessions, steps[1].Sessions}, "A repeated step needs another view")
+
+		funnel.Steps = []analytics.FunnelStep{{Type: analytics.StepPageview, Match: "/signup"}, {Type: analytics.StepPageview, Match: "/signup"}}
+		steps, err = s.Funnel(ctx, funnel)
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 0}, []int{steps[0].Sessions, steps[1].Sessions})
+
+		funnel.Params = analyticsParams(t, "2024-10-02", "2024-10-02", site.ID)
+		steps, err = s.Funnel(ctx, funnel)
+		require.NoError(t, err)
+		assert.Zero(t, steps[0].Sessions)
+		assert.Zero(t, steps[0].Conversion)
+	})
+}
+
+func TestStoreRetention(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		alice, bob, carol := "a11ce000-0000-4000-8000-000000000001", "b0b00000-0000-4000-8000-000000000002", "ca201000-0000-4000-8000-000000000003"
+		n := 0
+		session := func(userID, day string, isBot bool) models.Session {
+			n++
+			start, err := time.Parse(analytics.DateLayout, day)
+			require.NoError(t, err)
+			end := start.Add(30 * time.Minute)
+			return models.Session{
+				SessionID:        fmt.Sprintf("5e551000-0000-4000-8000-%012d", n),
+				UserID:           &userID,
+				StartTime:        &start,
+				LastActivityTime: &end,
+				IsBot:            isBot,
+			}
+		}
+
+		// Mondays: 2024-09-30, 10-07, 10-14 and 10-21
+		first := session(alice, "2024-10-01", false)
+		require.NoError(t, s.UpsertSession(ctx, site.ID, first))
+		require.NoError(t, s.UpsertSession(ctx, site.ID, first), "A resent beacon is not another session")
+		require.NoError(t, s.UpsertSessions(ctx, []models.Site_session{
+			{SiteID: site.ID, Session: session(alice, "2024-10-09", false)},
+			{SiteID: site.ID, Session: session(alice, "2024-10-22", false)},
+			{SiteID: site.ID, Session: session(bob, "2024-10-02", false)},
+			{SiteID: site.ID, Session: session(carol, "2024-10-08", false)},
+			{SiteID: site.ID, Session: session(carol, "2024-10-10", false)},
+			{SiteID: site.ID, Session: session(bob, "2024-10-15", true)},
+		}))
+
+		user, err := s.UniqueUser(ctx, site.ID, alice)
+		require.NoError(t, err)
+		assert.Equal(t, 3, user.SessionCount)
+		assert.True(t, user.FirstSeen.Equal(time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)), user.FirstSeen.String())
+		assert.True(t, user.LastSeen.Equal(time.Date(2024, 10, 22, 0, 30, 0, 0, time.UTC)), user.LastSeen.String())
+
+		user, err = s.UniqueUser(ctx, site.ID, bob)
+		require.NoError(t, err)
+		assert.Equal(t, 1, user.SessionCount, "Bot sessions are not counted")
+
+		_, err = s.UniqueUser(ctx, site.ID, "d0000000-0000-4000-8000-000000000004")
+		assert.True(t, errors.Is(err, store.ErrNotFound))
+
+		p := analyticsParams(t, "2024-09-30", "2024-10-27", site.ID)
+		p.Granularity = "week"
+
+		cohorts, err := s.Retention(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Retention_cohort{
+			{Cohort: "2024-09-30", Users: 2, Active: []int{2, 1, 0, 1}, Retention: []float64{1, 0.5, 0, 0.5}},
+			{Cohort: "2024-10-07", Users: 1, Active: []int{1, 0, 0}, Retention: []float64{1, 0, 0}},
+			{Cohort: "2024-10-14", Users: 0, Active: []int{0, 0}, Retention: []float64{0, 0}},
+			{Cohort: "2024-10-21", Users: 0, Active: []int{0}, Retention: []float64{0}},
+		}, cohorts)
+
+		p = analyticsParams(t, "2024-10-07", "2024-10-13", site.ID)
+		p.Granularity = "week"
+		cohorts, err = s.Retention(ctx, p)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Retention_cohort{
+			{Cohort: "2024-10-07", Users: 1, Active: []int{1}, Retention: []float64{1}},
+		}, cohorts, "Users first seen before the range are not in a cohort")
+	})
+}
+
+func TestStoreExport(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+		other, err := s.CreateSite(ctx, models.Site{Name: "other", TrackingToken: "other-token", AllowedOrigins: []string{"http://other.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		at := func(day, clock string) *time.Time {
+			parsed, err := time.Parse(time.RFC3339, day+"T"+clock+"Z")
+			require.NoError(t, err)
+			return &parsed
+		}
+		language := "en"
+
+		require.NoError(t, s.UpsertSessions(ctx, []models.Site_session{
+			{SiteID: site.ID, Session: models.Session{SessionID: "e0000000-0000-4000-8000-000000000002", LastActivityTime: at("2024-10-02", "09:00:00"), Language: &language}},
+			{SiteID: site.ID, Session: models.Session{SessionID: "e0000000-0000-4000-8000-000000000001", LastActivityTime: at("2024-10-01", "09:00:00"), IsBot: true}},
+			{SiteID: other.ID, Session: models.Session{SessionID: "e0000000-0000-4000-8000-000000000003", LastActivityTime: at("2024-10-01", "10:00:00")}},
+			{SiteID: site.ID, Session: models.Session{SessionID: "e0000000-0000-4000-8000-000000000004", LastActivityTime: at("2024-10-03", "00:00:00")}},
+		}))
+
+		for i, clock := range []string{"12:00:00", "08:00:00"} {
+			require.NoError(t, s.InsertEvent(ctx, site.ID, models.Event{
+				SessionID:  "e0000000-0000-4000-8000-000000000002",
+				Name:       fmt.Sprintf("event-%d", i),
+				Timestamp:  at("2024-10-02", clock),
+				Properties: json.RawMessage(`{"n":1}`),
+			}))
+		}
+
+		p := analyticsParams(t, "2024-10-01", "2024-10-02", site.ID)
+
+		sessions := make([]models.Site_session, 0)
+		require.NoError(t, s.ExportSessions(ctx, p, func(session models.Site_session) error {
+			sessions = append(sessions, session)
+			return nil
+		}))
+		require.Len(t, sessions, 2)
+		assert.Equal(t, "e0000000-0000-4000-8000-000000000001", sessions[0].Session.SessionID, "Bots are exported")
+		assert.True(t, sessions[0].Session.IsBot)
+		assert.Equal(t, "e0000000-0000-4000-8000-000000000002", sessions[1].Session.SessionID)
+		assert.Equal(t, site.ID, sessions[1].SiteID)
+		assert.Equal(t, "en", *sessions[1].Session.Language)
+
+		events := make([]models.Site_event, 0)
+		require.NoError(t, s.ExportEvents(ctx, p, func(event models.Site_event) error {
+			events = append(events, event)
+			return nil
+		}))
+		require.Len(t, events, 2)
+		assert.Equal(t, "event-1", events[0].Event.Name, "Events are ordered by their timestamp")
+		assert.JSONEq(t, `{"n":1}`, string(events[0].Event.Properties))
+
+		require.NoError(t, s.UpsertPageView(ctx, site.ID, models.Page_view{
+			ViewID: "e0000000-0000-4000-8000-000000000010", SessionID: "e0000000-0000-4000-8000-000000000002", Path: "/", Timestamp: at("2024-10-02", "08:59:00"),
+		}))
+		require.NoError(t, s.UpsertPageView(ctx, other.ID, models.Page_view{
+			ViewID: "e0000000-0000-4000-8000-000000000011", SessionID: "e0000000-0000-4000-8000-000000000003", Path: "/", Timestamp: at("2024-10-01", "09:59:00"),
+		}))
+
+		views := make([]models.Site_view, 0)
+		require.NoError(t, s.ExportPageViews(ctx, p, func(view models.Site_view) error {
+			views = append(views, view)
+			return nil
+		}))
+		require.Len(t, views, 1)
+		assert.Equal(t, "e0000000-0000-4000-8000-000000000010", views[0].View.ViewID)
+		assert.Equal(t, "/", views[0].View.Path)
+
+		stop := errors.New("stop")
+		calls := 0
+		p.Site = 0
+		err = s.ExportSessions(ctx, p, func(models.Site_session) error {
+			calls++
+			return stop
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls, "An error from each ends the export")
+	})
+}
+
+func TestStoreRollUpDay(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		userID := "a11ce000-0000-4000-8000-000000000001"
+		n := 0
+		session := func(day int, referrer string, duration int64, isBot bool, paths ...string) string {
+			n++
+			sessionID := fmt.Sprintf("70110000-0000-4000-8000-%012d", n)
+			start := time.Date(2024, 10, day, 10, n, 0, 0, time.UTC)
+			end := start.Add(10 * time.Minute)
+			language := "en"
+			require.NoError(t, s.UpsertSession(ctx, site.ID, models.Session{
+				SessionID:        sessionID,
+				UserID:           &userID,
+				StartTime:        &start,
+				LastActivityTime: &end,
+				SessionDuration:  &duration,
+				Referrer:         &referrer,
+				Language:         &language,
+				IsBot:            isBot,
+			}))
+
+			for i, path := range paths {
+				entered := start.Add(time.Duration(i) * time.Minute)
+				viewDuration := int64(1000 * (i + 1))
+				require.NoError(t, s.UpsertPageView(ctx, site.ID, models.Page_view{
+					ViewID:    fmt.Sprintf("70110000-0000-4000-9000-%06d%06d", n, i),
+					SessionID: sessionID,
+					Path:      path,
+					Timestamp: &entered,
+					Duration:  &viewDuration,
+				}))
+			}
+			require.NoError(t, s.InsertEvent(ctx, site.ID, models.Event{SessionID: sessionID, Name: "signup", Timestamp: &end}))
+			return sessionID
+		}
+
+		session(1, "https://google.com/", 60000, false, "/", "/pricing")
+		session(1, "", 120000, false, "/pricing")
+		session(1, "https://google.com/", 5000, true, "/")
+		session(2, "https://news.ycombinator.com/", 30000, false, "/", "/docs", "/signup")
+		session(3, "", 90000, false, "/docs")
+
+		// Everything the analytics endpoints report, before and after the rollups
+		p := analyticsParams(t, "2024-09-30", "2024-10-06", site.ID)
+		report := func() map[string]interface{} {
+			results := make(map[string]interface{})
+			for name, query := range map[string]func(context.Context, analytics.Params) (interface{}, error){
+				"sessions":  func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.SessionsOverTime(ctx, p) },
+				"duration":  func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.AverageDuration(ctx, p) },
+				"referrers": func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.TopReferrers(ctx, p) },
+				"languages": func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.Languages(ctx, p) },
+				"pages":     func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.TopPages(ctx, p) },
+				"entries":   func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.EntryPages(ctx, p) },
+				"exits":     func(ctx context.Context, p analytics.Params) (interface{}, error) { return s.ExitPages(ctx, p) },
+			} {
+				for _, granularity := range []string{"day", "week"} {
+					p.Granularity = granularity
+					result, err := query(ctx, p)
+					require.NoError(t, err, name)
+					results[name+"/"+granularity] = result
+				}
+			}
+			return results
+		}
+		before := report()
+
+		cutoff := time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC)
+		result, ok, err := s.RollUpDay(ctx, cutoff)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, models.Rollup_result{Day: "2024-10-01", Sessions: 3, PageViews: 4, Events: 3}, result)
+
+		result, ok, err = s.RollUpDay(ctx, cutoff)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, models.Rollup_result{Day: "2024-10-02", Sessions: 1, PageViews: 3, Events: 1}, result)
+
+		_, ok, err = s.RollUpDay(ctx, cutoff)
+		require.NoError(t, err)
+		assert.False(t, ok, "The day of the cutoff is kept raw")
+
+		assert.Equal(t, before, report(), "Rolled up days report what their raw data did")
+
+		user, err := s.UniqueUser(ctx, site.ID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, 4, user.SessionCount, "Deleted sessions still count for their user")
+
+		session(4, "", 1000, false)
+		user, err = s.UniqueUser(ctx, site.ID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, 5, user.SessionCount)
+		assert.True(t, user.FirstSeen.Equal(time.Date(2024, 10, 1, 10, 1, 0, 0, time.UTC)), user.FirstSeen.String())
+	})
+}
+
+// A session without any activity is deleted by its start, it has to be rolled up by it too
+func TestStoreRollUpSessionWithoutActivity(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}})
+		require.NoError(t, err)
+
+		start := time.Date(2024, 10, 1, 23, 30, 0, 0, time.UTC)
+		require.NoError(t, s.UpsertSession(ctx, site.ID, models.Session{SessionID: "57a27000-0000-4000-8000-000000000001", StartTime: &start}))
+
+		result, ok, err := s.RollUpDay(ctx, time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC))
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, models.Rollup_result{Day: "2024-10-01", Sessions: 1}, result)
+
+		buckets, err := s.SessionsOverTime(ctx, analyticsParams(t, "2024-10-01", "2024-10-02", site.ID))
+		require.NoError(t, err)
+		assert.Equal(t, []models.Time_bucket{{Date: "2024-10-01", Count: 1}, {Date: "2024-10-02", Count: 0}}, buckets)
+	})
+}
+
+// Page views and events without a session are rolled up before they are deleted, so a rollup
+// doesn't change what a range reports
+func TestPostgresRollUpWithoutSession(t *testing.T) {
+	skipWithoutPostgres(t)
+
+	err := db.InitDB()
+	require.NoError(t, err, "Database initialization error")
+	defer db.DB.Close()
+
+	err = SetUpTestSchema()
+	require.NoError(t, err, "Failed to migrate test schema")
+	defer TearDownTestSchema()
+
+	ctx := context.Background()
+	pg := store.NewPostgres(db.DB)
+
+	site, err := pg.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+	require.NoError(t, err)
+
+	// Neither session ever arrived
+	for i, day := range []int{1, 3} {
+		at := time.Date(2024, 10, day, 12, 0, 0, 0, time.UTC)
+		sessionID := fmt.Sprintf("0a9ba000-0000-4000-8000-%012d", i)
+		require.NoError(t, pg.InsertEvent(ctx, site.ID, models.Event{SessionID: sessionID, Name: "signup", Timestamp: &at}))
+		require.NoError(t, pg.UpsertPageView(ctx, site.ID, models.Page_view{
+			ViewID: fmt.Sprintf("0a9ba000-0000-4000-9000-%012d", i), SessionID: sessionID, Path: "/", Timestamp: &at,
+		}))
+	}
+
+	p := analyticsParams(t, "2024-10-01", "2024-10-03", site.ID)
+	before, err := pg.TopPages(ctx, p)
+	require.NoError(t, err)
+
+	cutoff := time.Date(2024, 10, 3, 0, 0, 0, 0, time.UTC)
+	result, ok, err := pg.RollUpDay(ctx, cutoff)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, models.Rollup_result{Day: "2024-10-01", PageViews: 1, Events: 1}, result)
+
+	_, ok, err = pg.RollUpDay(ctx, cutoff)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	var events, rolledUp int
+	require.NoError(t, db.DB.QueryRow("SELECT COUNT(*) FROM events").Scan(&events))
+	require.NoError(t, db.DB.QueryRow("SELECT COALESCE(SUM(events), 0) FROM daily_events WHERE day = '2024-10-01'").Scan(&rolledUp))
+	assert.Equal(t, 1, events, "The event after the cutoff is kept raw")
+	assert.Equal(t, 1, rolledUp, "The event before it is counted, not just deleted")
+
+	after, err := pg.TopPages(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Equal(t, []models.Page_row{{Path: "/", Views: 2, Sessions: 2}}, after)
+}
+
+// A session Postgres rejects must not cost the rest of the flushed batch
+func TestPostgresUpsertSessionsFallback(t *testing.T) {
+	skipWithoutPostgres(t)
+
+	err := db.InitDB()
+	require.NoError(t, err, "Database initialization error")
+	defer db.DB.Close()
+
+	err = SetUpTestSchema()
+	require.NoError(t, err, "Failed to migrate test schema")
+	defer TearDownTestSchema()
+
+	ctx := context.Background()
+	pg := store.NewPostgres(db.DB)
+
+	site, err := pg.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+	require.NoError(t, err)
+
+	good, tooLong := int64(2000), int64(1<<40)
+	batch := []models.Site_session{
+		{SiteID: site.ID, Session: models.Session{SessionID: "9e8d7c6b-5a49-4382-a1b0-c9d8e7f6a5b4", SessionDuration: &good}},
+		{SiteID: site.ID, Session: models.Session{SessionID: "9e8d7c6b-5a49-4382-a1b0-c9d8e7f6a5b5", SessionDuration: &tooLong}},
+	}
+
+	assert.Error(t, pg.UpsertSessions(ctx, batch))
+
+	var duration int64
+	err = db.DB.QueryRow("SELECT session_duration FROM sessions WHERE session_id = $1", batch[0].Session.SessionID).Scan(&duration)
+	require.NoError(t, err)
+	assert.Equal(t, good, duration)
+}
+
+func TestPostgresSessionPartitions(t *testing.T) {
+	skipWithoutPostgres(t)
+
+	err := db.InitDB()
+	require.NoError(t, err, "Database initialization error")
+	defer db.DB.Close()
+
+	err = SetUpTestSchema()
+	require.NoError(t, err, "Failed to migrate test schema")
+	defer TearDownTestSchema()
+
+	ctx := context.Background()
+	pg := store.NewPostgres(db.DB)
+
+	site, err := pg.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+	require.NoError(t, err)
+
+	partitionOf := func(sessionID string) string {
+		var partition string
+		err := db.DB.QueryRow("SELECT tableoid::regclass::text FROM sessions WHERE session_id = $1", sessionID).Scan(&partition)
+		require.NoError(t, err)
+		return partition
+	}
+
+	// Far enough ahead that the migration created no partition for it
+	future := time.Date(2099, 3, 15, 10, 0, 0, 0, time.UTC)
+	sessionID := "3c4d5e6f-7081-4920-a3b4-c5d6e7f80912"
+	require.NoError(t, pg.UpsertSession(ctx, site.ID, models.Session{SessionID: sessionID, LastActivityTime: &future}))
+	assert.Equal(t, "sessions_default", partitionOf(sessionID))
+
+	created, err := pg.EnsurePartitions(ctx, future, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sessions_y2099m03", "sessions_y2099m04"}, created)
+	assert.Equal(t, "sessions_y2099m03", partitionOf(sessionID), "Sessions move out of the default partition")
+
+	created, err = pg.EnsurePartitions(ctx, future, 1)
+	require.NoError(t, err)
+	assert.Empty(t, created)
+
+	later := future.AddDate(0, 1, 0)
+	require.NoError(t, pg.UpsertSession(ctx, site.ID, models.Session{SessionID: sessionID, LastActivityTime: &later}))
+	assert.Equal(t, "sessions_y2099m04", partitionOf(sessionID), "A session follows its last activity")
+
+	err = pg.UpsertSession(ctx, site.ID+1, models.Session{SessionID: sessionID, LastActivityTime: &later})
+	assert.True(t, errors.Is(err, store.ErrSessionOfAnotherSite))
+
+	dropped, err := pg.DropPartitions(ctx, time.Date(2099, 4, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.NotContains(t, dropped, "sessions_y2099m04", "Months not over by the cutoff are kept")
+	assert.Contains(t, dropped, "sessions_y2099m03")
+	assert.Equal(t, "sessions_y2099m04", partitionOf(sessionID))
+}
+
+// An expired month is rolled up whole and its partition dropped, its sessions are never deleted row by row
+func TestPostgresDropPartitionsRollsUp(t *testing.T) {
+	skipWithoutPostgres(t)
+
+	err := db.InitDB()
+	require.NoError(t, err, "Database initialization error")
+	defer db.DB.Close()
+
+	err = SetUpTestSchema()
+	require.NoError(t, err, "Failed to migrate test schema")
+	defer TearDownTestSchema()
+
+	ctx := context.Background()
+	pg := store.NewPostgres(db.DB)
+
+	site, err := pg.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+	require.NoError(t, err)
+
+	month := time.Date(2098, 6, 1, 0, 0, 0, 0, time.UTC)
+	_, err = pg.EnsurePartitions(ctx, month, 1)
+	require.NoError(t, err)
+
+	for i, day := range []int{3, 3, 28} {
+		sessionID := fmt.Sprintf("d7090000-0000-4000-8000-%012d", i)
+		at := time.Date(2098, 6, day, 12, i, 0, 0, time.UTC)
+		require.NoError(t, pg.UpsertSession(ctx, site.ID, models.Session{SessionID: sessionID, StartTime: &at, LastActivityTime: &at}))
+		require.NoError(t, pg.UpsertPageView(ctx, site.ID, models.Page_view{
+			ViewID: fmt.Sprintf("d7090000-0000-4000-9000-%012d", i), SessionID: sessionID, Path: "/", Timestamp: &at,
+		}))
+	}
+	next := time.Date(2098, 7, 2, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, pg.UpsertSession(ctx, site.ID, models.Session{SessionID: "d7090000-0000-4000-8000-000000000010", LastActivityTime: &next}))
+
+	p := analyticsParams(t, "2098-06-01", "2098-07-31", site.ID)
+	p.Granularity = "month"
+	before, err := pg.SessionsOverTime(ctx, p)
+	require.NoError(t, err)
+
+	dropped, err := pg.DropPartitions(ctx, time.Date(2098, 7, 10, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Contains(t, dropped, "sessions_y2098m06")
+	assert.NotContains(t, dropped, "sessions_y2098m07", "July is not over yet")
+
+	var exists bool
+	require.NoError(t, db.DB.QueryRow("SELECT to_regclass('sessions_y2098m06') IS NOT NULL").Scan(&exists))
+	assert.False(t, exists, "The partition is gone")
+
+	var raw, views int
+	require.NoError(t, db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE last_activity_time < '2098-07-01'").Scan(&raw))
+	require.NoError(t, db.DB.QueryRow("SELECT COUNT(*) FROM page_views WHERE viewed_at < '2098-07-01'").Scan(&views))
+	assert.Zero(t, raw)
+	assert.Zero(t, views)
+
+	after, err := pg.SessionsOverTime(ctx, p)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "The month's sessions are reported from the rollups")
+	assert.Equal(t, []models.Time_bucket{{Date: "2098-06-01", Count: 3}, {Date: "2098-07-01", Count: 1}}, after)
+
+	pages, err := pg.TopPages(ctx, analyticsParams(t, "2098-06-01", "2098-06-30", site.ID))
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	assert.Equal(t, "/", pages[0].Path)
+}
+
+func analyticsParams(t *testing.T, from, to string, site int) analytics.Params {
+	t.Helper()
+
+	p, err := analytics.ParseParams(url.Values{"from": {from}, "to": {to}, "site": {strconv.Itoa(site)}})
+	require.NoError(t, err)
+	return p
+}
@@ -1,66 +1,46 @@
 package main
 
 import (
-	"Borea/backend/db"
 	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
 	"bytes"
+	"context"
 	"encoding/json"
-	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func CreateSessionTestTable() error {
-	_, err := db.DB.Exec(`
-	CREATE TABLE IF NOT EXISTS sessions (
-		id SERIAL PRIMARY KEY,
-		last_activity_time TIMESTAMP,
-		user_id UUID,
-		session_id UUID NOT NULL,
-		token TEXT,
-		start_time TIMESTAMP,
-		session_duration INTEGER,
-		user_agent TEXT,
-		referrer TEXT,
-		language TEXT
-	)`)
-	if err != nil {
-		log.Printf("Error creating sessions table: %v", err)
-		return err
-	}
-
-	log.Println("sessions created successfully")
-	return nil
-}
+func TestHandleScriptRequest(t *testing.T) {
+	// The script is served from the backend directory, like the server is started
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(".."))
+	defer os.Chdir(wd)
 
-func TearDownSessionTestTable() error {
-	_, err := db.DB.Exec(`DROP TABLE IF EXISTS sessions`)
-	if err != nil {
-		log.Printf("Error dropping session table: %v", err)
-		return err
-	}
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
 
-	log.Println("sessions table dropped successfully")
-	return nil
-}
+	site, err := sites.Create(context.Background(), mem, "borea", []string{"http://borea.dev"})
+	require.NoError(t, err, "Failed to create site")
 
-func TestHandleScriptRequest(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
-		os.Setenv("DOMAIN", "http://borea.dev")
-
-		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/script?token=5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", nil)
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8080/script?token="+site.TrackingToken, nil)
 		if err != nil {
 			t.Fatalf("Failed to create request: %v", err)
 		}
 		req.Header.Set("Referer", "http://borea.dev")
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handlers.HandleScriptRequest)
+		handler := http.HandlerFunc(h.HandleScriptRequest)
 		handler.ServeHTTP(rr, req)
 
 		if status := rr.Code; status != http.StatusOK {
@@ -85,7 +65,7 @@ func TestHandleScriptRequest(t *testing.T) {
 		}
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handlers.HandleScriptRequest)
+		handler := http.HandlerFunc(h.HandleScriptRequest)
 		handler.ServeHTTP(rr, req)
 
 		if status := rr.Code; status != http.StatusMethodNotAllowed {
@@ -94,8 +74,22 @@ func TestHandleScriptRequest(t *testing.T) {
 	})
 
 	t.Run("DomainNotAllowed", func(t *testing.T) {
-		os.Setenv("DOMAIN", "http://example.com")
+		req, err := http.NewRequest(http.MethodGet, "http://other.com/oigjgjgvk/ll?token="+site.TrackingToken, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		req.Header.Set("Referer", "http://other.com/edovinw/wgewfv")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(h.HandleScriptRequest)
+		handler.ServeHTTP(rr, req)
 
+		if status := rr.Code; status != http.StatusForbidden {
+			t.Errorf("Expected status code 403, got %d", status)
+		}
+	})
+
+	t.Run("UnknownDomainWithoutToken", func(t *testing.T) {
 		req, err := http.NewRequest(http.MethodGet, "http://other.com/oigjgjgvk/ll", nil)
 		if err != nil {
 			t.Fatalf("Failed to create request: %v", err)
@@ -103,7 +97,7 @@ func TestHandleScriptRequest(t *testing.T) {
 		req.Header.Set("Referer", "http://other.com/edovinw/wgewfv")
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handlers.HandleScriptRequest)
+		handler := http.HandlerFunc(h.HandleScriptRequest)
 		handler.ServeHTTP(rr, req)
 
 		if status := rr.Code; status != http.StatusForbidden {
@@ -112,17 +106,14 @@ func TestHandleScriptRequest(t *testing.T) {
 	})
 
 	t.Run("InvalidToken", func(t *testing.T) {
-		os.Setenv("DOMAIN", "http://example.com")
-		os.Setenv("API_TOKEN", "123456")
-
 		req, err := http.NewRequest(http.MethodGet, "http://example.com/script?token=123467", nil)
 		if err != nil {
 			t.Fatalf("Failed to create request: %v", err)
 		}
-		req.Header.Set("Referer", "http://example.com")
+		req.Header.Set("Referer", "http://borea.dev")
 
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(handlers.HandleScriptRequest)
+		handler := http.HandlerFunc(h.HandleScriptRequest)
 
 		handler.ServeHTTP(rr, req)
 
@@ -131,18 +122,15 @@ func TestHandleScriptRequest(t *testing.T) {
 		}
 	})
 }
+
 func TestPostSessionData(t *testing.T) {
-	// Set up the test database connection
-	err := db.InitDB()
-	require.NoError(t, err, "Database initialization error")
-	defer db.DB.Close()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
 
-	err = CreateSessionTestTable()
-	require.NoError(t, err, "Failed to create test table")
-	defer TearDownSessionTestTable()
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
 
-	// Mock environment variable for DOMAIN
-	os.Setenv("DOMAIN", "http://example.com")
+	postSessionURL := "/postSession?token=" + site.TrackingToken
 
 	// Prepare common session data for tests
 	sessionData := map[string]interface{}{
@@ -159,10 +147,11 @@ func TestPostSessionData(t *testing.T) {
 	// Insert new session
 	t.Run("InsertNewSession", func(t *testing.T) {
 		body, _ := json.Marshal(sessionData)
-		req := httptest.NewRequest(http.MethodPost, "/postSession", bytes.NewBuffer(body))
+		req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
 		w := httptest.NewRecorder()
 
-		handlers.PostSessionData(w, req)
+		h.PostSessionData(w, req)
 
 		resp := w.Result()
 		defer resp.Body.Close()
@@ -171,20 +160,18 @@ func TestPostSessionData(t *testing.T) {
 			t.Errorf("Expected status 200 OK, got %v", resp.StatusCode)
 		}
 
-		// Verify session is inserted
-		var count int
-		row := db.DB.QueryRow("SELECT COUNT(*) FROM sessions WHERE session_id = $1", sessionData["sessionId"])
-		err := row.Scan(&count)
-		if err != nil || count != 1 {
-			t.Errorf("Expected 1 session to be inserted, got %v", count)
+		// Verify session is inserted for the site
+		stored, ok := mem.Session(sessionData["sessionId"].(string))
+		if !ok || stored.SiteID != site.ID {
+			t.Errorf("Expected the session to be inserted for site %d, got %+v", site.ID, stored)
 		}
 	})
 
 	// Update existing session
 	t.Run("UpdateExistingSession", func(t *testing.T) {
 		sessionData := map[string]interface{}{
-			"sessionId":        "a415c043-3570-4fab-9db0-f040925321be", // Generate a new UUID for the sessionId
-			"lastActivityTime": time.Now().Format(time.RFC3339),
+			"sessionId":        "c2f5d0a4-7b1e-4f0c-8a6d-3e9b2d4f6a18", // session_id is unique, so not the one inserted above
+			"lastActivityTime": "2024-10-02T22:00:00Z",
 			"userId":           "adc0d882-329f-4f83-88b4-38fc593ad217", // Use a valid UUID here
 			"sessionDuration":  120,
 			"userAgent":        "Mozilla/5.0",
@@ -194,30 +181,18 @@ func TestPostSessionData(t *testing.T) {
 			"language":         "en",
 		}
 
-		// Insert new mock session into DB first
-		_, err = db.DB.Exec(`
-			INSERT INTO sessions (session_id, last_activity_time, user_id, session_duration, user_agent, referrer, token, start_time, language)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-			sessionData["sessionId"],
-			sessionData["lastActivityTime"],
-			sessionData["userId"],
-			sessionData["sessionDuration"],
-			sessionData["userAgent"],
-			sessionData["referrer"],
-			sessionData["token"],
-			sessionData["startTime"],
-			sessionData["language"],
-		)
-		if err != nil {
-			log.Printf("Error inserting test data into sessions table: %v", err)
-			return
-		}
+		// Store the session first
+		var seed models.Session
+		seedBody, _ := json.Marshal(sessionData)
+		require.NoError(t, json.Unmarshal(seedBody, &seed))
+		err = mem.UpsertSession(context.Background(), site.ID, seed)
+		require.NoError(t, err, "Error storing test session")
 
 		UpdatedSessionData := map[string]interface{}{
 			"sessionId":        sessionData["sessionId"], // Use the same sessionId for update
 			"lastActivityTime": "2024-10-02T22:44:05Z",
 			"userId":           sessionData["userId"],
-			"sessionDuration":  120,
+			"sessionDuration":  2765,
 			"userAgent":        "Mozilla/5.0",
 			"referrer":         "http://google.com",
 			"token":            "abcdefg",
@@ -226,10 +201,11 @@ func TestPostSessionData(t *testing.T) {
 		}
 
 		body, _ := json.Marshal(UpdatedSessionData)
-		req := httptest.NewRequest(http.MethodPost, "/postSession", bytes.NewBuffer(body))
+		req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
 		w := httptest.NewRecorder()
 
-		handlers.PostSessionData(w, req)
+		h.PostSessionData(w, req)
 
 		resp := w.Result()
 		defer resp.Body.Close()
@@ -239,20 +215,104 @@ func TestPostSessionData(t *testing.T) {
 		}
 
 		// Verify session is updated
-		var updatedActivityTime string
-		row := db.DB.QueryRow("SELECT last_activity_time FROM sessions WHERE session_id = $1", sessionData["sessionId"])
-		err = row.Scan(&updatedActivityTime)
-		if err != nil || updatedActivityTime == sessionData["lastActivityTime"] {
-			t.Errorf("Expected last_activity_time to change from '%v', to '%v'", sessionData["lastActivityTime"], updatedActivityTime)
+		stored, ok := mem.Session(sessionData["sessionId"].(string))
+		require.True(t, ok)
+		assert.Equal(t, "2024-10-02T22:44:05Z", stored.Session.LastActivityTime.UTC().Format(time.RFC3339))
+		assert.Equal(t, int64(2765), *stored.Session.SessionDuration)
+	})
+
+	// A beacon that arrives late must not rewind the session
+	t.Run("StaleBeaconDoesNotRewind", func(t *testing.T) {
+		stale := map[string]interface{}{
+			"sessionId":        "c2f5d0a4-7b1e-4f0c-8a6d-3e9b2d4f6a18",
+			"lastActivityTime": "2024-10-02T22:10:00Z",
+			"sessionDuration":  600,
+			"language":         "de",
 		}
+
+		body, _ := json.Marshal(stale)
+		req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stored, ok := mem.Session(stale["sessionId"].(string))
+		require.True(t, ok)
+		assert.Equal(t, "2024-10-02T22:44:05Z", stored.Session.LastActivityTime.UTC().Format(time.RFC3339))
+		assert.Equal(t, int64(2765), *stored.Session.SessionDuration)
+		assert.Equal(t, "en", *stored.Session.Language, "The first seen language should be kept")
+	})
+
+	// Concurrent beacons for one session must end up as a single row with the furthest progress
+	t.Run("ConcurrentBeacons", func(t *testing.T) {
+		sessionID := "e8a1c3b5-4d2f-4e6a-9b7c-0f1d2e3a4b5c"
+		start := time.Date(2024, 10, 5, 12, 0, 0, 0, time.UTC)
+
+		var wg sync.WaitGroup
+		codes := make(chan int, 50)
+		for i := 1; i <= 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				beacon := map[string]interface{}{
+					"sessionId":        sessionID,
+					"lastActivityTime": start.Add(time.Duration(i) * time.Second).Format(time.RFC3339),
+					"sessionDuration":  i * 1000,
+					"startTime":        start.Format(time.RFC3339),
+					"language":         "en",
+				}
+
+				body, _ := json.Marshal(beacon)
+				req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(body))
+				req.Header.Set("Origin", "http://example.com")
+				w := httptest.NewRecorder()
+
+				h.PostSessionData(w, req)
+				codes <- w.Code
+			}(i)
+		}
+		wg.Wait()
+		close(codes)
+
+		for code := range codes {
+			assert.Equal(t, http.StatusOK, code)
+		}
+
+		stored, ok := mem.Session(sessionID)
+		require.True(t, ok)
+		assert.Equal(t, int64(50000), *stored.Session.SessionDuration)
+		assert.Equal(t, start.Add(50*time.Second), stored.Session.LastActivityTime.UTC())
+	})
+
+	t.Run("SessionOfAnotherSite", func(t *testing.T) {
+		other, err := sites.Create(context.Background(), mem, "other", []string{"http://other.example.com"})
+		require.NoError(t, err, "Failed to create site")
+
+		body, _ := json.Marshal(sessionData)
+		req := httptest.NewRequest(http.MethodPost, "/postSession?token="+other.TrackingToken, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://other.example.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		stored, ok := mem.Session(sessionData["sessionId"].(string))
+		require.True(t, ok)
+		assert.Equal(t, site.ID, stored.SiteID)
 	})
 
 	// Preflight request (OPTIONS method)
 	t.Run("PreflightRequest", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodOptions, "/postSession", nil)
+		req := httptest.NewRequest(http.MethodOptions, postSessionURL, nil)
+		req.Header.Set("Origin", "http://example.com")
 		w := httptest.NewRecorder()
 
-		handlers.PostSessionData(w, req)
+		h.PostSessionData(w, req)
 
 		resp := w.Result()
 		defer resp.Body.Close()
@@ -260,6 +320,27 @@ func TestPostSessionData(t *testing.T) {
 		if resp.StatusCode != http.StatusOK {
 			t.Errorf("Expected status 200 OK, got %v", resp.StatusCode)
 		}
+
+		if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "http://example.com" {
+			t.Errorf("Expected CORS origin http://example.com, got %v", origin)
+		}
+	})
+
+	// Origin not allowed for the site's token
+	t.Run("ForbiddenOrigin", func(t *testing.T) {
+		body, _ := json.Marshal(sessionData)
+		req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://other.com")
+		w := httptest.NewRecorder()
+
+		h.PostSessionData(w, req)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status 403 Forbidden, got %v", resp.StatusCode)
+		}
 	})
 
 	// Method Not Allowed (non-POST)
@@ -267,7 +348,7 @@ func TestPostSessionData(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/postSession", nil)
 		w := httptest.NewRecorder()
 
-		handlers.PostSessionData(w, req)
+		h.PostSessionData(w, req)
 
 		resp := w.Result()
 		defer resp.Body.Close()
@@ -280,10 +361,11 @@ func TestPostSessionData(t *testing.T) {
 	// Bad Request for invalid JSON
 	t.Run("BadRequestInvalidJSON", func(t *testing.T) {
 		invalidJSON := []byte(`{invalid json}`)
-		req := httptest.NewRequest(http.MethodPost, "/postSession", bytes.NewBuffer(invalidJSON))
+		req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(invalidJSON))
+		req.Header.Set("Origin", "http://example.com")
 		w := httptest.NewRecorder()
 
-		handlers.PostSessionData(w, req)
+		h.PostSessionData(w, req)
 
 		resp := w.Result()
 		defer resp.Body.Close()
@@ -299,10 +381,11 @@ func TestPostSessionData(t *testing.T) {
 		delete(sessionDataWithoutID, "sessionId")
 
 		body, _ := json.Marshal(sessionDataWithoutID)
-		req := httptest.NewRequest(http.MethodPost, "/postSession", bytes.NewBuffer(body))
+		req := httptest.NewRequest(http.MethodPost, postSessionURL, bytes.NewBuffer(body))
+		req.Header.Set("Origin", "http://example.com")
 		w := httptest.NewRecorder()
 
-		handlers.PostSessionData(w, req)
+		h.PostSessionData(w, req)
 
 		resp := w.Result()
 		defer resp.Body.Close()
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"Borea/backend/bots"
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"Borea/backend/useragent"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	iPhoneSafari  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1"
+	iPadSafari    = "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1"
+	windowsChrome = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	googlebot     = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	deref := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	}
+
+	cases := map[string]struct {
+		raw     string
+		browser string
+		os      string
+		device  string
+	}{
+		"MobileSafari":  {iPhoneSafari, "Safari", "iOS", useragent.DeviceMobile},
+		"Tablet":        {iPadSafari, "Safari", "iOS", useragent.DeviceTablet},
+		"DesktopChrome": {windowsChrome, "Chrome", "Windows", useragent.DeviceDesktop},
+		"Bot":           {googlebot, "Googlebot", "", useragent.DeviceBot},
+		"Empty":         {"  ", "", "", ""},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			client := useragent.Parse(c.raw)
+			assert.Equal(t, c.browser, deref(client.Browser))
+			assert.Equal(t, c.os, deref(client.OS))
+			assert.Equal(t, c.device, deref(client.Device))
+		})
+	}
+
+	t.Run("Versions", func(t *testing.T) {
+		client := useragent.Parse(iPhoneSafari)
+		assert.Equal(t, "17.1", deref(client.BrowserVersion))
+		assert.Equal(t, "17.1", deref(client.OSVersion))
+	})
+}
+
+func TestSessionUserAgentIsParsedOnIngest(t *testing.T) {
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	site, err := sites.Create(context.Background(), mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	sessionID := "4b7e9d21-6c3a-4f85-b0e2-d19a8c7f6e54"
+	body, _ := json.Marshal(map[string]interface{}{
+		"sessionId":        sessionID,
+		"lastActivityTime": "2024-10-01T10:00:00Z",
+		"userAgent":        iPhoneSafari,
+		// Parsed columns are the server's, a beacon cannot set them
+		"client": map[string]string{"device": "desktop"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/postSession?token="+site.TrackingToken, bytes.NewBuffer(body))
+	req.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+
+	h.PostSessionData(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored, ok := mem.Session(sessionID)
+	require.True(t, ok)
+	require.NotNil(t, stored.Session.Client.Device)
+	assert.Equal(t, useragent.DeviceMobile, *stored.Session.Client.Device)
+
+	req = httptest.NewRequest(http.MethodGet, "/analytics/devices?from=2024-10-01&to=2024-10-01", nil)
+	w = httptest.NewRecorder()
+
+	h.GetDevices(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var rows []models.Breakdown_row
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rows))
+	assert.Equal(t, []models.Breakdown_row{{Value: useragent.DeviceMobile, Count: 1}}, rows)
+}
+
+func TestBackfillUserAgents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s store.Store) {
+		ctx := context.Background()
+
+		site, err := s.CreateSite(ctx, models.Site{Name: "example", TrackingToken: "example-token", AllowedOrigins: []string{"http://example.com"}, BotPolicy: bots.PolicyFlag})
+		require.NoError(t, err)
+
+		activity := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		// Stored as sessions were before the backend parsed user agents
+		for i, raw := range []string{iPhoneSafari, iPhoneSafari, windowsChrome, "not a browser", googlebot} {
+			raw := raw
+			session := models.Session{SessionID: "6f1a2b3c-4d5e-4f60-8a9b-0c1d2e3f4a5" + string(rune('0'+i)), LastActivityTime: &activity, UserAgent: &raw}
+			require.NoError(t, s.UpsertSession(ctx, site.ID, session))
+		}
+
+		// A batch of one makes the backfill page past the user agent it cannot parse
+		updated, err := useragent.Backfill(ctx, s, 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), updated)
+
+		p := analyticsParams(t, "2024-10-01", "2024-10-01", site.ID)
+
+		devices, err := s.Devices(ctx, p)
+		require.NoError(t, err)
+		// Ties are ordered by the database collation, so only the leader's position is checked
+		require.NotEmpty(t, devices)
+		assert.Equal(t, models.Breakdown_row{Value: useragent.DeviceMobile, Count: 2}, devices[0])
+		assert.ElementsMatch(t, []models.Breakdown_row{
+			{Value: useragent.DeviceMobile, Count: 2},
+			{Value: "(unknown)", Count: 1},
+			{Value: useragent.DeviceBot, Count: 1},
+			{Value: useragent.DeviceDesktop, Count: 1},
+		}, devices)
+
+		operatingSystems, err := s.OperatingSystems(ctx, p)
+		require.NoError(t, err)
+		assert.Contains(t, operatingSystems, models.Breakdown_row{Value: "iOS", Count: 2})
+
+		// Only the unparseable user agent is left, running again finds nothing new to parse
+		remaining, err := s.UnparsedUserAgents(ctx, "", 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"not a browser"}, remaining)
+	})
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"Borea/backend/handlers"
+	"Borea/backend/models"
+	"Borea/backend/sites"
+	"Borea/backend/store"
+	"Borea/backend/webhooks"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver stands in for the systems webhooks notify, answering with the queued status codes
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestWebhookWorker(t *testing.T) {
+	ctx := context.Background()
+
+	setUp := func(t *testing.T, webhook models.Webhook, statuses ...int) (*store.Memory, *receiver, models.Webhook) {
+		mem := store.NewMemory()
+		site, err := sites.Create(ctx, mem, "example", []string{"http://example.com"})
+		require.NoError(t, err, "Failed to create site")
+
+		rc := &receiver{statuses: statuses}
+		server := httptest.NewServer(rc)
+		t.Cleanup(server.Close)
+
+		webhook.SiteID = site.ID
+		webhook.URL = server.URL + "/hooks/borea"
+		created, err := webhooks.Create(ctx, mem, webhook)
+		require.NoError(t, err)
+		require.NotEmpty(t, created.Secret)
+		return mem, rc, created
+	}
+
+	signup := models.Event{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-111111111111", Name: "signup", PageURL: "http://example.com/join", Properties: json.RawMessage(`{"plan":"pro"}`)}
+
+	t.Run("DeliversSignedEvents", func(t *testing.T) {
+		mem, rc, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeEvent}, Events: []string{"signup"}})
+		worker := webhooks.NewWorker(mem, webhooks.DefaultConfig())
+
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, models.Event{SessionID: signup.SessionID, Name: "click"}))
+		require.NoError(t, mem.UpsertSession(ctx, webhook.SiteID, models.Session{SessionID: signup.SessionID}))
+
+		sent, err := worker.Run(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 1, sent, "Only the filtered event is delivered")
+
+		require.Len(t, rc.requests, 1)
+		req, body := rc.requests[0], rc.bodies[0]
+		assert.Equal(t, "/hooks/borea", req.URL.Path)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		assert.Equal(t, webhooks.TypeEvent, req.Header.Get("X-Borea-Event"))
+
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		assert.Equal(t, "event", payload["type"])
+		assert.Equal(t, "signup", payload["name"])
+		assert.Equal(t, float64(webhook.SiteID), payload["site_id"])
+		assert.Equal(t, map[string]interface{}{"plan": "pro"}, payload["properties"])
+
+		// t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">
+		timestamp, signature, ok := strings.Cut(req.Header.Get("X-Borea-Signature"), ",v1=")
+		require.True(t, ok)
+		mac := hmac.New(sha256.New, []byte(webhook.Secret))
+		mac.Write([]byte(strings.TrimPrefix(timestamp, "t=") + "."))
+		mac.Write(body)
+		assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), signature)
+
+		deliveries, err := mem.WebhookDeliveries(ctx, webhook.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, "delivered", deliveries[0].Status)
+		assert.Equal(t, 1, deliveries[0].Attempts)
+		assert.Equal(t, http.StatusOK, *deliveries[0].StatusCode)
+		assert.NotNil(t, deliveries[0].DeliveredAt)
+		assert.Nil(t, deliveries[0].NextAttemptAt)
+		assert.Equal(t, req.Header.Get("X-Borea-Delivery"), "1")
+
+		sent, err = worker.Run(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, 0, sent, "A delivered delivery is not sent again")
+	})
+
+	t.Run("NotifiesNewHumanSessions", func(t *testing.T) {
+		mem, rc, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeSessionStarted}})
+		worker := webhooks.NewWorker(mem, webhooks.DefaultConfig())
+
+		start := time.Date(2024, 10, 1, 10, 0, 0, 0, time.UTC)
+		referrer := "http://google.com"
+		session := models.Session{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-222222222222", StartTime: &start, Referrer: &referrer}
+		require.NoError(t, mem.UpsertSession(ctx, webhook.SiteID, session))
+		require.NoError(t, mem.UpsertSession(ctx, webhook.SiteID, session), "A later beacon is not a new session")
+		require.NoError(t, mem.UpsertSession(ctx, webhook.SiteID, models.Session{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-333333333333", IsBot: true}))
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+
+		sent, err := worker.Run(ctx, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, 1, sent)
+
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(rc.bodies[0], &payload))
+		assert.Equal(t, "session.started", payload["type"])
+		assert.Equal(t, session.SessionID, payload["session_id"])
+		assert.Equal(t, "2024-10-01T10:00:00.000000Z", payload["start_time"])
+		assert.Equal(t, referrer, payload["referrer"])
+		assert.Nil(t, payload["user_id"])
+	})
+
+	t.Run("RetriesWithBackoff", func(t *testing.T) {
+		mem, rc, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeEvent}}, http.StatusInternalServerError, http.StatusOK)
+		config := webhooks.DefaultConfig()
+		worker := webhooks.NewWorker(mem, config)
+
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+
+		now := time.Now()
+		_, err := worker.Run(ctx, now)
+		require.NoError(t, err)
+
+		deliveries, err := mem.WebhookDeliveries(ctx, webhook.ID, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, "pending", deliveries[0].Status)
+		assert.Equal(t, http.StatusInternalServerError, *deliveries[0].StatusCode)
+		assert.Contains(t, *deliveries[0].Error, "500")
+		require.NotNil(t, deliveries[0].NextAttemptAt)
+		assert.WithinDuration(t, time.Now().Add(config.Backoff), *deliveries[0].NextAttemptAt, 5*time.Second)
+
+		sent, err := worker.Run(ctx, now.Add(config.Backoff/2))
+		require.NoError(t, err)
+		assert.Equal(t, 0, sent, "Not due before the backoff has passed")
+
+		sent, err = worker.Run(ctx, now.Add(config.Backoff+10*time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, 1, sent)
+		assert.Len(t, rc.requests, 2)
+
+		deliveries, err = mem.WebhookDeliveries(ctx, webhook.ID, 10)
+		require.NoError(t, err)
+		assert.Equal(t, "delivered", deliveries[0].Status)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.Nil(t, deliveries[0].Error)
+	})
+
+	t.Run("FailsAfterMaxAttempts", func(t *testing.T) {
+		mem, _, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeEvent}}, http.StatusBadGateway, http.StatusMovedPermanently)
+		config := webhooks.DefaultConfig()
+		config.MaxAttempts = 2
+		worker := webhooks.NewWorker(mem, config)
+
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+
+		_, err := worker.Run(ctx, time.Now())
+		require.NoError(t, err)
+		_, err = worker.Run(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		deliveries, err := mem.WebhookDeliveries(ctx, webhook.ID, 10)
+		require.NoError(t, err)
+		assert.Equal(t, "failed", deliveries[0].Status)
+		assert.Equal(t, http.StatusMovedPermanently, *deliveries[0].StatusCode, "Redirects are not followed")
+		assert.Nil(t, deliveries[0].NextAttemptAt)
+
+		pruned, err := worker.Prune(ctx, time.Now().AddDate(0, 0, config.LogDays+1))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), pruned)
+	})
+
+	t.Run("SkipsEventsOfBots", func(t *testing.T) {
+		mem, rc, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeEvent}})
+		worker := webhooks.NewWorker(mem, webhooks.DefaultConfig())
+
+		crawler := "0b1c6c9e-6f5e-4f3a-8c7e-444444444444"
+		require.NoError(t, mem.UpsertSession(ctx, webhook.SiteID, models.Session{SessionID: crawler, IsBot: true}))
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, models.Event{SessionID: crawler, Name: "signup"}))
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+
+		sent, err := worker.Run(ctx, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, 1, sent, "Events of a session not stored yet are sent, events of bots are not")
+
+		var payload map[string]interface{}
+		require.NoError(t, json.Unmarshal(rc.bodies[0], &payload))
+		assert.Equal(t, signup.SessionID, payload["session_id"])
+	})
+
+	t.Run("ZeroConfigUsesDefaults", func(t *testing.T) {
+		mem, _, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeEvent}}, http.StatusInternalServerError)
+		worker := webhooks.NewWorker(mem, webhooks.Config{})
+
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+
+		now := time.Now()
+		_, err := worker.Run(ctx, now)
+		require.NoError(t, err)
+
+		deliveries, err := mem.WebhookDeliveries(ctx, webhook.ID, 10)
+		require.NoError(t, err)
+		assert.Equal(t, "pending", deliveries[0].Status, "A failed first attempt is retried")
+		require.NotNil(t, deliveries[0].NextAttemptAt)
+		assert.WithinDuration(t, now.Add(webhooks.DefaultConfig().Backoff), *deliveries[0].NextAttemptAt, 5*time.Second)
+	})
+
+	t.Run("UnreachableReceiver", func(t *testing.T) {
+		mem, _, webhook := setUp(t, models.Webhook{Types: []string{webhooks.TypeEvent}})
+		require.NoError(t, mem.DeleteWebhook(ctx, webhook.ID))
+
+		webhook.URL = "http://127.0.0.1:1/closed"
+		webhook, err := webhooks.Create(ctx, mem, webhook)
+		require.NoError(t, err)
+		require.NoError(t, mem.InsertEvent(ctx, webhook.SiteID, signup))
+
+		_, err = webhooks.NewWorker(mem, webhooks.DefaultConfig()).Run(ctx, time.Now())
+		require.NoError(t, err)
+
+		deliveries, err := mem.WebhookDeliveries(ctx, webhook.ID, 10)
+		require.NoError(t, err)
+		assert.Equal(t, "pending", deliveries[0].Status)
+		assert.Nil(t, deliveries[0].StatusCode, "No response, no status code")
+		assert.NotNil(t, deliveries[0].Error)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		config := webhooks.Config{MaxAttempts: 12, Backoff: time.Minute}
+		at := time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC)
+
+		assert.Equal(t, at.Add(time.Minute), *config.NextAttempt(1, at))
+		assert.Equal(t, at.Add(4*time.Minute), *config.NextAttempt(3, at))
+		assert.Equal(t, at.Add(6*time.Hour), *config.NextAttempt(11, at), "The wait is capped")
+		assert.Nil(t, config.NextAttempt(12, at))
+	})
+
+	t.Run("ConfigFromEnv", func(t *testing.T) {
+		t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+		t.Setenv("WEBHOOK_TIMEOUT_SECONDS", "2")
+
+		config, err := webhooks.ConfigFromEnv()
+		require.NoError(t, err)
+		assert.Equal(t, 3, config.MaxAttempts)
+		assert.Equal(t, 2*time.Second, config.Timeout)
+		assert.Equal(t, webhooks.DefaultConfig().Interval, config.Interval)
+
+		t.Setenv("WEBHOOK_LOG_DAYS", "-1")
+		_, err = webhooks.ConfigFromEnv()
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookHandlers(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	h := handlers.New(mem, nil, nil, nil)
+
+	os.Setenv("DOMAIN", "http://example.com")
+
+	site, err := sites.Create(ctx, mem, "example", []string{"http://example.com"})
+	require.NoError(t, err, "Failed to create site")
+
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/createWebhook", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		h.CreateWebhook(w, req)
+		return w
+	}
+
+	w := create(`{"siteId": 1, "url": "https://hooks.example.com/borea", "types": ["event"], "events": ["signup"]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var created models.Webhook
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, site.ID, created.SiteID)
+	assert.Len(t, created.Secret, 64, "The secret is shown once")
+
+	invalid := map[string]string{
+		"RelativeURL":  `{"siteId": 1, "url": "/hooks", "types": ["event"]}`,
+		"FTP":          `{"siteId": 1, "url": "ftp://hooks.example.com", "types": ["event"]}`,
+		"NoTypes":      `{"siteId": 1, "url": "https://hooks.example.com"}`,
+		"UnknownType":  `{"siteId": 1, "url": "https://hooks.example.com", "types": ["pageview"]}`,
+		"EventsFilter": `{"siteId": 1, "url": "https://hooks.example.com", "types": ["session.started"], "events": ["signup"]}`,
+		"UnknownSite":  `{"siteId": 7, "url": "https://hooks.example.com", "types": ["event"]}`,
+		"NotJSON":      `url=https://hooks.example.com`,
+	}
+	for name, body := range invalid {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusBadRequest, create(body).Code)
+		})
+	}
+
+	t.Run("ListHidesSecrets", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/getWebhooks?site=1", nil)
+		w := httptest.NewRecorder()
+		h.GetWebhooks(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), created.Secret)
+
+		var list []models.Webhook
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		require.Len(t, list, 1)
+		assert.Equal(t, []string{"signup"}, list[0].Events)
+	})
+
+	t.Run("DeliveryLog", func(t *testing.T) {
+		require.NoError(t, mem.InsertEvent(ctx, site.ID, models.Event{SessionID: "0b1c6c9e-6f5e-4f3a-8c7e-111111111111", Name: "signup"}))
+
+		req := httptest.NewRequest(http.MethodGet, "/getWebhookDeliveries?webhook=1", nil)
+		w := httptest.NewRecorder()
+		h.GetWebhookDeliveries(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var deliveries []models.Webhook_delivery
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &deliveries))
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, "pending", deliveries[0].Status)
+
+		req = httptest.NewRequest(http.MethodGet, "/getWebhookDeliveries?webhook=1&limit=1000", nil)
+		w = httptest.NewRecorder()
+		h.GetWebhookDeliveries(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		deleteWebhook := func(id string) int {
+			req := httptest.NewRequest(http.MethodPost, "/deleteWebhook", bytes.NewBufferString(`{"id": `+id+`}`))
+			w := httptest.NewRecorder()
+			h.DeleteWebhook(w, req)
+			return w.Code
+		}
+
+		assert.Equal(t, http.StatusOK, deleteWebhook("1"))
+		assert.Equal(t, http.StatusNotFound, deleteWebhook("1"))
+
+		deliveries, err := mem.WebhookDeliveries(ctx, 1, 10)
+		require.NoError(t, err)
+		assert.Empty(t, deliveries, "Deliveries go with their webhook")
+	})
+}
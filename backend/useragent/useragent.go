@@ -0,0 +1,96 @@
+// Package useragent parses the raw navigator.userAgent a beacon sends into the browser, OS and
+// device class the dashboard groups sessions by.
+package useragent
+
+import (
+	"context"
+	"strings"
+
+	"Borea/backend/models"
+	"Borea/backend/store"
+
+	ua "github.com/mileusna/useragent"
+)
+
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// Parse reads a raw user agent, anything it cannot recognise is left nil
+func Parse(raw string) models.User_agent {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return models.User_agent{}
+	}
+
+	parsed := ua.Parse(raw)
+
+	return models.User_agent{
+		Browser:        optional(parsed.Name),
+		BrowserVersion: optional(parsed.Version),
+		OS:             optional(parsed.OS),
+		OSVersion:      optional(parsed.OSVersion),
+		Device:         optional(device(parsed)),
+	}
+}
+
+// Apply sets the parsed client of a session from its user agent
+func Apply(session *models.Session) {
+	if session.UserAgent == nil {
+		return
+	}
+	session.Client = Parse(*session.UserAgent)
+}
+
+// Backfill parses the user agents of sessions stored before parsing happened on ingest.
+// Each distinct user agent is parsed once, and it returns the number of sessions updated.
+func Backfill(ctx context.Context, sessions store.UserAgentBackfill, batchSize int) (int64, error) {
+	var updated int64
+	after := ""
+
+	for {
+		userAgents, err := sessions.UnparsedUserAgents(ctx, after, batchSize)
+		if err != nil {
+			return updated, err
+		}
+		if len(userAgents) == 0 {
+			return updated, nil
+		}
+
+		for _, userAgent := range userAgents {
+			count, err := sessions.SetClient(ctx, userAgent, Parse(userAgent))
+			if err != nil {
+				return updated, err
+			}
+			updated += count
+		}
+
+		after = userAgents[len(userAgents)-1]
+	}
+}
+
+// device classifies bots first, since crawlers often claim to be a mobile browser
+func device(parsed ua.UserAgent) string {
+	switch {
+	case parsed.Bot:
+		return DeviceBot
+	case parsed.Tablet:
+		return DeviceTablet
+	case parsed.Mobile:
+		return DeviceMobile
+	case parsed.Desktop:
+		return DeviceDesktop
+	default:
+		return ""
+	}
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
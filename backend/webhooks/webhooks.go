@@ -0,0 +1,97 @@
+// Package webhooks lets other systems react to what Borea tracks. The store queues a delivery
+// for every webhook a new session or event concerns, and the Worker POSTs them as JSON:
+//
+//	{"type": "session.started", "site_id": 1, "session_id": "...", "user_id": ..., "start_time": ...,
+//	 "referrer": ..., "language": ..., "country": ..., "channel": ..., "utm_source": ..., "utm_medium": ..., "utm_campaign": ...}
+//	{"type": "event", "site_id": 1, "session_id": "...", "name": "signup", "timestamp": ..., "page_url": ..., "properties": {...}}
+//
+// Every request carries the delivery id in X-Borea-Delivery, the type in X-Borea-Event and
+// X-Borea-Signature: t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>" keyed with the
+// webhook's secret>. Receivers should check the signature and that t is recent, and dedupe on
+// the delivery id since a delivery can arrive more than once.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+const (
+	TypeSessionStarted = "session.started"
+	TypeEvent          = "event"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+var types = []string{TypeSessionStarted, TypeEvent}
+
+// Validate checks a webhook before it is stored, the returned error wraps ErrInvalidWebhook
+func Validate(webhook models.Webhook) error {
+	if webhook.SiteID < 1 {
+		return fmt.Errorf("%w: siteId is required", ErrInvalidWebhook)
+	}
+
+	target, err := url.Parse(webhook.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+
+	if len(webhook.Types) == 0 {
+		return fmt.Errorf("%w: types is required", ErrInvalidWebhook)
+	}
+	for _, t := range webhook.Types {
+		if !slices.Contains(types, t) {
+			return fmt.Errorf("%w: unknown type %q", ErrInvalidWebhook, t)
+		}
+	}
+
+	if len(webhook.Events) > 0 && !slices.Contains(webhook.Types, TypeEvent) {
+		return fmt.Errorf("%w: events filters %q notifications, which types does not include", ErrInvalidWebhook, TypeEvent)
+	}
+	for _, name := range webhook.Events {
+		if name == "" {
+			return fmt.Errorf("%w: event names cannot be empty", ErrInvalidWebhook)
+		}
+	}
+
+	return nil
+}
+
+// Create validates and stores a webhook with a generated secret, which is only returned here
+func Create(ctx context.Context, webhooks store.WebhookStore, webhook models.Webhook) (models.Webhook, error) {
+	if err := Validate(webhook); err != nil {
+		return models.Webhook{}, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return models.Webhook{}, fmt.Errorf("generating webhook secret: %w", err)
+	}
+	webhook.Secret = hex.EncodeToString(secret)
+
+	return webhooks.CreateWebhook(ctx, webhook)
+}
+
+// Sign returns the X-Borea-Signature of a request body sent at at
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,305 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"Borea/backend/helper"
+	"Borea/backend/logging"
+	"Borea/backend/models"
+	"Borea/backend/store"
+)
+
+const (
+	// maxBackoff caps the wait between two attempts of a delivery
+	maxBackoff = 6 * time.Hour
+	// pruneInterval is how often the delivery log is pruned
+	pruneInterval = time.Hour
+	// Response bodies are read up to this size so the connection can be reused, and ignored
+	maxResponseBody = 64 << 10
+	maxErrorLength  = 500
+)
+
+type Config struct {
+	Interval    time.Duration // How often due deliveries are looked for
+	Timeout     time.Duration // Of one request
+	MaxAttempts int           // A delivery fails after this many attempts
+	Backoff     time.Duration // Wait after the first failed attempt, doubled after every further one
+	BatchSize   int           // Deliveries claimed at once
+	Concurrency int           // Requests sent at once
+	LogDays     int           // Days delivered and failed deliveries stay in the delivery log
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval:    5 * time.Second,
+		Timeout:     10 * time.Second,
+		MaxAttempts: 8,
+		Backoff:     30 * time.Second,
+		BatchSize:   50,
+		Concurrency: 4,
+		LogDays:     30,
+	}
+}
+
+// ConfigFromEnv reads WEBHOOK_INTERVAL_SECONDS, WEBHOOK_TIMEOUT_SECONDS, WEBHOOK_MAX_ATTEMPTS,
+// WEBHOOK_CONCURRENCY and WEBHOOK_LOG_DAYS
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	interval := int(config.Interval / time.Second)
+	if err := helper.PositiveIntFromEnv("WEBHOOK_INTERVAL_SECONDS", &interval); err != nil {
+		return config, err
+	}
+	config.Interval = time.Duration(interval) * time.Second
+
+	timeout := int(config.Timeout / time.Second)
+	if err := helper.PositiveIntFromEnv("WEBHOOK_TIMEOUT_SECONDS", &timeout); err != nil {
+		return config, err
+	}
+	config.Timeout = time.Duration(timeout) * time.Second
+
+	if err := helper.PositiveIntFromEnv("WEBHOOK_MAX_ATTEMPTS", &config.MaxAttempts); err != nil {
+		return config, err
+	}
+	if err := helper.PositiveIntFromEnv("WEBHOOK_CONCURRENCY", &config.Concurrency); err != nil {
+		return config, err
+	}
+	if err := helper.PositiveIntFromEnv("WEBHOOK_LOG_DAYS", &config.LogDays); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// NextAttempt is when to retry a delivery that failed its attempts-th attempt at at,
+// nil once it has had MaxAttempts
+func (c Config) NextAttempt(attempts int, at time.Time) *time.Time {
+	if attempts >= c.MaxAttempts {
+		return nil
+	}
+
+	wait := c.Backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	next := at.Add(min(wait, maxBackoff))
+	return &next
+}
+
+// Worker sends the queued deliveries in the background. Every backend runs one, the store
+// makes sure a delivery is only claimed by one of them at a time.
+type Worker struct {
+	store  store.WebhookStore
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	started   bool
+	stop      chan struct{}
+	done      chan struct{}
+	cancel    context.CancelFunc
+	lastPrune time.Time
+}
+
+func NewWorker(s store.WebhookStore, config Config) *Worker {
+	defaults := DefaultConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaults.Backoff
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaults.Concurrency
+	}
+
+	return &Worker{
+		store:  s,
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			// A redirect counts as a failed attempt, the payload is only sent where it was registered to go
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Run sends the deliveries due at now, up to BatchSize, and returns how many it attempted.
+// An attempt cut short by ctx is not recorded, the delivery is sent again once its lease ends.
+func (w *Worker) Run(ctx context.Context, now time.Time) (int, error) {
+	deliveries, err := w.store.ClaimDeliveries(ctx, now, w.config.Timeout+time.Minute, w.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, w.config.Concurrency)
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery models.Webhook_delivery) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			attempt := w.send(ctx, delivery)
+			if ctx.Err() != nil {
+				return
+			}
+			if err := w.store.RecordAttempt(context.WithoutCancel(ctx), attempt); err != nil {
+				logging.FromContext(ctx).Error("recording webhook delivery", "delivery", delivery.ID, "err", err)
+			}
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+func (w *Worker) send(ctx context.Context, delivery models.Webhook_delivery) models.Delivery_attempt {
+	attempt := models.Delivery_attempt{DeliveryID: delivery.ID, At: time.Now()}
+	fail := func(message string) models.Delivery_attempt {
+		if len(message) > maxErrorLength {
+			message = message[:maxErrorLength]
+		}
+		attempt.Error = &message
+		attempt.NextAttemptAt = w.config.NextAttempt(delivery.Attempts+1, attempt.At)
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fail(err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Borea-Webhooks/1.0")
+	req.Header.Set("X-Borea-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Borea-Event", delivery.Type)
+	req.Header.Set("X-Borea-Signature", Sign(delivery.Secret, attempt.At, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fail(err.Error())
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+	resp.Body.Close()
+
+	attempt.StatusCode = &resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fail(fmt.Sprintf("receiver answered %s", resp.Status))
+	}
+
+	attempt.Delivered = true
+	return attempt
+}
+
+// Prune deletes what the delivery log holds beyond LogDays
+func (w *Worker) Prune(ctx context.Context, now time.Time) (int64, error) {
+	return w.store.PruneDeliveries(ctx, now.AddDate(0, 0, -w.config.LogDays))
+}
+
+// Start sends deliveries every Interval, and right away while there are more due than a batch
+func (w *Worker) Start() {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.started {
+		return
+	}
+	w.started = true
+
+	ctx, cancel := context.WithCancel(logging.NewContext(context.Background(), slog.With("component", "webhooks")))
+	w.cancel = cancel
+	go w.loop(ctx)
+}
+
+// Close stops the worker and waits for the requests in flight, or cancels them once ctx is done
+func (w *Worker) Close(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	started := w.started
+	if started {
+		select {
+		case <-w.stop:
+		default:
+			close(w.stop)
+		}
+	}
+	w.mu.Unlock()
+
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+		return fmt.Errorf("webhook deliveries cancelled: %w", ctx.Err())
+	}
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	defer close(w.done)
+	defer w.cancel()
+
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		sent, err := w.Run(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("sending webhook deliveries", "err", err)
+		}
+
+		if now := time.Now(); now.Sub(w.lastPrune) >= pruneInterval {
+			w.lastPrune = now
+			if _, err := w.Prune(ctx, now); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Error("pruning webhook deliveries", "err", err)
+			}
+		}
+
+		// A full batch means more are probably due
+		if sent == w.config.BatchSize {
+			select {
+			case <-w.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,22 @@
+import jwt from 'jsonwebtoken';
+const SERVER_KEY = process.env.SERVER_KEY;
+
+// The Go backend requires a JWT signed with SERVER_KEY on its data endpoints. Calls made for
+// a logged in user pass on their session token, so nothing reaches the backend without a login.
+export function backendHeaders(session: string): Record<string, string> {
+	return {
+		'Content-Type': 'application/json',
+		Authorization: `Bearer ${session}`
+	};
+}
+
+// The login route has to look up the admin user before anyone is logged in. Its token only
+// carries the login scope, which the backend accepts on /getAdminUser and nowhere else.
+export function loginHeaders(): Record<string, string> {
+	const token = jwt.sign({ scope: 'login' }, SERVER_KEY ?? '', { expiresIn: '1m' });
+
+	return {
+		'Content-Type': 'application/json',
+		Authorization: `Bearer ${token}`
+	};
+}
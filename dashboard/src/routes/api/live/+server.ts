@@ -0,0 +1,46 @@
+// src/routes/api/live/+server.ts
+import type { RequestHandler } from './$types';
+import jwt from 'jsonwebtoken';
+import { backendHeaders } from '$lib/server/backend';
+const HOST_ADDRESS = process.env.HOST_ADDRESS;
+const GO_PORT = process.env.GO_PORT;
+const SERVER_KEY = process.env.SERVER_KEY;
+
+// EventSource cannot send an Authorization header, so the browser opens the live view here
+// and the stream from the backend is passed through as is.
+export const GET: RequestHandler = async ({ url, cookies, request, fetch }) => {
+	const session = cookies.get('session') ?? '';
+	try {
+		jwt.verify(session, SERVER_KEY ?? '');
+	} catch {
+		return new Response('Unauthorized', { status: 401 });
+	}
+
+	const params = new URLSearchParams();
+	const site = url.searchParams.get('site');
+	if (site) {
+		params.set('site', site);
+	}
+
+	try {
+		const backendResponse = await fetch(`http://${HOST_ADDRESS}:${GO_PORT}/live?${params}`, {
+			headers: backendHeaders(session),
+			signal: request.signal
+		});
+
+		if (!backendResponse.ok || !backendResponse.body) {
+			throw new Error(`Backend responded with status: ${backendResponse.status}`);
+		}
+
+		return new Response(backendResponse.body, {
+			headers: {
+				'Content-Type': 'text/event-stream',
+				'Cache-Control': 'no-cache',
+				'X-Accel-Buffering': 'no'
+			}
+		});
+	} catch (error) {
+		console.error('Error proxying live view from backend:', error);
+		return new Response('Internal Server Error', { status: 500 });
+	}
+};
@@ -1,53 +1,46 @@
 import { json } from '@sveltejs/kit';
 import bcrypt from 'bcrypt';
 import jwt from 'jsonwebtoken';
+import { loginHeaders } from '$lib/server/backend';
 const HOST_ADDRESS = process.env.HOST_ADDRESS;
 const GO_PORT = process.env.GO_PORT;
 const SERVER_KEY = process.env.SERVER_KEY;
 
 type Auth_User = {
-	id: number;
+	ID: number;
 	username: string;
-	password_hash: string;
+	passwordHash: string;
 };
 
-async function postQueryToServer(handle: string, query: string, params: string[]) {
-	const url = `http://${HOST_ADDRESS}:${GO_PORT}/${handle}`;
-	try {
-		const response = await fetch(url, {
-			method: 'POST',
-			headers: {
-				'Content-Type': 'application/json'
-			},
-			body: JSON.stringify({ query, params })
-		});
+// Returns null when there is no admin user with this username
+async function getAdminUser(username: string): Promise<Auth_User | null> {
+	const url = `http://${HOST_ADDRESS}:${GO_PORT}/getAdminUser`;
+	const response = await fetch(url, {
+		method: 'POST',
+		headers: loginHeaders(),
+		body: JSON.stringify({ username })
+	});
 
-		if (!response.ok) {
-			throw new Error(`HTTP Error: ${response.status}`);
-		}
+	if (response.status === 404) {
+		return null;
+	}
 
-		const result = await response.json();
-		return result;
-	} catch (error) {
-		console.error('Error:', error);
-		throw error;
+	if (!response.ok) {
+		throw new Error(`HTTP Error: ${response.status}`);
 	}
+
+	return (await response.json()) as Auth_User;
 }
 
 export async function POST({ request, cookies }) {
 	try {
 		const { username, password } = await request.json();
 
-		const query = `SELECT ID, username, password_hash FROM admin_users WHERE username = $1`;
-
-		const data = (await postQueryToServer('getItem', query, [username])) as Auth_User;
-
-		// Check if user exists
-		const user = data.username === username;
+		const data = await getAdminUser(username);
 
-		if (user) {
+		if (data) {
 			// Compare the provided password with the stored password hash
-			const isMatch = await bcrypt.compare(password, data.password_hash);
+			const isMatch = await bcrypt.compare(password, data.passwordHash);
 
 			if (isMatch) {
 				// Generate authentication token